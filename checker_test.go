@@ -22,3 +22,9 @@ func (errorRegistrar) CheckDomain(string) (Status, error) {
 func (errorRegistrar) RegisterDomain(string) (Status, error) {
 	return Unavailable, errors.New(errorMessage)
 }
+
+type detailedRegistrar struct{ availableRegistrar }
+
+func (detailedRegistrar) CheckDomainDetail(string) (Detail, error) {
+	return Detail{Status: Unavailable, Raw: "taken", Price: &Price{Amount: 1299, Currency: "USD", Premium: true}}, nil
+}
@@ -0,0 +1,102 @@
+// Package checkertest provides utilities for testing checker.Registrar implementations.
+package checkertest
+
+import (
+	"sync"
+	"testing"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// concurrency is the amount of goroutines used to exercise a Registrar at the same time
+const concurrency = 8
+
+// Fixture describes a domain name together with the behaviour a Registrar is expected to
+// show when it gets asked about it.
+type Fixture struct {
+	// Domain is the domain name handed to the Registrar
+	Domain string
+	// Status is the status CheckDomain is expected to report
+	Status checker.Status
+	// Err marks that CheckDomain is expected to fail for this domain
+	Err bool
+	// Register is the status RegisterDomain is expected to report, the zero value Unavailable
+	// fits domains that can not be registered
+	Register checker.Status
+}
+
+// RunConformance exercises the contracts every checker.Registrar should honour against the
+// Registrar returned by factory. The factory is called for every sub test so state does not
+// leak between them. The fixtures describe the domain names the Registrar is asked about and
+// the results it should give.
+//
+// The contracts tested are:
+//   - CheckDomain reports the status from the fixture and Unavailable alongside any error
+//   - RegisterDomain reports the status from the fixture, never Available and Unavailable
+//     alongside any error
+//   - the Registrar can be used by multiple goroutines at once, run the tests with -race
+func RunConformance(t *testing.T, factory func() checker.Registrar, fixtures ...Fixture) {
+	t.Helper()
+	if len(fixtures) == 0 {
+		t.Fatal("conformance suite requires at least one fixture")
+	}
+
+	t.Run("CheckDomain", func(t *testing.T) {
+		r := factory()
+		for _, f := range fixtures {
+			s, err := r.CheckDomain(f.Domain)
+			checkResult(t, "CheckDomain", f, s, err)
+		}
+	})
+
+	t.Run("RegisterDomain", func(t *testing.T) {
+		r := factory()
+		for _, f := range fixtures {
+			s, err := r.RegisterDomain(f.Domain)
+			if s == checker.Available {
+				t.Errorf("RegisterDomain(%s) returned Available, expected a definitive status", f.Domain)
+			}
+			if err != nil && s != checker.Unavailable {
+				t.Errorf("RegisterDomain(%s) returned status %d alongside error '%v', expected Unavailable", f.Domain, s, err)
+			}
+			if s != f.Register {
+				t.Errorf("RegisterDomain(%s) returned status %d but expected %d", f.Domain, s, f.Register)
+			}
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		r := factory()
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, f := range fixtures {
+					s, err := r.CheckDomain(f.Domain)
+					checkResult(t, "concurrent CheckDomain", f, s, err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func checkResult(t *testing.T, op string, f Fixture, s checker.Status, err error) {
+	t.Helper()
+	if err != nil {
+		if !f.Err {
+			t.Errorf("%s(%s) returned unexpected error: %v", op, f.Domain, err)
+		}
+		if s != checker.Unavailable {
+			t.Errorf("%s(%s) returned status %d alongside error '%v', expected Unavailable", op, f.Domain, s, err)
+		}
+		return
+	}
+	if f.Err {
+		t.Errorf("%s(%s) returned no error but one was expected", op, f.Domain)
+	}
+	if s != f.Status {
+		t.Errorf("%s(%s) returned status %d but expected %d", op, f.Domain, s, f.Status)
+	}
+}
@@ -1,89 +1,426 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-redis/redis"
 	checker "github.com/jaztec/domain-checker"
 )
 
 type checking struct {
-	redis      *redis.Client
-	lock       sync.RWMutex
-	domains    []string
-	registrars []checker.Registrar
-}
-
-func (c *checking) runChecks() {
-	for {
-		c.lock.RLock()
-		for _, name := range c.domains {
-			statuses, _ := checker.CheckDomain(name, c.registrars)
-			for _, s := range statuses {
-				if s.Status() == checker.Available {
-					if s, _ := checker.RegisterDomain(name, c.registrars); s.Status() == checker.Owned || s.Status() == checker.Processing {
-						log.Printf("Registered '%s' at %T", name, s.Registrar())
-					}
-					break
-				}
-			}
+	store       Store
+	registrars  []checker.Registrar
+	tracker     *tracker
+	states      *domainStates
+	dropCatcher *dropCatcher
+	filter      checker.PreFilter
+	scheduler   *scheduler
+
+	// coordinator is set when several instances share Redis, only the leader checks domains
+	// and registrations are locked
+	coordinator *coordinator
+	// leading is 1 while this instance is the leader
+	leading int32
+}
+
+// runChecks checks the domains when they are due until done is closed
+func (c *checking) runChecks(done <-chan struct{}) {
+	c.scheduler.run(done)
+}
+
+// check checks a single domain, registers it when it is available and returns when it should
+// be checked again. The zero time leaves that to the interval of the domain.
+func (c *checking) check(name string, now time.Time) (due time.Time) {
+	if c.dropCatcher != nil {
+		defer func() {
+			due = c.dropCatcher.next(name, now)
+		}()
+	}
+	previous := c.states.state(name)
+	switch previous {
+	case statePaused, stateOwned:
+		return
+	}
+	if c.tracker.isPending(name) {
+		return
+	}
+	if previous == stateProcessing {
+		// no registration is followed up on, like when its record was lost, so the domain
+		// is checked like any other failed registration
+		c.states.move(name, stateFailed, "", "the registration is no longer followed up on")
+	}
+
+	// registered domains are recognised without spending registrar calls on them, when
+	// the filter is not sure the registrars are consulted
+	if c.filter != nil {
+		registered, err := c.filter.Registered(name)
+		if err != nil {
+			log.Printf("Pre-filter failed for '%s': %v", name, err)
+		}
+		if registered {
+			c.states.checked(name, checker.Taken, "pre-filter", now)
+			c.states.move(name, stateWatching, "pre-filter", "delegated in DNS")
+			return
+		}
+	}
+
+	burst := c.dropCatcher != nil && c.dropCatcher.inWindow(name, now)
+	if burst {
+		c.dropCatcher.wait()
+	}
+	statuses, err := checker.CheckDomain(name, c.registrars)
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	result := checkResult(statuses)
+	if result == nil {
+		return
+	}
+	registrar := checker.RegistrarName(result.Registrar())
+	c.states.checked(name, result.Status(), registrar, now)
+
+	switch result.Status() {
+	case checker.Available:
+		c.states.move(name, stateAvailable, registrar, "")
+	case checker.Owned:
+		c.states.move(name, stateOwned, registrar, "reported owned")
+		return
+	default:
+		c.states.move(name, stateWatching, registrar, "no longer available")
+		return
+	}
+
+	opts := c.states.options(name)
+	if opts.notifyOnly() {
+		if previous != stateAvailable {
+			log.Printf("NOTIFY: '%s' is available at %s", name, registrar)
+		}
+		return
+	}
+	registrars := opts.registrars(c.registrars, statuses)
+	if len(registrars) == 0 {
+		log.Printf("Not registering '%s', no registrar is within its maximum price", name)
+		return
+	}
+
+	// another instance may be registering the domain already
+	var lock *lease
+	var fence int64
+	reason := ""
+	if c.coordinator != nil {
+		if lock, err = c.coordinator.lockDomain(name); err != nil {
+			log.Printf("Not registering '%s': %v", name, err)
+			return
 		}
-		c.lock.RUnlock()
+		defer lock.release()
+		fence = lock.token
+		reason = fmt.Sprintf("fencing token %d", fence)
+		c.states.fence(name, fence)
+		defer c.states.fence(name, 0)
+	}
 
-		time.Sleep(60 * time.Second)
+	if burst {
+		c.dropCatcher.wait()
+	}
+	// the domain may have been paused during the check
+	if err := c.states.transition(name, stateRegistering, "", reason); err != nil {
+		log.Printf("Not registering '%s': %v", name, err)
+		return
+	}
+	s, err := checker.RegisterDomain(name, registrars)
+	if err != nil {
+		log.Printf("%v", err)
 	}
+	// the lock may have expired during the registration and another instance may have taken
+	// over, the fencing token keeps the records of that instance from being overwritten
+	lost := lock != nil && !lock.held()
+	if lost {
+		log.Printf("ESCALATION: lost the registration lock of '%s' (fencing token %d) while registering", name, fence)
+	}
+	switch {
+	case lost && s.Registrar() != nil:
+		// the registrar took the registration, the tracker finds out what became of it
+		c.states.move(name, stateProcessing, checker.RegistrarName(s.Registrar()), "registration lock lost, the outcome is unknown")
+		c.tracker.track(name, s.Registrar(), fence)
+	case s.Status() == checker.Owned:
+		log.Printf("Registered '%s' at %s", name, checker.RegistrarName(s.Registrar()))
+		c.states.move(name, stateOwned, checker.RegistrarName(s.Registrar()), "registered")
+	case s.Status() == checker.Processing:
+		log.Printf("Registered '%s' at %s", name, checker.RegistrarName(s.Registrar()))
+		c.states.move(name, stateProcessing, checker.RegistrarName(s.Registrar()), "")
+		c.tracker.track(name, s.Registrar(), fence)
+	default:
+		reason := "no registrar registered the domain"
+		if err != nil {
+			reason = err.Error()
+		}
+		c.states.move(name, stateFailed, "", reason)
+	}
+	return
 }
 
-func (c *checking) findDomain(name string) int {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	for i, d := range c.domains {
-		if name == d {
-			return i
+// checkResult picks the status that decides what happens to the domain: the first registrar
+// reporting it available, otherwise the first one reporting it owned, otherwise the first one
+func checkResult(statuses []checker.RegistrarStatus) *checker.RegistrarStatus {
+	var result *checker.RegistrarStatus
+	for i := range statuses {
+		switch {
+		case statuses[i].Status() == checker.Available:
+			return &statuses[i]
+		case result == nil || statuses[i].Status() == checker.Owned && result.Status() != checker.Owned:
+			result = &statuses[i]
 		}
 	}
-	return -1
+	return result
 }
 
-func (c *checking) addDomain(name string) {
-	if c.findDomain(name) == -1 {
-		c.domains = append(c.domains, name)
+// addDomain starts checking the domain with the options, they hold its interval and jitter. A
+// standby only adds new domains, the leader would overwrite changes to a watched domain.
+func (c *checking) addDomain(name string, opts watchOptions) error {
+	if c.standingBy() {
+		domains, err := c.store.Domains()
+		if err != nil {
+			return err
+		}
+		for _, d := range domains {
+			if d == name {
+				return c.notLeading()
+			}
+		}
 	}
-	c.persistRedis()
+	// the store only keeps the records of watched domains, so the domain goes first
+	if c.store != nil {
+		if err := c.store.AddDomain(name); err != nil {
+			log.Printf("Could not persist domain \"%s\": %v", name, err)
+		}
+	}
+	c.schedule(name, opts)
+	c.states.add(name)
+	c.states.setOptions(name, opts)
 	log.Printf("Added domain \"%s\"", name)
+	return nil
+}
+
+// schedule checks the domain on the interval and with the priority of its options
+func (c *checking) schedule(name string, opts watchOptions) {
+	interval, jitter := opts.schedule()
+	c.scheduler.add(name, interval, jitter)
+	c.scheduler.prioritize(name, opts.Priority)
 }
 
 func (c *checking) removeDomain(name string) {
-	if i := c.findDomain(name); i != -1 {
-		d := c.domains
-		c.domains = d[:i+copy(d[i:], d[i+1:])]
+	c.scheduler.remove(name)
+	c.states.forget(name)
+	c.tracker.forget(name)
+	if c.dropCatcher != nil {
+		c.dropCatcher.forget(name)
+	}
+	if c.store != nil {
+		if err := c.store.RemoveDomain(name); err != nil {
+			log.Printf("Could not remove domain \"%s\" from the store: %v", name, err)
+		}
 	}
-	c.persistRedis()
 	log.Printf("Removed domain \"%s\"", name)
 }
 
 func (c *checking) listDomains() []string {
-	return c.domains
+	return c.watched()
+}
+
+// pauseDomain stops checking the domain until it is resumed
+func (c *checking) pauseDomain(name string) error {
+	if c.standingBy() {
+		return c.notLeading()
+	}
+	if err := c.states.transition(name, statePaused, "", "paused"); err != nil {
+		return err
+	}
+	log.Printf("Paused domain \"%s\"", name)
+	return nil
+}
+
+// resumeDomain starts checking a paused, owned or processing domain again
+func (c *checking) resumeDomain(name string) error {
+	if c.standingBy() {
+		return c.notLeading()
+	}
+	if err := c.states.transition(name, stateWatching, "", "resumed"); err != nil {
+		return err
+	}
+	log.Printf("Resumed domain \"%s\"", name)
+	return nil
+}
+
+// domainState returns the state of a watched domain
+func (c *checking) domainState(name string) (watchedDomain, bool) {
+	c.refresh()
+	return c.states.get(name)
+}
+
+// domainDetails returns the state and options of the watched domains in alphabetical order
+func (c *checking) domainDetails() []watchedDomain {
+	names := c.watched()
+	details := make([]watchedDomain, 0, len(names))
+	for _, name := range names {
+		if d, ok := c.states.get(name); ok {
+			details = append(details, d)
+		}
+	}
+	return details
+}
+
+// standingBy reports whether another instance is the leader. The leader writes through to
+// the store, so a standby reads from the store instead of serving what it remembers.
+func (c *checking) standingBy() bool {
+	return c.coordinator != nil && atomic.LoadInt32(&c.leading) == 0
+}
+
+// notLeading returns the error for changes a standby refuses, it names the leader to send
+// them to instead
+func (c *checking) notLeading() error {
+	leader, err := c.coordinator.leader()
+	switch {
+	case err != nil:
+		return fmt.Errorf("this instance stands by and could not find the leader: %w", err)
+	case leader == "":
+		return errors.New("this instance stands by and no instance leads, try again shortly")
+	}
+	return fmt.Errorf("this instance stands by, send this to the leader %s", leader)
+}
+
+// refresh reads the states the leader stored when this instance stands by
+func (c *checking) refresh() {
+	if !c.standingBy() {
+		return
+	}
+	if err := c.states.refresh(); err != nil {
+		log.Printf("Could not refresh domain states: %v", err)
+	}
+}
+
+// watched returns the watched domains in alphabetical order, a standby reads them and their
+// states from the store
+func (c *checking) watched() []string {
+	if !c.standingBy() {
+		return c.scheduler.list()
+	}
+	c.refresh()
+	domains, err := c.store.Domains()
+	if err != nil {
+		log.Printf("Could not read domains: %v", err)
+		return c.scheduler.list()
+	}
+	return domains
+}
+
+// lead checks the domains and follows up on registrations until stop is closed, it is run
+// by the leader. It starts from the states the previous leader stored and picks up domains
+// added or removed at other instances every interval.
+func (c *checking) lead(stop <-chan struct{}, interval time.Duration) {
+	atomic.StoreInt32(&c.leading, 1)
+	defer atomic.StoreInt32(&c.leading, 0)
+	if err := c.states.load(); err != nil {
+		log.Printf("Could not load domain states: %v", err)
+	}
+	if err := c.sync(); err != nil {
+		log.Printf("Could not sync domains: %v", err)
+	}
+	if err := c.tracker.load(); err != nil {
+		log.Printf("Could not load pending registrations: %v", err)
+	}
+	go c.tracker.run(stop)
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				if err := c.sync(); err != nil {
+					log.Printf("Could not sync domains: %v", err)
+				}
+			}
+		}
+	}()
+	c.runChecks(stop)
 }
 
-func (c *checking) persistRedis() {
-	if c.redis != nil {
-		c.lock.Lock()
-		defer c.lock.Unlock()
-		pipe := c.redis.Pipeline()
-		pipe.Del(RedisListKey)
-		for _, d := range c.domains {
-			pipe.LPush(RedisListKey, d)
+// sync brings the scheduled domains in line with the store
+func (c *checking) sync() error {
+	if c.store == nil {
+		return nil
+	}
+	domains, err := c.store.Domains()
+	if err != nil {
+		return err
+	}
+	scheduled := make(map[string]bool)
+	for _, name := range c.scheduler.list() {
+		scheduled[name] = true
+	}
+	stored := make(map[string]bool, len(domains))
+	var added []string
+	for _, name := range domains {
+		stored[name] = true
+		if !scheduled[name] {
+			added = append(added, name)
 		}
-		pipe.Exec()
 	}
+
+	if len(added) > 0 {
+		if err := c.states.restore(added); err != nil {
+			return err
+		}
+	}
+	for _, name := range added {
+		c.schedule(name, c.states.options(name))
+		log.Printf("Picked up domain \"%s\"", name)
+	}
+	for name := range scheduled {
+		if !stored[name] {
+			c.scheduler.remove(name)
+			c.states.forget(name)
+			if c.dropCatcher != nil {
+				c.dropCatcher.forget(name)
+			}
+			log.Printf("Dropped domain \"%s\"", name)
+		}
+	}
+	return nil
 }
 
-func newChecking(domains []string, clients []checker.Registrar, r *redis.Client) *checking {
-	return &checking{
-		redis:   r,
-		domains: domains,
+// persist stores every domain being checked, it is used on shutdown
+func (c *checking) persist() error {
+	if c.store == nil {
+		return nil
+	}
+	for _, name := range c.scheduler.list() {
+		if err := c.store.AddDomain(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newChecking returns checks for the domains, run by the amount of workers. Domains are checked
+// every interval with a random delay of at most jitter, unless drop catching decides otherwise.
+func newChecking(domains []string, clients []checker.Registrar, store Store, t *tracker, s *domainStates, d *dropCatcher, f checker.PreFilter, workers int, interval, jitter time.Duration) *checking {
+	c := &checking{
+		store:       store,
+		registrars:  clients,
+		tracker:     t,
+		states:      s,
+		dropCatcher: d,
+		filter:      f,
+	}
+	c.scheduler = newScheduler(c.check, workers, interval, jitter)
+	for _, name := range domains {
+		c.states.add(name)
+		c.schedule(name, c.states.options(name))
 	}
+	return c
 }
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Keys within Redis used to coordinate daemon instances
+const (
+	// RedisFenceKey is the counter handing out fencing tokens
+	RedisFenceKey = "checker:fence"
+	// RedisLockPrefix starts the key of the registration lock of a domain
+	RedisLockPrefix = "checker:lock:"
+	// RedisLeaderKey is held by the instance driving the checks
+	RedisLeaderKey = "checker:leader"
+)
+
+// errLocked is returned when another instance holds a lease
+var errLocked = errors.New("held by another instance")
+
+// lease is a key in Redis held by this instance until it expires. It is renewed in the
+// background until it is released. Every lease gets a fencing token that is higher than the
+// token of any lease taken before it.
+type lease struct {
+	client *redis.Client
+	key    string
+	value  string
+	ttl    time.Duration
+	token  int64
+
+	// stop is closed on release, lost when renewing failed and done when renewing stopped
+	stop chan struct{}
+	lost chan struct{}
+	done chan struct{}
+}
+
+// held reports whether this instance still holds the lease
+func (l *lease) held() bool {
+	select {
+	case <-l.lost:
+		return false
+	default:
+	}
+	v, err := l.client.Get(l.key).Result()
+	return err == nil && v == l.value
+}
+
+// release stops renewing and gives up the lease when this instance still holds it
+func (l *lease) release() {
+	close(l.stop)
+	<-l.done
+	if _, err := l.ifHeld(func(pipe redis.Pipeliner) { pipe.Del(l.key) }); err != nil {
+		log.Printf("Could not release '%s': %v", l.key, err)
+	}
+}
+
+func (l *lease) renew() {
+	defer close(l.done)
+	tick := time.NewTicker(l.ttl / 3)
+	defer tick.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+			held, err := l.ifHeld(func(pipe redis.Pipeliner) { pipe.PExpire(l.key, l.ttl) })
+			if err != nil || !held {
+				if err != nil {
+					log.Printf("Could not renew '%s': %v", l.key, err)
+				}
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+// ifHeld runs the commands in a transaction when this instance still holds the lease
+func (l *lease) ifHeld(fn func(redis.Pipeliner)) (bool, error) {
+	held := false
+	err := l.client.Watch(func(tx *redis.Tx) error {
+		v, err := tx.Get(l.key).Result()
+		if err == redis.Nil || err == nil && v != l.value {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			fn(pipe)
+			return nil
+		})
+		held = err == nil
+		return err
+	}, l.key)
+	return held, err
+}
+
+// coordinator lets several daemon instances sharing Redis work together: only the leader
+// drives the checks and a domain is registered by one instance at a time
+type coordinator struct {
+	client *redis.Client
+	id     string
+	ttl    time.Duration
+}
+
+// acquire takes the lease on key, it returns errLocked when another instance holds it
+func (c *coordinator) acquire(key string) (*lease, error) {
+	token, err := c.client.Incr(RedisFenceKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get a fencing token: %w", err)
+	}
+	value := fmt.Sprintf("%s:%d", c.id, token)
+	ok, err := c.client.SetNX(key, value, c.ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not take '%s': %w", key, err)
+	}
+	if !ok {
+		return nil, errLocked
+	}
+	l := &lease{
+		client: c.client,
+		key:    key,
+		value:  value,
+		ttl:    c.ttl,
+		token:  token,
+		stop:   make(chan struct{}),
+		lost:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go l.renew()
+	return l, nil
+}
+
+// lockDomain takes the registration lock of the domain
+func (c *coordinator) lockDomain(name string) (*lease, error) {
+	l, err := c.acquire(RedisLockPrefix + name)
+	if err == errLocked {
+		return nil, fmt.Errorf("registration lock of '%s' is %w", name, err)
+	}
+	return l, err
+}
+
+// lead runs lead while this instance is the leader until done is closed. Instances that are
+// not the leader stand by and try to take over every third of the TTL. lead has to return
+// once its stop channel is closed, which happens when the leadership is lost.
+func (c *coordinator) lead(done <-chan struct{}, lead func(stop <-chan struct{})) {
+	retry := time.NewTicker(c.ttl / 3)
+	defer retry.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		l, err := c.acquire(RedisLeaderKey)
+		switch {
+		case err == nil:
+			log.Printf("Instance %s is the leader (fencing token %d)", c.id, l.token)
+			stop, finished := make(chan struct{}), make(chan struct{})
+			go func() {
+				lead(stop)
+				close(finished)
+			}()
+			select {
+			case <-done:
+			case <-l.lost:
+				log.Printf("Instance %s lost the leadership, standing by", c.id)
+			}
+			close(stop)
+			<-finished
+			l.release()
+		case err != errLocked:
+			log.Printf("Leader election failed: %v", err)
+		}
+
+		select {
+		case <-done:
+		case <-retry.C:
+		}
+	}
+}
+
+// leader returns the ID of the instance that leads, it is empty when no instance does
+func (c *coordinator) leader() (string, error) {
+	v, err := c.client.Get(RedisLeaderKey).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// the value is the ID followed by the fencing token
+	if i := strings.LastIndex(v, ":"); i >= 0 {
+		v = v[:i]
+	}
+	return v, nil
+}
+
+// instanceID names this instance in the locks it holds, INSTANCE_ID overrides it
+func instanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "checker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func newCoordinator(client *redis.Client, id string, ttl time.Duration) *coordinator {
+	return &coordinator{client: client, id: id, ttl: ttl}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaztec/domain-checker"
+)
+
+func TestCoordinatorDomainLock(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	a, b := newCoordinator(client, "a", time.Minute), newCoordinator(client, "b", time.Minute)
+	first, err := a.lockDomain("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.lockDomain("example.org"); err == nil {
+		t.Fatal("Expected the lock to be held by the other instance")
+	}
+	if !first.held() {
+		t.Error("Expected the lock to be held")
+	}
+
+	first.release()
+	second, err := b.lockDomain("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.release()
+	if second.token <= first.token {
+		t.Errorf("Expected the fencing token to increase, got %d after %d", second.token, first.token)
+	}
+	if first.held() {
+		t.Error("Expected the released lock not to be held")
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	l, err := newCoordinator(client, "a", 30*time.Millisecond).lockDomain("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.release()
+
+	// another instance took over after the lock expired
+	standIn.set(RedisLockPrefix+"example.org", "b:99")
+	waitFor(t, "the lease to be lost", func() bool {
+		select {
+		case <-l.lost:
+			return true
+		default:
+			return false
+		}
+	})
+	if l.held() {
+		t.Error("Expected a lost lease not to be held")
+	}
+}
+
+func TestCoordinatorLeaderFailover(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	var lock sync.Mutex
+	var leaders []string
+	start := func(id string, done chan struct{}) chan struct{} {
+		finished := make(chan struct{})
+		go func() {
+			newCoordinator(client, id, 60*time.Millisecond).lead(done, func(stop <-chan struct{}) {
+				lock.Lock()
+				leaders = append(leaders, id)
+				lock.Unlock()
+				<-stop
+			})
+			close(finished)
+		}()
+		return finished
+	}
+	leading := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), leaders...)
+	}
+
+	doneA, doneB := make(chan struct{}), make(chan struct{})
+	finishedA := start("a", doneA)
+	waitFor(t, "a to lead", func() bool { return len(leading()) == 1 })
+	finishedB := start("b", doneB)
+	time.Sleep(100 * time.Millisecond)
+	if l := leading(); len(l) != 1 || l[0] != "a" {
+		t.Fatalf("Expected only a to lead, got %v", l)
+	}
+
+	close(doneA)
+	<-finishedA
+	waitFor(t, "b to take over", func() bool { return len(leading()) == 2 })
+	if l := leading(); l[1] != "b" {
+		t.Errorf("Expected b to take over, got %v", l)
+	}
+	close(doneB)
+	<-finishedB
+	if keys := standIn.keys(); len(keys) != 1 || keys[0] != RedisFenceKey {
+		t.Errorf("Expected the leadership to be released, got %v", keys)
+	}
+}
+
+func TestCheckingRegistrationLocked(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	other, err := newCoordinator(client, "b", time.Minute).lockDomain("example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &scriptedRegistrar{check: checker.Available, register: checker.Processing}
+	states := newDomainStates(nil, 10)
+	tr := newTracker([]checker.Registrar{r}, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking([]string{"example.org"}, []checker.Registrar{r}, nil, tr, states, nil, nil, 1, time.Hour, 0)
+	c.coordinator = newCoordinator(client, "a", time.Minute)
+
+	c.check("example.org", time.Now())
+	d, _ := c.domainState("example.org")
+	expectStates(t, d, stateWatching, stateAvailable)
+
+	other.release()
+	c.check("example.org", time.Now())
+	d, _ = c.domainState("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateProcessing)
+	if p := tr.pending["example.org"]; p == nil || p.Fence == 0 {
+		t.Errorf("Expected the fencing token to be tracked, got %+v", p)
+	}
+}
+
+func TestStandbyServesStoredStates(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	store, err := newRedisStore(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDomain("example.org"); err != nil {
+		t.Fatal(err)
+	}
+	instance := func(id string) (*checking, *tracker) {
+		r := &scriptedRegistrar{check: checker.Owned}
+		states := newDomainStates(store, 10)
+		tr := newTracker([]checker.Registrar{r}, store, states, time.Minute, time.Hour, 2*time.Hour)
+		c := newChecking([]string{"example.org"}, []checker.Registrar{r}, store, tr, states, nil, nil, 1, time.Hour, 0)
+		c.coordinator = newCoordinator(client, id, time.Minute)
+		return c, tr
+	}
+	leader, _ := instance("a")
+	standby, tr := instance("b")
+	leader.leading = 1
+
+	leader.check("example.org", time.Now())
+	leader.addDomain("example.net", watchOptions{})
+
+	// the standby remembers example.org watching, shutting it down leaves the store alone
+	s, err := newServer("0", "secret", standby, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksDone := make(chan struct{})
+	close(checksDone)
+	if code := shutdown(s, standby, tr, nil, make(chan struct{}), checksDone, time.Second); code != exitOK {
+		t.Errorf("Expected a clean shutdown, got exit code %d", code)
+	}
+	stored := newDomainStates(store, 10)
+	if err := stored.load(); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := stored.get("example.org")
+	expectStates(t, d, stateWatching, stateOwned)
+
+	d, _ = standby.domainState("example.org")
+	expectStates(t, d, stateWatching, stateOwned)
+	if l := standby.listDomains(); len(l) != 2 || l[0] != "example.net" || l[1] != "example.org" {
+		t.Errorf("Expected the standby to list the stored domains, got %v", l)
+	}
+
+	// changes to watched domains are refused by a standby, the leader would overwrite them
+	if err := standby.pauseDomain("example.org"); err == nil || !strings.Contains(err.Error(), "no instance leads") {
+		t.Errorf("Expected a standby to refuse a pause without a leader, got '%v'", err)
+	}
+	client.Set(RedisLeaderKey, "a:7", 0)
+	for name, change := range map[string]func() error{
+		"pause":  func() error { return standby.pauseDomain("example.org") },
+		"resume": func() error { return standby.resumeDomain("example.org") },
+		"add":    func() error { return standby.addDomain("example.org", watchOptions{Mode: watchNotify}) },
+	} {
+		if err := change(); err == nil || !strings.Contains(err.Error(), "leader a") {
+			t.Errorf("Expected the standby to refuse the %s naming the leader, got '%v'", name, err)
+		}
+	}
+	if err := standby.addDomain("example.com", watchOptions{Mode: watchNotify}); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if o := leader.states.options("example.com"); !o.notifyOnly() {
+		t.Errorf("Expected the leader to pick up a domain added at the standby with its options, got %+v", o)
+	}
+	if o := leader.states.options("example.org"); o.notifyOnly() {
+		t.Errorf("Expected the options of a watched domain to be left alone, got %+v", o)
+	}
+}
+
+// takeoverRegistrar lets another instance take over the registration lock while registering
+type takeoverRegistrar struct {
+	scriptedRegistrar
+	takeover func()
+}
+
+func (r *takeoverRegistrar) RegisterDomain(name string) (checker.Status, error) {
+	r.takeover()
+	return r.scriptedRegistrar.RegisterDomain(name)
+}
+
+func TestCheckingRegistrationLockLost(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	store, err := newRedisStore(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDomain("example.org"); err != nil {
+		t.Fatal(err)
+	}
+	taken := []byte(`{"domain":"example.org","state":"owned"}`)
+	r := &takeoverRegistrar{scriptedRegistrar: scriptedRegistrar{check: checker.Available, register: checker.Owned}}
+	r.takeover = func() {
+		// the lock expired and another instance registered the domain with a later token
+		standIn.set(RedisLockPrefix+"example.org", "b:99")
+		if err := store.SaveFenced(bucketStates, "example.org", taken, 99); err != nil {
+			t.Error(err)
+		}
+	}
+	states := newDomainStates(store, 10)
+	tr := newTracker([]checker.Registrar{r}, store, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking([]string{"example.org"}, []checker.Registrar{r}, store, tr, states, nil, nil, 1, time.Hour, 0)
+	c.coordinator = newCoordinator(client, "a", time.Minute)
+
+	c.check("example.org", time.Now())
+	d, _ := states.get("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateProcessing)
+	if !tr.isPending("example.org") {
+		t.Error("Expected the tracker to follow up on the registration")
+	}
+	if m, _ := store.Load(bucketStates); string(m["example.org"]) != string(taken) {
+		t.Errorf("Expected the state of the later lock holder to be kept, got %s", m["example.org"])
+	}
+	if m, _ := store.Load(bucketPending); len(m) != 0 {
+		t.Errorf("Expected the fenced off registration not to be stored, got %v", m)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// dropState is the predicted drop window of a single domain, ok is false when no drop
+// is expected
+type dropState struct {
+	window checker.DropWindow
+	ok     bool
+}
+
+func (s *dropState) inWindow(t time.Time) bool {
+	return s.ok && s.window.Contains(t)
+}
+
+// dropCatcher decides when domains need to be checked based on their lifecycle. Outside
+// of the predicted drop window a domain is checked once every idle interval, inside the
+// window it is checked every burst interval.
+type dropCatcher struct {
+	readers []checker.LifecycleReader
+	idle    time.Duration
+	burst   time.Duration
+	limit   *time.Ticker
+
+	lock   sync.Mutex
+	states map[string]*dropState
+}
+
+// next returns when the domain should be checked again
+func (d *dropCatcher) next(name string, now time.Time) time.Time {
+	d.lock.Lock()
+	st, ok := d.states[name]
+	d.lock.Unlock()
+
+	// inside the window the prediction is trusted, outside of it the lifecycle is read
+	// again as the domain may have moved on
+	if !ok || !st.inWindow(now) {
+		st = d.read(name, now)
+		d.lock.Lock()
+		d.states[name] = st
+		d.lock.Unlock()
+	}
+
+	if st.inWindow(now) {
+		return now.Add(d.burst)
+	}
+	next := now.Add(d.idle)
+	if st.ok && st.window.Start.After(now) && st.window.Start.Before(next) {
+		next = st.window.Start
+	}
+	return next
+}
+
+// inWindow reports whether the domain is within its predicted drop window
+func (d *dropCatcher) inWindow(name string, now time.Time) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	st, ok := d.states[name]
+	return ok && st.inWindow(now)
+}
+
+// wait blocks until the rate limit allows another registrar call during a burst
+func (d *dropCatcher) wait() {
+	<-d.limit.C
+}
+
+// forget removes what is known about a domain
+func (d *dropCatcher) forget(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.states, name)
+}
+
+func (d *dropCatcher) read(name string, now time.Time) *dropState {
+	for _, r := range d.readers {
+		l, err := r.Lifecycle(name)
+		if err != nil {
+			log.Printf("Could not read lifecycle of '%s' at %s: %v", name, checker.RegistrarName(r.(checker.Registrar)), err)
+			continue
+		}
+		// a reader that knows too little to predict a drop does not rule out the others
+		w, ok := checker.PredictDrop(l, now)
+		if !ok {
+			continue
+		}
+		log.Printf("Predicted drop of '%s' between %s and %s", name, w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+		return &dropState{w, true}
+	}
+	return &dropState{}
+}
+
+// newDropCatcher returns a drop catcher using the registrars that can read lifecycles, rate
+// limits the registrar calls during bursts to one per limit.
+func newDropCatcher(clients []checker.Registrar, idle, burst, limit time.Duration) *dropCatcher {
+	d := &dropCatcher{
+		idle:   idle,
+		burst:  burst,
+		limit:  time.NewTicker(limit),
+		states: make(map[string]*dropState),
+	}
+	for _, c := range clients {
+		if r, ok := c.(checker.LifecycleReader); ok {
+			d.readers = append(d.readers, r)
+		}
+	}
+	return d
+}
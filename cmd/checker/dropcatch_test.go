@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// lifecycleRegistrar reports the same lifecycle for every domain
+type lifecycleRegistrar struct {
+	lifecycle checker.Lifecycle
+}
+
+func (lifecycleRegistrar) CheckDomain(string) (checker.Status, error) {
+	return checker.Unavailable, nil
+}
+func (lifecycleRegistrar) RegisterDomain(string) (checker.Status, error) {
+	return checker.Unavailable, nil
+}
+func (l *lifecycleRegistrar) Lifecycle(string) (checker.Lifecycle, error) {
+	return l.lifecycle, nil
+}
+
+func TestDropCatcherNext(t *testing.T) {
+	now := time.Now()
+	r := &lifecycleRegistrar{checker.Lifecycle{Statuses: []string{"ok"}, Expires: now.Add(time.Hour)}}
+	d := newDropCatcher([]checker.Registrar{r}, 6*time.Hour, 5*time.Second, time.Millisecond)
+
+	if next := d.next("example.org", now); !next.Equal(now.Add(6 * time.Hour)) {
+		t.Errorf("Expected an active domain to be checked after the idle interval, got %s", next.Sub(now))
+	}
+
+	r.lifecycle = checker.Lifecycle{Statuses: []string{checker.StatusCodePendingDelete}, Changed: now.Add(-5 * 24 * time.Hour)}
+	if next := d.next("example.org", now); !next.Equal(now.Add(5 * time.Second)) {
+		t.Errorf("Expected a dropping domain to be checked after the burst interval, got %s", next.Sub(now))
+	}
+	if !d.inWindow("example.org", now) {
+		t.Error("Expected the domain to be inside its drop window")
+	}
+
+	r.lifecycle = checker.Lifecycle{Statuses: []string{checker.StatusCodePendingDelete}, Changed: now.Add(-5*24*time.Hour + 15*time.Hour)}
+	d.forget("example.org")
+	if next := d.next("example.org", now); !next.Equal(now.Add(3 * time.Hour)) {
+		t.Errorf("Expected the domain to be checked at the start of its drop window, got %s", next.Sub(now))
+	}
+}
+
+func TestDropCatcherAsksEveryReader(t *testing.T) {
+	now := time.Now()
+	empty := &lifecycleRegistrar{}
+	dropping := &lifecycleRegistrar{checker.Lifecycle{Statuses: []string{checker.StatusCodePendingDelete}, Changed: now.Add(-5 * 24 * time.Hour)}}
+	d := newDropCatcher([]checker.Registrar{empty, dropping}, 6*time.Hour, 5*time.Second, time.Millisecond)
+
+	if next := d.next("example.org", now); !next.Equal(now.Add(5 * time.Second)) {
+		t.Errorf("Expected the prediction of the second reader to be used, got %s", next.Sub(now))
+	}
+}
@@ -2,21 +2,23 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-redis/redis"
 	checker "github.com/jaztec/domain-checker"
 	"github.com/jaztec/domain-checker/internal"
 )
 
-// RedisListKey defines the key within Redis that is used for
-// caching the domain name list.
-const RedisListKey = "checker_domain_list"
-
 func startRedis(dsn, password string, db int) (*redis.Client, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     dsn,
@@ -35,50 +37,370 @@ func startRedis(dsn, password string, db int) (*redis.Client, error) {
 	return client, nil
 }
 
+// loadStore opens the store the state of the daemon is kept in, a file when STORE_FILE is set
+// and Redis otherwise. If no Redis connection can be established the program will continue
+// without persistent storage.
+func loadStore() Store {
+	if path := os.Getenv("STORE_FILE"); path != "" {
+		s, err := openFileStore(path)
+		if err != nil {
+			panic(fmt.Errorf("error while opening store file: %w", err))
+		}
+		return s
+	}
+
+	dsn := os.Getenv("REDIS_DSN")
+	password := os.Getenv("REDIS_PASSWORD")
+	db, err := strconv.Atoi(os.Getenv("REDIS_DB"))
+	if err != nil {
+		log.Printf("%v\n", fmt.Errorf("error while loading Redis db variable: %w", err))
+		return nil
+	}
+	r, err := startRedis(dsn, password, db)
+	if err != nil {
+		log.Printf("%v\n", (fmt.Errorf("error while conecting to Redis: %w", err)))
+		return nil
+	}
+	s, err := newRedisStore(r)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
 func loadClients() []checker.Registrar {
 	c := make([]checker.Registrar, 0, 5)
 
 	transIPName := os.Getenv("TRANSIP_ACCOUNT_NAME")
 	transIPKey := os.Getenv("TRANSIP_KEY_FILE_PATH")
-	if transIPName != "" && transIPKey != "" {
-		internal.NewTransIP(transIPName, transIPKey)
-		c = append(c)
+	transIPKeyContents := os.Getenv("TRANSIP_PRIVATE_KEY")
+	if transIPName != "" && (transIPKey != "" || transIPKeyContents != "") {
+		t, err := internal.NewTransIPWithConfig(internal.TransIPConfig{
+			AccountName:    transIPName,
+			PrivateKeyPath: transIPKey,
+			PrivateKey:     transIPKeyContents,
+			Endpoint:       os.Getenv("TRANSIP_ENDPOINT"),
+			API:            os.Getenv("TRANSIP_API"),
+			TLDCacheTTL:    durationEnv("TRANSIP_TLD_CACHE_TTL", 24*time.Hour),
+			ReadOnly:       os.Getenv("TRANSIP_READ_ONLY") == "true",
+			GlobalKey:      os.Getenv("TRANSIP_GLOBAL_KEY") == "true",
+		})
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading TransIP registrar: %w", err))
+		} else {
+			c = append(c, t)
+		}
+	}
+
+	// RDAP needs no account, it only adds availability and lifecycle information
+	if os.Getenv("RDAP_ENABLED") == "true" {
+		r, err := internal.NewRDAP(internal.RDAPConfig{BootstrapURL: os.Getenv("RDAP_BOOTSTRAP_URL")})
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading RDAP registrar: %w", err))
+		} else {
+			c = append(c, r)
+		}
+	}
+
+	// WHOIS covers the registries that have no RDAP service yet
+	if os.Getenv("WHOIS_ENABLED") == "true" {
+		w, err := internal.NewWhois(internal.WhoisConfig{Servers: mapEnv("WHOIS_SERVERS")})
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading WHOIS registrar: %w", err))
+		} else {
+			c = append(c, w)
+		}
+	}
+
+	if ncUser := os.Getenv("NAMECHEAP_API_USER"); ncUser != "" {
+		n, err := internal.NewNamecheap(internal.NamecheapConfig{
+			APIUser:      ncUser,
+			APIKey:       os.Getenv("NAMECHEAP_API_KEY"),
+			UserName:     os.Getenv("NAMECHEAP_USER_NAME"),
+			ClientIP:     os.Getenv("NAMECHEAP_CLIENT_IP"),
+			Sandbox:      os.Getenv("NAMECHEAP_SANDBOX") == "true",
+			AllowPremium: os.Getenv("NAMECHEAP_ALLOW_PREMIUM") == "true",
+			Registrant: internal.NamecheapContact{
+				FirstName:        os.Getenv("NAMECHEAP_FIRST_NAME"),
+				LastName:         os.Getenv("NAMECHEAP_LAST_NAME"),
+				OrganizationName: os.Getenv("NAMECHEAP_ORGANIZATION"),
+				Address1:         os.Getenv("NAMECHEAP_ADDRESS"),
+				City:             os.Getenv("NAMECHEAP_CITY"),
+				StateProvince:    os.Getenv("NAMECHEAP_STATE_PROVINCE"),
+				PostalCode:       os.Getenv("NAMECHEAP_POSTAL_CODE"),
+				Country:          os.Getenv("NAMECHEAP_COUNTRY"),
+				Phone:            os.Getenv("NAMECHEAP_PHONE"),
+				EmailAddress:     os.Getenv("NAMECHEAP_EMAIL"),
+			},
+		})
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading Namecheap registrar: %w", err))
+		} else {
+			c = append(c, n)
+		}
+	}
+
+	if pbKey := os.Getenv("PORKBUN_API_KEY"); pbKey != "" {
+		p, err := internal.NewPorkbun(internal.PorkbunConfig{
+			APIKey:       pbKey,
+			SecretAPIKey: os.Getenv("PORKBUN_SECRET_API_KEY"),
+			AllowPremium: os.Getenv("PORKBUN_ALLOW_PREMIUM") == "true",
+		})
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading Porkbun registrar: %w", err))
+		} else {
+			c = append(c, p)
+		}
+	}
+
+	if ovhKey := os.Getenv("OVH_APPLICATION_KEY"); ovhKey != "" {
+		var maxPrice int64
+		switch v := os.Getenv("OVH_MAX_AUTOPAY_PRICE"); v {
+		case "":
+		case "unlimited":
+			maxPrice = internal.OVHNoAutoPayLimit
+		default:
+			p, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || p <= 0 {
+				panic(fmt.Errorf("invalid OVH_MAX_AUTOPAY_PRICE '%s', expected a total in cents or 'unlimited'", v))
+			}
+			maxPrice = p
+		}
+		o, err := internal.NewOVH(internal.OVHConfig{
+			Endpoint:          os.Getenv("OVH_ENDPOINT"),
+			ApplicationKey:    ovhKey,
+			ApplicationSecret: os.Getenv("OVH_APPLICATION_SECRET"),
+			ConsumerKey:       os.Getenv("OVH_CONSUMER_KEY"),
+			Subsidiary:        os.Getenv("OVH_SUBSIDIARY"),
+			AutoPay:           os.Getenv("OVH_AUTOPAY") == "true",
+			MaxAutoPayPrice:   maxPrice,
+		})
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading OVH registrar: %w", err))
+		} else {
+			c = append(c, o)
+		}
+	}
+
+	// Registrars with a simple JSON API are described in YAML files
+	for _, path := range strings.Split(os.Getenv("HTTP_REGISTRARS"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		// the file is named explicitly, a mistake in it should not go unnoticed
+		h, err := loadHTTP(path)
+		if err != nil {
+			panic(fmt.Errorf("error while loading HTTP registrar from %s: %w", path, err))
+		}
+		c = append(c, h)
+	}
+
+	// Plugins are external programs speaking JSON over stdin and stdout
+	for _, command := range strings.Split(os.Getenv("PLUGINS"), ",") {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			continue
+		}
+		pl, err := internal.NewPlugin(internal.PluginConfig{
+			Command: args[0],
+			Args:    args[1:],
+			Timeout: durationEnv("PLUGIN_TIMEOUT", 30*time.Second),
+		})
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading plugin %s: %w", args[0], err))
+		} else {
+			c = append(c, pl)
+		}
+	}
+
+	// EPP talks to a registry directly, the accreditation comes with a client certificate
+	if eppAddress := os.Getenv("EPP_ADDRESS"); eppAddress != "" {
+		e, err := loadEPP(eppAddress)
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading EPP registrar: %w", err))
+		} else {
+			c = append(c, e)
+		}
+	}
+
+	// Gandi comes last, it is the fallback when the others can not register a domain
+	if gandiKey := os.Getenv("GANDI_API_KEY"); gandiKey != "" {
+		g, err := loadGandi(gandiKey)
+		if err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading Gandi registrar: %w", err))
+		} else {
+			c = append(c, g)
+		}
 	}
 
 	return c
 }
 
+func loadHTTP(path string) (checker.Registrar, error) {
+	cfg, err := internal.LoadHTTPConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return internal.NewHTTP(cfg)
+}
+
+func loadGandi(key string) (checker.Registrar, error) {
+	cfg := internal.GandiConfig{
+		APIKey:    key,
+		SharingID: os.Getenv("GANDI_SHARING_ID"),
+		Currency:  os.Getenv("GANDI_CURRENCY"),
+	}
+	if owner := os.Getenv("GANDI_OWNER"); owner != "" {
+		if err := json.Unmarshal([]byte(owner), &cfg.Owner); err != nil {
+			return nil, fmt.Errorf("invalid GANDI_OWNER: %w", err)
+		}
+	}
+	return internal.NewGandi(cfg)
+}
+
+func loadEPP(address string) (checker.Registrar, error) {
+	cfg := internal.EPPConfig{
+		Address:    address,
+		ClientID:   os.Getenv("EPP_CLIENT_ID"),
+		Password:   os.Getenv("EPP_PASSWORD"),
+		TLS:        &tls.Config{},
+		Registrant: os.Getenv("EPP_REGISTRANT"),
+		Admin:      os.Getenv("EPP_ADMIN"),
+		Tech:       os.Getenv("EPP_TECH"),
+		Billing:    os.Getenv("EPP_BILLING"),
+	}
+	if cert, key := os.Getenv("EPP_CERT_FILE"), os.Getenv("EPP_KEY_FILE"); cert != "" && key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLS.Certificates = []tls.Certificate{pair}
+	}
+	for _, ns := range strings.Split(os.Getenv("EPP_NAMESERVERS"), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			cfg.Nameservers = append(cfg.Nameservers, ns)
+		}
+	}
+	return internal.NewEPP(cfg)
+}
+
+// mapEnv reads a list like "nl=whois.example.nl,de=whois.example.de" from the environment
+func mapEnv(name string) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range strings.Split(os.Getenv(name), ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			m[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+		}
+	}
+	return m
+}
+
+// durationEnv reads a duration like "5m" from the environment, def is returned when the
+// variable is not set or invalid.
+func durationEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid duration '%s' for %s, using %s", v, name, def)
+		return def
+	}
+	return d
+}
+
+// intEnv reads a positive number from the environment, def is returned when the variable is
+// not set or invalid.
+func intEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		log.Printf("Invalid number '%s' for %s, using %d", v, name, def)
+		return def
+	}
+	return i
+}
+
+// Exit codes of the daemon, configuration errors end it with a panic which exits with 2
+const (
+	// exitOK follows a clean shutdown
+	exitOK = 0
+	// exitTimeout follows a shutdown in which running checks did not finish in time
+	exitTimeout = 3
+	// exitFlushFailed follows a shutdown in which the state could not be stored
+	exitFlushFailed = 4
+)
+
 func main() {
+	os.Exit(run())
+}
+
+// run starts the daemon and returns the exit code once it is stopped by a signal
+func run() int {
 	var domains []string
 
-	// get Redis connection going to keep track of requested domains
-	// over restarts. If no Redis connection can be established the
-	// program will continue without persisten storage.
-	var r *redis.Client
-	dsn := os.Getenv("REDIS_DSN")
-	password := os.Getenv("REDIS_PASSWORD")
-	db, err := strconv.Atoi(os.Getenv("REDIS_DB"))
-	if err != nil {
-		log.Printf("%v\n", fmt.Errorf("error while loading Redis db variable: %w", err))
-	} else {
-		r, err := startRedis(dsn, password, db)
-		if err != nil {
-			log.Printf("%v\n", (fmt.Errorf("error while conecting to Redis: %w", err)))
-		} else {
-			if n, err := r.LLen(RedisListKey).Result(); err != nil {
-				var i int64
-				for ; i < n; i++ {
-					if s, err := r.RPop(RedisListKey).Result(); err == nil {
-						domains = append(domains, s)
-					}
-				}
-			}
-			defer r.Close()
+	// keep track of requested domains over restarts
+	store := loadStore()
+	if store != nil {
+		var err error
+		if domains, err = store.Domains(); err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading domains: %w", err))
+		}
+		defer store.Close()
+	}
+
+	// every watched domain moves through states, their history survives restarts
+	states := newDomainStates(store, intEnv("STATE_HISTORY_SIZE", 20))
+	if err := states.load(); err != nil {
+		log.Printf("%v\n", fmt.Errorf("error while loading domain states: %w", err))
+	}
+
+	// follow up on registrations that are still being processed by a registrar, also the
+	// ones that were running before a restart
+	clients := loadClients()
+	t := newTracker(clients, store, states,
+		durationEnv("REGISTRATION_POLL_INTERVAL", 5*time.Minute),
+		durationEnv("REGISTRATION_ESCALATE_AFTER", 24*time.Hour),
+		durationEnv("REGISTRATION_TIMEOUT", 7*24*time.Hour),
+	)
+	if err := t.load(); err != nil {
+		log.Printf("%v\n", fmt.Errorf("error while loading pending registrations: %w", err))
+	}
+	done := make(chan struct{})
+
+	// in drop catching mode domains are checked based on the predicted moment the
+	// registry deletes them
+	var d *dropCatcher
+	if os.Getenv("DROPCATCH_ENABLED") == "true" {
+		d = newDropCatcher(clients,
+			durationEnv("DROPCATCH_IDLE_INTERVAL", 6*time.Hour),
+			durationEnv("DROPCATCH_BURST_INTERVAL", 5*time.Second),
+			durationEnv("DROPCATCH_RATE_LIMIT", time.Second),
+		)
+		if len(d.readers) == 0 {
+			log.Println("WARNING: drop catching is enabled but no registrar can read domain lifecycles")
+		}
+	}
+
+	// the DNS pre-filter skips the registrars for domains that are clearly registered
+	var f checker.PreFilter
+	if os.Getenv("DNS_FILTER_ENABLED") == "true" {
+		var err error
+		if f, err = internal.NewDNSFilter(internal.DNSConfig{Server: os.Getenv("DNS_FILTER_SERVER")}); err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while loading DNS pre-filter: %w", err))
 		}
 	}
 
 	// run the checking loops
-	c := newChecking(domains, loadClients(), r)
+	c := newChecking(domains, clients, store, t, states, d, f,
+		intEnv("CHECK_WORKERS", 4),
+		durationEnv("CHECK_INTERVAL", time.Minute),
+		durationEnv("CHECK_JITTER", 10*time.Second),
+	)
 
 	// get server running for communication with this instance
 	port := os.Getenv("PORT")
@@ -103,7 +425,70 @@ func main() {
 	if err != nil {
 		panic(fmt.Errorf("error while launching server: %w", err))
 	}
-	defer s.close()
 
-	c.runChecks()
+	// instances sharing Redis elect a leader to check the domains, the others stand by
+	if rs, ok := store.(*redisStore); ok {
+		c.coordinator = newCoordinator(rs.client, instanceID(), durationEnv("LOCK_TTL", 30*time.Second))
+	}
+	checksDone := make(chan struct{})
+	go func() {
+		if c.coordinator != nil {
+			c.coordinator.lead(done, func(stop <-chan struct{}) {
+				c.lead(stop, durationEnv("SYNC_INTERVAL", time.Minute))
+			})
+		} else {
+			go t.run(done)
+			c.runChecks(done)
+		}
+		close(checksDone)
+	}()
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	log.Printf("Received %s, shutting down", <-sig)
+	go func() {
+		log.Printf("Received %s again, exiting immediately", <-sig)
+		os.Exit(exitTimeout)
+	}()
+	return shutdown(s, c, t, clients, done, checksDone, durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second))
+}
+
+// shutdown stops accepting connections and checks, gives running checks and registrations
+// until the timeout to finish, stores the state and closes the registrars
+func shutdown(s *server, c *checking, t *tracker, clients []checker.Registrar, done chan struct{}, checksDone <-chan struct{}, timeout time.Duration) int {
+	code := exitOK
+	s.close()
+	close(done)
+	select {
+	case <-checksDone:
+	case <-time.After(timeout):
+		log.Printf("Running checks did not finish within %s, stopping anyway", timeout)
+		code = exitTimeout
+	}
+
+	// instances sharing Redis wrote every change through, flushing what a standby remembers
+	// would overwrite the records of the leader
+	if c.coordinator == nil {
+		if err := c.persist(); err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while storing domain list: %w", err))
+			code = exitFlushFailed
+		}
+		if err := t.flush(); err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while storing pending registrations: %w", err))
+			code = exitFlushFailed
+		}
+		if err := c.states.flush(); err != nil {
+			log.Printf("%v\n", fmt.Errorf("error while storing domain states: %w", err))
+			code = exitFlushFailed
+		}
+	}
+	for _, r := range clients {
+		if cl, ok := r.(io.Closer); ok {
+			if err := cl.Close(); err != nil {
+				log.Printf("%v\n", fmt.Errorf("error while closing %s: %w", checker.RegistrarName(r), err))
+			}
+		}
+	}
+	log.Println("Shut down")
+	return code
 }
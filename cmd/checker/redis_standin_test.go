@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// redisStandIn is an in-memory Redis speaking just enough of the protocol for the store:
+// strings, counters, lists, sets, hashes, expiry, SCAN and MULTI/EXEC with WATCH
+type redisStandIn struct {
+	listener net.Listener
+
+	lock     sync.Mutex
+	data     map[string]interface{}
+	versions map[string]int
+	expires  map[string]time.Time
+}
+
+func newRedisStandIn(t *testing.T) (*redisStandIn, *redis.Client) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &redisStandIn{listener: l, data: map[string]interface{}{}, versions: map[string]int{}, expires: map[string]time.Time{}}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s, redis.NewClient(&redis.Options{Addr: l.Addr().String()})
+}
+
+func (s *redisStandIn) Close() {
+	s.listener.Close()
+}
+
+// redisConn is the state of a single connection
+type redisConn struct {
+	queue   [][]string
+	multi   bool
+	watched map[string]int
+}
+
+func (s *redisStandIn) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	conn := &redisConn{}
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+		writeRedisReply(w, s.handle(conn, args))
+		w.Flush()
+	}
+}
+
+func (s *redisStandIn) handle(c *redisConn, args []string) interface{} {
+	name := strings.ToLower(args[0])
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, at := range s.expires {
+		if time.Now().After(at) {
+			s.write(key, nil)
+		}
+	}
+	switch {
+	case name == "multi":
+		c.multi = true
+		return "OK"
+	case name == "exec":
+		queue, watched := c.queue, c.watched
+		c.queue, c.multi, c.watched = nil, false, nil
+		for key, v := range watched {
+			if s.versions[key] != v {
+				return nil
+			}
+		}
+		replies := make([]interface{}, len(queue))
+		for i, cmd := range queue {
+			replies[i] = s.exec(cmd)
+		}
+		return replies
+	case c.multi:
+		c.queue = append(c.queue, args)
+		return "QUEUED"
+	case name == "watch":
+		if c.watched == nil {
+			c.watched = map[string]int{}
+		}
+		for _, key := range args[1:] {
+			c.watched[key] = s.versions[key]
+		}
+		return "OK"
+	case name == "unwatch":
+		c.watched = nil
+		return "OK"
+	}
+	return s.exec(args)
+}
+
+// exec runs a single command, the lock must be held
+func (s *redisStandIn) exec(args []string) interface{} {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		return "PONG"
+	case "get":
+		if v, ok := s.data[key].(string); ok {
+			return []byte(v)
+		}
+		return nil
+	case "set":
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "nx":
+				if _, ok := s.data[key]; ok {
+					return nil
+				}
+			case "ex", "px":
+				n, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(n) * time.Millisecond
+				if strings.ToLower(args[i]) == "ex" {
+					ttl = time.Duration(n) * time.Second
+				}
+				i++
+			}
+		}
+		s.write(key, args[2])
+		if ttl > 0 {
+			s.expires[key] = time.Now().Add(ttl)
+		}
+		return "OK"
+	case "incr":
+		n, _ := strconv.Atoi(fmt.Sprint(s.data[key]))
+		s.write(key, strconv.Itoa(n+1))
+		return n + 1
+	case "pexpire":
+		if _, ok := s.data[key]; !ok {
+			return 0
+		}
+		n, _ := strconv.Atoi(args[2])
+		s.expires[key] = time.Now().Add(time.Duration(n) * time.Millisecond)
+		return 1
+	case "del":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.data[k]; ok {
+				n++
+				s.write(k, nil)
+			}
+		}
+		return n
+	case "lpush":
+		l, _ := s.data[key].([]string)
+		for _, v := range args[2:] {
+			l = append([]string{v}, l...)
+		}
+		s.write(key, l)
+		return len(l)
+	case "lrange":
+		l, _ := s.data[key].([]string)
+		return redisStrings(l)
+	case "sadd", "srem":
+		set, _ := s.data[key].(map[string]bool)
+		next := map[string]bool{}
+		for m := range set {
+			next[m] = true
+		}
+		for _, m := range args[2:] {
+			if strings.ToLower(args[0]) == "sadd" {
+				next[m] = true
+			} else {
+				delete(next, m)
+			}
+		}
+		s.write(key, next)
+		return len(args) - 2
+	case "sismember":
+		set, _ := s.data[key].(map[string]bool)
+		if set[args[2]] {
+			return 1
+		}
+		return 0
+	case "smembers":
+		set, _ := s.data[key].(map[string]bool)
+		var members []string
+		for m := range set {
+			members = append(members, m)
+		}
+		return redisStrings(members)
+	case "hset", "hdel":
+		h, _ := s.data[key].(map[string]string)
+		next := map[string]string{}
+		for f, v := range h {
+			next[f] = v
+		}
+		if strings.ToLower(args[0]) == "hset" {
+			for i := 2; i+1 < len(args); i += 2 {
+				next[args[i]] = args[i+1]
+			}
+		} else {
+			for _, f := range args[2:] {
+				delete(next, f)
+			}
+		}
+		s.write(key, next)
+		return 1
+	case "hget":
+		h, _ := s.data[key].(map[string]string)
+		if v, ok := h[args[2]]; ok {
+			return []byte(v)
+		}
+		return nil
+	case "hgetall":
+		h, _ := s.data[key].(map[string]string)
+		var fields []string
+		for f, v := range h {
+			fields = append(fields, f, v)
+		}
+		return redisStrings(fields)
+	case "scan":
+		match := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToLower(args[i]) == "match" {
+				match = args[i+1]
+			}
+		}
+		var keys []string
+		for k := range s.data {
+			if ok, _ := path.Match(match, k); ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		return []interface{}{[]byte("0"), redisStrings(keys)}
+	}
+	return fmt.Errorf("ERR unknown command '%s'", args[0])
+}
+
+// write replaces the value of a key, empty collections and nil delete it
+func (s *redisStandIn) write(key string, v interface{}) {
+	s.versions[key]++
+	delete(s.expires, key)
+	switch c := v.(type) {
+	case nil:
+		delete(s.data, key)
+		return
+	case []string:
+		if len(c) == 0 {
+			delete(s.data, key)
+			return
+		}
+	case map[string]bool:
+		if len(c) == 0 {
+			delete(s.data, key)
+			return
+		}
+	case map[string]string:
+		if len(c) == 0 {
+			delete(s.data, key)
+			return
+		}
+	}
+	s.data[key] = v
+}
+
+// set writes a string value, as if another client did
+func (s *redisStandIn) set(key, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.write(key, value)
+}
+
+// keys returns the keys in use
+func (s *redisStandIn) keys() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var keys []string
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func redisStrings(l []string) []interface{} {
+	r := make([]interface{}, len(l))
+	for i, v := range l {
+		r[i] = []byte(v)
+	}
+	return r
+}
+
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func writeRedisReply(w *bufio.Writer, v interface{}) {
+	switch r := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "+%s\r\n", r)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", r)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", r)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(r), r)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(r))
+		for _, e := range r {
+			writeRedisReply(w, e)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scheduledDomain is a domain together with when and how often it is checked
+type scheduledDomain struct {
+	name string
+	// interval is the time between checks, a random duration of at most jitter is added to it
+	// so domains added at the same moment spread out over time
+	interval time.Duration
+	jitter   time.Duration
+	due      time.Time
+	// priority decides which domain goes first when several are due, highest first
+	priority int
+
+	// index is the position in the queue, -1 while the domain is being checked
+	index   int
+	removed bool
+}
+
+// scheduleQueue is a priority queue of domains ordered by the moment they are due
+type scheduleQueue []*scheduledDomain
+
+func (q scheduleQueue) Len() int           { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x interface{}) {
+	d := x.(*scheduledDomain)
+	d.index = len(*q)
+	*q = append(*q, d)
+}
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	d.index = -1
+	*q = old[:len(old)-1]
+	return d
+}
+
+// scheduler runs checks for domains when they are due on a bounded amount of workers. Domains
+// are checked one at a time, a domain is never checked by two workers at once.
+type scheduler struct {
+	// check checks the domain and returns when it is due again, the zero time means after
+	// the interval of the domain
+	check   func(name string, now time.Time) time.Time
+	workers int
+	// interval and jitter are used for domains added without their own
+	interval time.Duration
+	jitter   time.Duration
+
+	lock    sync.Mutex
+	queue   scheduleQueue
+	domains map[string]*scheduledDomain
+	// wake is signalled when the head of the queue may have changed
+	wake chan struct{}
+}
+
+// add schedules the domain to be checked right away and every interval after that, a zero
+// interval and a negative jitter select the defaults. Adding a domain that is scheduled
+// already changes its interval.
+func (s *scheduler) add(name string, interval, jitter time.Duration) {
+	if interval <= 0 {
+		interval = s.interval
+	}
+	if jitter < 0 {
+		jitter = s.jitter
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if d, ok := s.domains[name]; ok {
+		d.interval, d.jitter, d.removed = interval, jitter, false
+		return
+	}
+	d := &scheduledDomain{name: name, interval: interval, jitter: jitter, due: time.Now()}
+	s.domains[name] = d
+	heap.Push(&s.queue, d)
+	s.signal()
+}
+
+// prioritize changes the priority of a scheduled domain
+func (s *scheduler) prioritize(name string, priority int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if d, ok := s.domains[name]; ok {
+		d.priority = priority
+	}
+}
+
+// remove stops checking the domain, a check that is running is finished but not rescheduled
+func (s *scheduler) remove(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	d, ok := s.domains[name]
+	if !ok {
+		return
+	}
+	if d.index < 0 {
+		// the domain is being checked, it is forgotten once the check is done
+		d.removed = true
+		return
+	}
+	delete(s.domains, name)
+	heap.Remove(&s.queue, d.index)
+	s.signal()
+}
+
+// list returns the scheduled domains in alphabetical order
+func (s *scheduler) list() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make([]string, 0, len(s.domains))
+	for n, d := range s.domains {
+		if !d.removed {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// due returns when the domain is checked next, false when it is not scheduled or being checked
+func (s *scheduler) due(name string) (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	d, ok := s.domains[name]
+	if !ok || d.removed || d.index < 0 {
+		return time.Time{}, false
+	}
+	return d.due, true
+}
+
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run hands due domains to the workers until done is closed, running checks are finished
+// before it returns
+func (s *scheduler) run(done <-chan struct{}) {
+	jobs := make(chan *scheduledDomain)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				s.runCheck(d)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.lock.Lock()
+		next, wait := s.next(time.Now())
+		s.lock.Unlock()
+
+		if next != nil {
+			select {
+			case jobs <- next:
+			case <-done:
+				s.lock.Lock()
+				heap.Push(&s.queue, next)
+				s.lock.Unlock()
+				return
+			}
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// next takes the due domain with the highest priority from the queue, when no domain is due
+// it returns the time until the first one is. The lock must be held.
+func (s *scheduler) next(now time.Time) (*scheduledDomain, time.Duration) {
+	if len(s.queue) == 0 {
+		return nil, time.Hour
+	}
+	if wait := s.queue[0].due.Sub(now); wait > 0 {
+		return nil, wait
+	}
+	next := s.queue[0]
+	for _, d := range s.queue {
+		if !d.due.After(now) && d.priority > next.priority {
+			next = d
+		}
+	}
+	heap.Remove(&s.queue, next.index)
+	return next, 0
+}
+
+// runCheck checks the domain and puts it back in the queue
+func (s *scheduler) runCheck(d *scheduledDomain) {
+	now := time.Now()
+	due := s.check(d.name, now)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if d.removed {
+		delete(s.domains, d.name)
+		return
+	}
+	if due.IsZero() {
+		due = now.Add(d.interval)
+		if d.jitter > 0 {
+			due = due.Add(time.Duration(rand.Int63n(int64(d.jitter))))
+		}
+	}
+	d.due = due
+	heap.Push(&s.queue, d)
+	s.signal()
+}
+
+func newScheduler(check func(string, time.Time) time.Time, workers int, interval, jitter time.Duration) *scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &scheduler{
+		check:    check,
+		workers:  workers,
+		interval: interval,
+		jitter:   jitter,
+		domains:  make(map[string]*scheduledDomain),
+		wake:     make(chan struct{}, 1),
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingCheck records the checks of a scheduler and blocks them while hold is set
+type recordingCheck struct {
+	lock    sync.Mutex
+	checked []string
+	running int
+	max     int
+	hold    chan struct{}
+}
+
+func (r *recordingCheck) check(name string, now time.Time) time.Time {
+	r.lock.Lock()
+	r.checked = append(r.checked, name)
+	r.running++
+	if r.running > r.max {
+		r.max = r.running
+	}
+	hold := r.hold
+	r.lock.Unlock()
+
+	if hold != nil {
+		<-hold
+	}
+	r.lock.Lock()
+	r.running--
+	r.lock.Unlock()
+	return time.Time{}
+}
+
+func (r *recordingCheck) count(name string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n := 0
+	for _, c := range r.checked {
+		if c == name {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor polls until cond holds or a second passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerIntervals(t *testing.T) {
+	r := &recordingCheck{}
+	s := newScheduler(r.check, 2, time.Hour, time.Millisecond)
+	done := make(chan struct{})
+	defer close(done)
+	go s.run(done)
+
+	s.add("slow.org", 0, 0)
+	s.add("fast.org", 20*time.Millisecond, time.Millisecond)
+	waitFor(t, "the fast domain to be checked repeatedly", func() bool { return r.count("fast.org") >= 3 })
+	if n := r.count("slow.org"); n != 1 {
+		t.Errorf("Expected the slow domain to be checked once, got %d", n)
+	}
+	if due, ok := s.due("slow.org"); !ok || time.Until(due) < 59*time.Minute {
+		t.Errorf("Expected the slow domain to be due after its interval, got %s", time.Until(due))
+	}
+
+	s.remove("fast.org")
+	n := r.count("fast.org")
+	time.Sleep(60 * time.Millisecond)
+	if r.count("fast.org") != n {
+		t.Error("Expected a removed domain not to be checked anymore")
+	}
+	if l := s.list(); len(l) != 1 || l[0] != "slow.org" {
+		t.Errorf("Expected only slow.org to be scheduled, got %v", l)
+	}
+}
+
+func TestSchedulerWorkers(t *testing.T) {
+	r := &recordingCheck{hold: make(chan struct{})}
+	s := newScheduler(r.check, 2, time.Hour, time.Millisecond)
+	done := make(chan struct{})
+	go s.run(done)
+
+	for _, name := range []string{"a.org", "b.org", "c.org"} {
+		s.add(name, 0, 0)
+	}
+	waitFor(t, "the workers to be busy", func() bool {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return r.running == 2
+	})
+
+	// a domain removed during its check is not scheduled again
+	r.lock.Lock()
+	running := r.checked[0]
+	r.lock.Unlock()
+	s.remove(running)
+
+	close(r.hold)
+	waitFor(t, "all domains to be checked", func() bool {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return len(r.checked) == 3
+	})
+	close(done)
+
+	r.lock.Lock()
+	max := r.max
+	r.lock.Unlock()
+	if max != 2 {
+		t.Errorf("Expected at most 2 checks at once, got %d", max)
+	}
+	if l := s.list(); len(l) != 2 {
+		t.Errorf("Expected the removed domain to be forgotten, got %v", l)
+	}
+	if _, ok := s.due(running); ok {
+		t.Errorf("Expected %s not to be scheduled anymore", running)
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	r := &recordingCheck{}
+	s := newScheduler(r.check, 1, time.Hour, time.Millisecond)
+	for _, name := range []string{"a.org", "b.org", "c.org"} {
+		s.add(name, 0, 0)
+	}
+	s.prioritize("c.org", 10)
+	s.prioritize("b.org", 5)
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.run(done)
+	waitFor(t, "all domains to be checked", func() bool {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return len(r.checked) == 3
+	})
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, expected := range []string{"c.org", "b.org", "a.org"} {
+		if r.checked[i] != expected {
+			t.Errorf("Expected check %d to be %s, got %v", i, expected, r.checked)
+		}
+	}
+}
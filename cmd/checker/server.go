@@ -3,10 +3,12 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"strings"
+	"sync"
 )
 
 func isAuthenticated(c *client) bool {
@@ -25,17 +27,20 @@ type client struct {
 	conn          net.Conn
 	authenticated bool
 	commands      chan command
+	// closed stops read from handing out commands once the connection is closed
+	closed chan struct{}
 }
 
 func (c *client) close() {
 	log.Printf("Closing connection to '%s'", c.conn.RemoteAddr())
 	c.conn.Close()
-	close(c.commands)
+	close(c.closed)
 }
 
 func (c *client) read() {
+	r := bufio.NewReader(c.conn)
 	for {
-		d, err := bufio.NewReader(c.conn).ReadString('\n')
+		d, err := r.ReadString('\n')
 		if err != nil {
 			if _, ok := err.(*net.OpError); ok {
 				return
@@ -44,7 +49,7 @@ func (c *client) read() {
 			return
 		}
 
-		cmd := strings.Fields(string(d))
+		cmd := splitFields(d)
 		if len(cmd) == 0 {
 			continue
 		}
@@ -53,9 +58,10 @@ func (c *client) read() {
 		for i := 1; i < len(cmd); i++ {
 			params[i-1] = cmd[i]
 		}
-		c.commands <- command{
-			name:   name,
-			params: params,
+		select {
+		case c.commands <- command{name: name, params: params}:
+		case <-c.closed:
+			return
 		}
 	}
 }
@@ -71,11 +77,21 @@ type server struct {
 	done     chan struct{}
 	checking *checking
 	token    string
+
+	// clients tracks the connections being handled, lock keeps a connection accepted while
+	// closing from being added once close waits for them
+	clients sync.WaitGroup
+	lock    sync.Mutex
 }
 
+// close stops accepting connections and says goodbye to the connected clients, it returns
+// once their connections are closed
 func (s *server) close() {
+	s.lock.Lock()
 	close(s.done)
+	s.lock.Unlock()
 	s.listener.Close()
+	s.clients.Wait()
 }
 
 func (s *server) loop() {
@@ -92,7 +108,18 @@ func (s *server) loop() {
 				conn:          c,
 				authenticated: false,
 				commands:      make(chan command),
+				closed:        make(chan struct{}),
+			}
+			s.lock.Lock()
+			select {
+			case <-s.done:
+				s.lock.Unlock()
+				c.Close()
+				return
+			default:
 			}
+			s.clients.Add(1)
+			s.lock.Unlock()
 			log.Printf("New connection from '%s'", c.RemoteAddr())
 			go s.handle(cl)
 		}
@@ -100,13 +127,14 @@ func (s *server) loop() {
 }
 
 func (s *server) handle(c *client) {
+	defer s.clients.Done()
 	defer c.close()
 	go c.read()
 
 	for {
 		select {
 		case _ = <-s.done:
-			log.Println("'done' channel closed")
+			c.write("server is shutting down, goodbye")
 			return
 		case cmd := <-c.commands:
 			switch cmd.name {
@@ -120,7 +148,36 @@ func (s *server) handle(c *client) {
 				if !isAuthenticated(c) {
 					break
 				}
-				s.checking.addDomain(cmd.params[0])
+				if len(cmd.params) == 0 {
+					c.write("usage: ADD <domain> [interval [jitter]] [key=value...]")
+					break
+				}
+				// options are applied to those of a domain that is watched already
+				var durations []string
+				opts := s.checking.states.options(cmd.params[0])
+				var err error
+				for _, p := range cmd.params[1:] {
+					if strings.Contains(p, "=") {
+						if err = opts.set(p); err != nil {
+							break
+						}
+					} else {
+						durations = append(durations, p)
+					}
+				}
+				if err != nil {
+					c.write(err.Error())
+					break
+				}
+				// durations that are not given keep those the domain is checked with already
+				if err = opts.setSchedule(durations); err != nil {
+					c.write(err.Error())
+					break
+				}
+				if err = s.checking.addDomain(cmd.params[0], opts); err != nil {
+					c.write(err.Error())
+					break
+				}
 				c.write(fmt.Sprintf("%s added", cmd.params[0]))
 			case "REMOVE":
 				if !isAuthenticated(c) {
@@ -128,10 +185,61 @@ func (s *server) handle(c *client) {
 				}
 				s.checking.removeDomain(cmd.params[0])
 				c.write(fmt.Sprintf("%s removed", cmd.params[0]))
+			case "STATE":
+				if !isAuthenticated(c) {
+					break
+				}
+				if len(cmd.params) != 1 {
+					c.write("usage: STATE <domain>")
+					break
+				}
+				d, ok := s.checking.domainState(cmd.params[0])
+				if !ok {
+					c.write(fmt.Sprintf("%s is not watched", cmd.params[0]))
+					break
+				}
+				b, err := json.Marshal(d)
+				if err != nil {
+					c.write(err.Error())
+					break
+				}
+				c.write(string(b))
+			case "PAUSE", "RESUME":
+				if !isAuthenticated(c) {
+					break
+				}
+				if len(cmd.params) != 1 {
+					c.write(fmt.Sprintf("usage: %s <domain>", cmd.name))
+					break
+				}
+				if _, ok := s.checking.domainState(cmd.params[0]); !ok {
+					c.write(fmt.Sprintf("%s is not watched", cmd.params[0]))
+					break
+				}
+				var err error
+				if cmd.name == "PAUSE" {
+					err = s.checking.pauseDomain(cmd.params[0])
+				} else {
+					err = s.checking.resumeDomain(cmd.params[0])
+				}
+				if err != nil {
+					c.write(err.Error())
+					break
+				}
+				c.write(fmt.Sprintf("%s %s", cmd.params[0], strings.ToLower(cmd.name)+"d"))
 			case "LIST":
 				if !isAuthenticated(c) {
 					break
 				}
+				if len(cmd.params) > 0 && strings.EqualFold(cmd.params[0], "details") {
+					b, err := json.Marshal(s.checking.domainDetails())
+					if err != nil {
+						c.write(err.Error())
+						break
+					}
+					c.write(string(b))
+					break
+				}
 				domains := s.checking.listDomains()
 				res := ""
 				for _, domain := range domains {
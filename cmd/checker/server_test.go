@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// closingRegistrar records whether it was closed
+type closingRegistrar struct {
+	lifecycleRegistrar
+	closed bool
+}
+
+func (c *closingRegistrar) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newTestDaemon(t *testing.T, check func(string, time.Time) time.Time) (*server, *checking, *tracker) {
+	t.Helper()
+	states := newDomainStates(nil, 10)
+	tr := newTracker(nil, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking(nil, nil, nil, tr, states, nil, nil, 1, time.Hour, time.Millisecond)
+	if check != nil {
+		c.scheduler.check = check
+	}
+	s, err := newServer("0", "secret", c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c, tr
+}
+
+func TestShutdownSaysGoodbye(t *testing.T) {
+	s, c, tr := newTestDaemon(t, nil)
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("AUTH secret\nADD example.org\n"))
+	if l, err := r.ReadString('\n'); err != nil || l != "example.org added\n" {
+		t.Fatalf("Expected the domain to be added, got '%s' and '%v'", l, err)
+	}
+
+	cl := &closingRegistrar{}
+	done, checksDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		c.runChecks(done)
+		close(checksDone)
+	}()
+	if code := shutdown(s, c, tr, []checker.Registrar{cl}, done, checksDone, time.Second); code != exitOK {
+		t.Errorf("Expected a clean shutdown, got exit code %d", code)
+	}
+
+	if l, err := r.ReadString('\n'); err != nil || !strings.Contains(l, "goodbye") {
+		t.Errorf("Expected a goodbye message, got '%s' and '%v'", l, err)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("Expected the connection to be closed")
+	}
+	if !cl.closed {
+		t.Error("Expected the registrar to be closed")
+	}
+	if _, err := net.Dial("tcp", s.listener.Addr().String()); err == nil {
+		t.Error("Expected no new connections to be accepted")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s, c, tr := newTestDaemon(t, func(string, time.Time) time.Time {
+		close(started)
+		<-release
+		return time.Time{}
+	})
+	c.addDomain("slow.org", watchOptions{})
+
+	done, checksDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		c.runChecks(done)
+		close(checksDone)
+	}()
+	<-started
+	if code := shutdown(s, c, tr, nil, done, checksDone, 10*time.Millisecond); code != exitTimeout {
+		t.Errorf("Expected the timeout exit code, got %d", code)
+	}
+}
+
+func TestServerAddOptions(t *testing.T) {
+	s, _, _ := newTestDaemon(t, func(string, time.Time) time.Time { return time.Time{} })
+	defer s.close()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte("AUTH secret\nADD example.org 5m colour=red\n"))
+	if l, _ := r.ReadString('\n'); !strings.Contains(l, "unknown option") {
+		t.Errorf("Expected an unknown option to be refused, got '%s'", l)
+	}
+	conn.Write([]byte("ADD example.org 5m note=\"renew before May\" tags=brand,shop mode=notify\nLIST details\n"))
+	if l, _ := r.ReadString('\n'); l != "example.org added\n" {
+		t.Fatalf("Expected the domain to be added, got '%s'", l)
+	}
+	l, _ := r.ReadString('\n')
+	for _, expected := range []string{`"notes":"renew before May"`, `"tags":["brand","shop"]`, `"mode":"notify"`, `"state":"watching"`} {
+		if !strings.Contains(l, expected) {
+			t.Errorf("Expected the details to contain %s, got '%s'", expected, l)
+		}
+	}
+}
+
+func TestServerAddSchedule(t *testing.T) {
+	s, c, _ := newTestDaemon(t, func(string, time.Time) time.Time { return time.Time{} })
+	defer s.close()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	schedule := func() (time.Duration, time.Duration) {
+		c.scheduler.lock.Lock()
+		defer c.scheduler.lock.Unlock()
+		d := c.scheduler.domains["example.org"]
+		return d.interval, d.jitter
+	}
+	conn.Write([]byte("AUTH secret\nADD example.org 0s\n"))
+	if l, _ := r.ReadString('\n'); !strings.Contains(l, "invalid duration") {
+		t.Errorf("Expected an interval of zero to be refused, got '%s'", l)
+	}
+	for _, step := range []struct {
+		add              string
+		interval, jitter time.Duration
+	}{
+		{"ADD example.org 5m 0s", 5 * time.Minute, 0},
+		// durations that are not given are left alone
+		{"ADD example.org mode=notify", 5 * time.Minute, 0},
+		{"ADD example.org 10m", 10 * time.Minute, 0},
+	} {
+		conn.Write([]byte(step.add + "\n"))
+		if l, _ := r.ReadString('\n'); l != "example.org added\n" {
+			t.Fatalf("Expected the domain to be added, got '%s'", l)
+		}
+		if interval, jitter := schedule(); interval != step.interval || jitter != step.jitter {
+			t.Errorf("Expected %s to check every %s with jitter %s, got %s and %s", step.add, step.interval, step.jitter, interval, jitter)
+		}
+	}
+	if o := c.states.options("example.org"); o.Interval != 10*time.Minute || o.Jitter == nil || *o.Jitter != 0 {
+		t.Errorf("Expected the interval and jitter in the options, got %+v", o)
+	}
+}
+
+func TestServerCloseWhileConnecting(t *testing.T) {
+	s, _, _ := newTestDaemon(t, nil)
+	addr := s.listener.Addr().String()
+	stop := make(chan struct{})
+	dialing := make(chan struct{})
+	go func() {
+		defer close(dialing)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				conn.Close()
+			}
+		}
+	}()
+	time.Sleep(5 * time.Millisecond)
+	// connections accepted while closing are closed instead of handled
+	s.close()
+	close(stop)
+	<-dialing
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clients.Wait()
+}
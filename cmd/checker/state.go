@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// domainState is the state a watched domain is in
+type domainState string
+
+// The states of a watched domain
+const (
+	// stateWatching domains are checked until they become available
+	stateWatching domainState = "watching"
+	// stateAvailable domains were reported available by a registrar
+	stateAvailable domainState = "available"
+	// stateRegistering domains are being registered at the registrars
+	stateRegistering domainState = "registering"
+	// stateProcessing domains were accepted by a registrar that is still processing them
+	stateProcessing domainState = "processing"
+	// stateOwned domains are in our possession, they are no longer checked
+	stateOwned domainState = "owned"
+	// stateFailed domains could not be registered, they are checked again
+	stateFailed domainState = "failed"
+	// statePaused domains are not checked until they are resumed
+	statePaused domainState = "paused"
+)
+
+// domainTransitions lists the states every state can move to
+var domainTransitions = map[domainState][]domainState{
+	stateWatching:    {stateAvailable, stateOwned, statePaused},
+	stateAvailable:   {stateRegistering, stateWatching, stateOwned, statePaused},
+	stateRegistering: {stateProcessing, stateOwned, stateFailed},
+	stateProcessing:  {stateOwned, stateFailed, stateWatching},
+	stateOwned:       {stateWatching, statePaused},
+	stateFailed:      {stateAvailable, stateWatching, stateOwned, statePaused},
+	statePaused:      {stateWatching},
+}
+
+// domainTransition records a change of state
+type domainTransition struct {
+	From      domainState `json:"from"`
+	To        domainState `json:"to"`
+	At        time.Time   `json:"at"`
+	Registrar string      `json:"registrar,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+}
+
+// domainCheck is the outcome of the last check of a domain
+type domainCheck struct {
+	At        time.Time `json:"at"`
+	Status    string    `json:"status"`
+	Registrar string    `json:"registrar,omitempty"`
+}
+
+// watchedDomain is the state of a watched domain together with how it got there
+type watchedDomain struct {
+	Domain    string             `json:"domain"`
+	Options   watchOptions       `json:"options"`
+	State     domainState        `json:"state"`
+	Since     time.Time          `json:"since"`
+	LastCheck *domainCheck       `json:"lastCheck,omitempty"`
+	History   []domainTransition `json:"history"`
+}
+
+// domainStates keeps the state machine of every watched domain
+type domainStates struct {
+	store Store
+	// historySize is the amount of transitions kept per domain, older ones are dropped
+	historySize int
+
+	lock    sync.Mutex
+	domains map[string]*watchedDomain
+	// fences holds the fencing token of the registration lock of the domains being registered
+	fences map[string]int64
+}
+
+// add starts the state machine of the domain at watching, known domains keep their state
+func (s *domainStates) add(name string) {
+	s.lock.Lock()
+	_, ok := s.domains[name]
+	if !ok {
+		s.domains[name] = &watchedDomain{Domain: name, State: stateWatching, Since: time.Now()}
+	}
+	s.lock.Unlock()
+	if !ok {
+		s.persist(name)
+	}
+}
+
+// fence stores the state of the domain with the fencing token of its registration lock until
+// the token is cleared with 0
+func (s *domainStates) fence(name string, token int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if token == 0 {
+		delete(s.fences, name)
+	} else {
+		s.fences[name] = token
+	}
+}
+
+// forget drops the state of the domain
+func (s *domainStates) forget(name string) {
+	s.lock.Lock()
+	delete(s.domains, name)
+	s.lock.Unlock()
+	s.persist(name)
+}
+
+// state returns the current state of the domain, unknown domains are watching
+func (s *domainStates) state(name string) domainState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if d, ok := s.domains[name]; ok {
+		return d.State
+	}
+	return stateWatching
+}
+
+// get returns a copy of the state of the domain
+func (s *domainStates) get(name string) (watchedDomain, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	d, ok := s.domains[name]
+	if !ok {
+		return watchedDomain{}, false
+	}
+	c := *d
+	c.History = append([]domainTransition(nil), d.History...)
+	c.Options.Tags = append([]string(nil), d.Options.Tags...)
+	c.Options.Registrars = append([]string(nil), d.Options.Registrars...)
+	if d.Options.Jitter != nil {
+		j := *d.Options.Jitter
+		c.Options.Jitter = &j
+	}
+	if d.LastCheck != nil {
+		lc := *d.LastCheck
+		c.LastCheck = &lc
+	}
+	return c, true
+}
+
+// options returns the watch options of the domain
+func (s *domainStates) options(name string) watchOptions {
+	if d, ok := s.get(name); ok {
+		return d.Options
+	}
+	return watchOptions{}
+}
+
+// setOptions replaces the watch options of a known domain
+func (s *domainStates) setOptions(name string, o watchOptions) {
+	s.lock.Lock()
+	d, ok := s.domains[name]
+	if ok {
+		d.Options = o
+	}
+	s.lock.Unlock()
+	if ok {
+		s.persist(name)
+	}
+}
+
+// transition moves the domain to another state, moving to the current state does nothing.
+// Transitions the state machine does not allow are refused.
+func (s *domainStates) transition(name string, to domainState, registrar, reason string) error {
+	s.lock.Lock()
+	d, ok := s.domains[name]
+	if !ok {
+		d = &watchedDomain{Domain: name, State: stateWatching, Since: time.Now()}
+		s.domains[name] = d
+	}
+	if d.State == to {
+		s.lock.Unlock()
+		return nil
+	}
+	allowed := false
+	for _, st := range domainTransitions[d.State] {
+		allowed = allowed || st == to
+	}
+	if !allowed {
+		from := d.State
+		s.lock.Unlock()
+		return fmt.Errorf("domain '%s' can not move from %s to %s", name, from, to)
+	}
+
+	now := time.Now()
+	d.History = append(d.History, domainTransition{From: d.State, To: to, At: now, Registrar: registrar, Reason: reason})
+	if n := len(d.History) - s.historySize; n > 0 {
+		d.History = append([]domainTransition(nil), d.History[n:]...)
+	}
+	d.State, d.Since = to, now
+	s.lock.Unlock()
+
+	s.persist(name)
+	return nil
+}
+
+// move transitions the domain and logs a refused transition
+func (s *domainStates) move(name string, to domainState, registrar, reason string) {
+	if err := s.transition(name, to, registrar, reason); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// checked records the outcome of a check of the domain
+func (s *domainStates) checked(name string, status checker.Status, registrar string, at time.Time) {
+	s.lock.Lock()
+	d, ok := s.domains[name]
+	if ok {
+		d.LastCheck = &domainCheck{At: at, Status: status.String(), Registrar: registrar}
+	}
+	s.lock.Unlock()
+	if ok {
+		s.persist(name)
+	}
+}
+
+// persist stores the state of the domain or removes it when the domain is forgotten
+func (s *domainStates) persist(name string) {
+	if s.store == nil {
+		return
+	}
+	s.lock.Lock()
+	d, ok := s.domains[name]
+	var b []byte
+	var err error
+	if ok {
+		b, err = json.Marshal(d)
+	}
+	fence := s.fences[name]
+	s.lock.Unlock()
+
+	if err != nil {
+		log.Printf("Could not encode state of '%s': %v", name, err)
+		return
+	}
+	if err := saveRecord(s.store, bucketStates, name, b, fence); err != nil {
+		log.Printf("Could not persist state of '%s': %v", name, err)
+	}
+}
+
+// flush stores the state of all domains, it is used on shutdown
+func (s *domainStates) flush() error {
+	if s.store == nil {
+		return nil
+	}
+	s.lock.Lock()
+	records := make(map[string][]byte, len(s.domains))
+	for name, d := range s.domains {
+		b, err := json.Marshal(d)
+		if err != nil {
+			s.lock.Unlock()
+			return fmt.Errorf("could not encode state of '%s': %w", name, err)
+		}
+		records[name] = b
+	}
+	s.lock.Unlock()
+	return s.store.Save(bucketStates, records)
+}
+
+// load restores the states persisted before a restart, registrations that were interrupted
+// by the restart are marked failed
+func (s *domainStates) load() error {
+	return s.restore(nil)
+}
+
+// restore reads the states of the domains from the store, all domains when names is nil.
+// Domains without a stored state start watching.
+func (s *domainStates) restore(names []string) error {
+	if s.store == nil {
+		return nil
+	}
+	m, err := s.store.Load(bucketStates)
+	if err != nil {
+		return err
+	}
+	if names != nil {
+		selected := make(map[string][]byte, len(names))
+		for _, name := range names {
+			if v, ok := m[name]; ok {
+				selected[name] = v
+			} else {
+				s.add(name)
+			}
+		}
+		m = selected
+	}
+
+	var interrupted []string
+	s.lock.Lock()
+	for name, d := range decodeStates(m) {
+		s.domains[name] = d
+		if d.State == stateRegistering {
+			interrupted = append(interrupted, name)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, name := range interrupted {
+		s.move(name, stateFailed, "", "registration interrupted by a restart")
+	}
+	return nil
+}
+
+// refresh replaces the states in memory with the stored ones without writing anything, it
+// lets an instance standing by serve the states the leader wrote
+func (s *domainStates) refresh() error {
+	if s.store == nil {
+		return nil
+	}
+	m, err := s.store.Load(bucketStates)
+	if err != nil {
+		return err
+	}
+	domains := decodeStates(m)
+	s.lock.Lock()
+	s.domains = domains
+	s.lock.Unlock()
+	return nil
+}
+
+// decodeStates decodes stored states by domain, invalid ones are skipped
+func decodeStates(m map[string][]byte) map[string]*watchedDomain {
+	domains := make(map[string]*watchedDomain, len(m))
+	for name, v := range m {
+		var d watchedDomain
+		if err := json.Unmarshal(v, &d); err != nil {
+			log.Printf("Skipping invalid state of '%s': %v", name, err)
+			continue
+		}
+		domains[name] = &d
+	}
+	return domains
+}
+
+func newDomainStates(store Store, historySize int) *domainStates {
+	if historySize < 1 {
+		historySize = 1
+	}
+	return &domainStates{
+		store:       store,
+		historySize: historySize,
+		domains:     make(map[string]*watchedDomain),
+		fences:      make(map[string]int64),
+	}
+}
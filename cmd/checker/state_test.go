@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// scriptedRegistrar answers checks and registrations with fixed statuses
+type scriptedRegistrar struct {
+	check    checker.Status
+	register checker.Status
+	err      error
+}
+
+func (scriptedRegistrar) Name() string { return "scripted" }
+func (s *scriptedRegistrar) CheckDomain(string) (checker.Status, error) {
+	return s.check, nil
+}
+func (s *scriptedRegistrar) RegisterDomain(string) (checker.Status, error) {
+	return s.register, s.err
+}
+
+func expectStates(t *testing.T, d watchedDomain, states ...domainState) {
+	t.Helper()
+	if len(d.History) != len(states)-1 {
+		t.Fatalf("Expected %d transitions, got %+v", len(states)-1, d.History)
+	}
+	for i, tr := range d.History {
+		if tr.From != states[i] || tr.To != states[i+1] {
+			t.Errorf("Expected transition %d to go from %s to %s, got %s to %s", i, states[i], states[i+1], tr.From, tr.To)
+		}
+	}
+	if d.State != states[len(states)-1] {
+		t.Errorf("Expected state %s, got %s", states[len(states)-1], d.State)
+	}
+}
+
+func TestDomainStatesTransition(t *testing.T) {
+	s := newDomainStates(nil, 3)
+	s.add("example.org")
+
+	if err := s.transition("example.org", stateProcessing, "", ""); err == nil {
+		t.Error("Expected watching to processing to be refused")
+	}
+	for _, to := range []domainState{stateAvailable, stateWatching, statePaused, stateWatching, stateAvailable} {
+		if err := s.transition("example.org", to, "scripted", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.transition("example.org", stateAvailable, "", ""); err != nil {
+		t.Errorf("Expected moving to the current state to be allowed, got %v", err)
+	}
+
+	d, _ := s.get("example.org")
+	expectStates(t, d, stateWatching, statePaused, stateWatching, stateAvailable)
+	if d.History[0].Registrar != "scripted" {
+		t.Errorf("Expected the registrar to be recorded, got '%s'", d.History[0].Registrar)
+	}
+}
+
+func TestCheckingStates(t *testing.T) {
+	r := &scriptedRegistrar{check: checker.Available, register: checker.Processing}
+	states := newDomainStates(nil, 10)
+	tr := newTracker([]checker.Registrar{r}, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking([]string{"example.org"}, []checker.Registrar{r}, nil, tr, states, nil, nil, 1, time.Hour, 0)
+
+	c.check("example.org", time.Now())
+	d, _ := c.domainState("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateProcessing)
+	if d.LastCheck == nil || d.LastCheck.Status != "available" || d.LastCheck.Registrar != "scripted" {
+		t.Errorf("Expected the last check to be recorded, got %+v", d.LastCheck)
+	}
+
+	tr.registrars = []checker.Registrar{&progressRegistrar{status: checker.Owned}}
+	tr.pending["example.org"].Registrar = "progress"
+	tr.poll()
+	d, _ = c.domainState("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateProcessing, stateOwned)
+
+	// owned domains are no longer checked
+	r.check = checker.Unavailable
+	c.check("example.org", time.Now())
+	if d, _ = c.domainState("example.org"); d.State != stateOwned {
+		t.Errorf("Expected the domain to stay owned, got %s", d.State)
+	}
+}
+
+func TestCheckingFailedRegistration(t *testing.T) {
+	r := &scriptedRegistrar{check: checker.Available, register: checker.Unavailable, err: errors.New("insufficient funds")}
+	states := newDomainStates(nil, 10)
+	tr := newTracker([]checker.Registrar{r}, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking([]string{"example.org"}, []checker.Registrar{r}, nil, tr, states, nil, nil, 1, time.Hour, 0)
+
+	c.check("example.org", time.Now())
+	r.check = checker.Taken
+	c.check("example.org", time.Now())
+
+	d, _ := c.domainState("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateFailed, stateWatching)
+	if d.History[2].Reason == "" {
+		t.Error("Expected the failure to be explained")
+	}
+
+	if err := c.pauseDomain("example.org"); err != nil {
+		t.Fatal(err)
+	}
+	r.check = checker.Available
+	c.check("example.org", time.Now())
+	if d, _ = c.domainState("example.org"); d.State != statePaused {
+		t.Errorf("Expected a paused domain not to be checked, got %s", d.State)
+	}
+}
+
+// pausingRegistrar pauses the domain while it is being checked and counts registrations
+type pausingRegistrar struct {
+	scriptedRegistrar
+	states    *domainStates
+	registers int
+}
+
+func (p *pausingRegistrar) CheckDomain(name string) (checker.Status, error) {
+	p.states.move(name, statePaused, "", "paused")
+	return p.check, nil
+}
+func (p *pausingRegistrar) RegisterDomain(name string) (checker.Status, error) {
+	p.registers++
+	return p.register, nil
+}
+
+func TestCheckingPausedDuringCheck(t *testing.T) {
+	states := newDomainStates(nil, 10)
+	r := &pausingRegistrar{scriptedRegistrar: scriptedRegistrar{check: checker.Available, register: checker.Owned}, states: states}
+	tr := newTracker([]checker.Registrar{r}, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking([]string{"example.org"}, []checker.Registrar{r}, nil, tr, states, nil, nil, 1, time.Hour, 0)
+
+	c.check("example.org", time.Now())
+	if r.registers != 0 {
+		t.Errorf("Expected a domain paused during its check not to be registered, got %d registrations", r.registers)
+	}
+	d, _ := c.domainState("example.org")
+	expectStates(t, d, stateWatching, statePaused)
+}
+
+func TestCheckingUntrackedProcessing(t *testing.T) {
+	r := &scriptedRegistrar{check: checker.Available, register: checker.Owned}
+	states := newDomainStates(nil, 10)
+	tr := newTracker([]checker.Registrar{r}, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking([]string{"example.org"}, []checker.Registrar{r}, nil, tr, states, nil, nil, 1, time.Hour, 0)
+	for _, to := range []domainState{stateAvailable, stateRegistering, stateProcessing} {
+		states.move("example.org", to, "", "")
+	}
+
+	// the tracker does not know the registration, the domain is checked and registered again
+	c.check("example.org", time.Now())
+	d, _ := c.domainState("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateProcessing, stateFailed, stateAvailable, stateRegistering, stateOwned)
+
+	if err := states.transition("example.org", stateWatching, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []domainState{stateAvailable, stateRegistering, stateProcessing, stateWatching} {
+		if err := states.transition("example.org", to, "", ""); err != nil {
+			t.Errorf("Expected the move to %s to be allowed, got %v", to, err)
+		}
+	}
+}
+
+// delegatedFilter reports every domain as registered
+type delegatedFilter struct{}
+
+func (delegatedFilter) Registered(string) (bool, error) { return true, nil }
+
+func TestCheckingFilteredDomain(t *testing.T) {
+	r := &scriptedRegistrar{check: checker.Available, register: checker.Unavailable, err: errors.New("insufficient funds")}
+	states := newDomainStates(nil, 10)
+	tr := newTracker([]checker.Registrar{r}, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking([]string{"example.org"}, []checker.Registrar{r}, nil, tr, states, nil, nil, 1, time.Hour, 0)
+
+	c.check("example.org", time.Now())
+	c.filter = delegatedFilter{}
+	c.check("example.org", time.Now())
+
+	d, _ := c.domainState("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateFailed, stateWatching)
+	if d.LastCheck == nil || d.LastCheck.Status != "taken" || d.LastCheck.Registrar != "pre-filter" {
+		t.Errorf("Expected the pre-filter to be recorded as the last check, got %+v", d.LastCheck)
+	}
+}
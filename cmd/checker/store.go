@@ -0,0 +1,50 @@
+package main
+
+import "errors"
+
+// The buckets records are stored in
+const (
+	// bucketStates holds the state of every watched domain by name
+	bucketStates = "states"
+	// bucketPending holds the registrations that are still being followed up on by name
+	bucketPending = "pending"
+)
+
+// Store persists the watched domains, their state and the pending registrations so they
+// survive restarts. Records are kept in buckets by the name of their domain.
+type Store interface {
+	// Domains returns the watched domains in alphabetical order
+	Domains() ([]string, error)
+	// AddDomain adds a watched domain, adding a known domain does nothing
+	AddDomain(name string) error
+	// RemoveDomain removes a watched domain together with its records in every bucket
+	RemoveDomain(name string) error
+	// Load returns all records in the bucket by key
+	Load(bucket string) (map[string][]byte, error)
+	// Save stores the records in the bucket at once, a nil value deletes the record. Records
+	// of domains that are not watched are left out.
+	Save(bucket string, records map[string][]byte) error
+	// Close releases the store
+	Close() error
+}
+
+// errFenced is returned when a record was written with a higher fencing token
+var errFenced = errors.New("fenced off by a later registration lock")
+
+// fencedStore is implemented by stores shared by instances that lock registrations. It keeps
+// an instance that lost the registration lock of a domain from overwriting the records written
+// by the instance that took the lock over.
+type fencedStore interface {
+	// SaveFenced stores the record of a domain like Save unless a record of the domain was
+	// stored with a higher fencing token, in which case it returns errFenced
+	SaveFenced(bucket, name string, record []byte, fence int64) error
+}
+
+// saveRecord stores the record of a domain, with the fencing token of its registration lock
+// when the store supports it and the domain is locked
+func saveRecord(store Store, bucket, name string, record []byte, fence int64) error {
+	if fs, ok := store.(fencedStore); ok && fence > 0 {
+		return fs.SaveFenced(bucket, name, record, fence)
+	}
+	return store.Save(bucket, map[string][]byte{name: record})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// The operations recorded in the log of a file store
+const (
+	fileOpAdd    = "add"
+	fileOpRemove = "remove"
+	fileOpPut    = "put"
+	fileOpDelete = "delete"
+)
+
+// fileCompactMin is the amount of records a log holds before it is compacted at all
+const fileCompactMin = 1000
+
+// fileRecord is a single line in the log of a file store
+type fileRecord struct {
+	Op     string `json:"op"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+}
+
+// fileStore keeps everything in memory and appends every change to a single log file, one
+// JSON record per line, which is replayed when the store is opened. Once the log holds more
+// than twice the records needed to describe the current contents it is rewritten. Changes
+// are synced to disk before they are reported saved. The file is meant for a single daemon.
+type fileStore struct {
+	path string
+
+	lock    sync.Mutex
+	file    *os.File
+	records int
+	domains map[string]bool
+	buckets map[string]map[string][]byte
+}
+
+// Domains returns the watched domains
+func (s *fileStore) Domains() ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	domains := make([]string, 0, len(s.domains))
+	for name := range s.domains {
+		domains = append(domains, name)
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+// AddDomain appends the domain to the log
+func (s *fileStore) AddDomain(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.domains[name] {
+		return nil
+	}
+	return s.write(fileRecord{Op: fileOpAdd, Key: name})
+}
+
+// RemoveDomain appends the removal of the domain and its records to the log
+func (s *fileStore) RemoveDomain(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.write(fileRecord{Op: fileOpRemove, Key: name})
+}
+
+// Load returns all records in the bucket
+func (s *fileStore) Load(bucket string) (map[string][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records := make(map[string][]byte, len(s.buckets[bucket]))
+	for k, v := range s.buckets[bucket] {
+		records[k] = append([]byte(nil), v...)
+	}
+	return records, nil
+}
+
+// Save appends the records of watched domains to the log
+func (s *fileStore) Save(bucket string, records map[string][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	recs := make([]fileRecord, 0, len(records))
+	for k, v := range records {
+		if !s.domains[k] {
+			continue
+		}
+		if v == nil {
+			recs = append(recs, fileRecord{Op: fileOpDelete, Bucket: bucket, Key: k})
+		} else {
+			recs = append(recs, fileRecord{Op: fileOpPut, Bucket: bucket, Key: k, Value: v})
+		}
+	}
+	return s.write(recs...)
+}
+
+// Close closes the log file
+func (s *fileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// write appends the records to the log and applies them
+func (s *fileStore) write(records ...fileRecord) error {
+	if err := s.append(records...); err != nil {
+		return err
+	}
+	for _, r := range records {
+		s.apply(r)
+	}
+	return s.compact()
+}
+
+// append writes the records to the log and syncs it
+func (s *fileStore) append(records ...fileRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	if _, err := s.file.Write(buf); err != nil {
+		return fmt.Errorf("could not write to store '%s': %w", s.path, err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync store '%s': %w", s.path, err)
+	}
+	s.records += len(records)
+	return nil
+}
+
+// apply changes the contents in memory according to the record
+func (s *fileStore) apply(r fileRecord) {
+	switch r.Op {
+	case fileOpAdd:
+		s.domains[r.Key] = true
+	case fileOpRemove:
+		delete(s.domains, r.Key)
+		for _, m := range s.buckets {
+			delete(m, r.Key)
+		}
+	case fileOpPut:
+		if s.buckets[r.Bucket] == nil {
+			s.buckets[r.Bucket] = make(map[string][]byte)
+		}
+		s.buckets[r.Bucket][r.Key] = r.Value
+	case fileOpDelete:
+		delete(s.buckets[r.Bucket], r.Key)
+	}
+}
+
+// snapshot returns the records describing the current contents
+func (s *fileStore) snapshot() []fileRecord {
+	records := make([]fileRecord, 0, len(s.domains))
+	for name := range s.domains {
+		records = append(records, fileRecord{Op: fileOpAdd, Key: name})
+	}
+	for bucket, m := range s.buckets {
+		for k, v := range m {
+			records = append(records, fileRecord{Op: fileOpPut, Bucket: bucket, Key: k, Value: v})
+		}
+	}
+	return records
+}
+
+// compact rewrites the log when it grew too large, the new log replaces the old one at once
+func (s *fileStore) compact() error {
+	live := len(s.domains)
+	for _, m := range s.buckets {
+		live += len(m)
+	}
+	if s.records < fileCompactMin || s.records < 2*live {
+		return nil
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not compact store '%s': %w", s.path, err)
+	}
+	old, records := s.file, s.records
+	s.file, s.records = f, 0
+	if err := s.append(s.snapshot()...); err != nil {
+		s.file, s.records = old, records
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		s.file, s.records = old, records
+		os.Remove(tmp)
+		return fmt.Errorf("could not compact store '%s': %w", s.path, err)
+	}
+	old.Close()
+	syncDir(filepath.Dir(s.path))
+	if s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return fmt.Errorf("could not reopen store '%s': %w", s.path, err)
+	}
+	return nil
+}
+
+// replay reads the log into memory. A broken last line, left by a crash during a write, is
+// cut off, a broken line elsewhere means the file is corrupt.
+func (s *fileStore) replay(f *os.File) error {
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		var rec fileRecord
+		if jsonErr := json.Unmarshal(line, &rec); jsonErr != nil || line[len(line)-1] != '\n' {
+			if _, err := r.Peek(1); err != io.EOF {
+				return fmt.Errorf("store '%s' is corrupt at offset %d", s.path, offset)
+			}
+			log.Printf("Cutting off incomplete record at the end of store '%s'", s.path)
+			return f.Truncate(offset)
+		}
+		s.apply(rec)
+		s.records++
+		offset += int64(len(line))
+	}
+}
+
+// syncDir syncs a directory so a rename in it is durable, not every platform supports it
+func syncDir(path string) {
+	if d, err := os.Open(path); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// openFileStore opens the store at path, creating it when it does not exist
+func openFileStore(path string) (*fileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open store '%s': %w", path, err)
+	}
+	s := &fileStore{path: path, domains: make(map[string]bool), buckets: make(map[string]map[string][]byte)}
+	if err := s.replay(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	f.Close()
+	if s.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return nil, fmt.Errorf("could not open store '%s': %w", path, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempStorePath(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "checker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "state.log"), func() { os.RemoveAll(dir) }
+}
+
+// expectScheduleRestored watches a domain on its own interval and jitter and checks that an
+// instance started on the reopened store, and a leader picking the domain up, check it on them
+func expectScheduleRestored(t *testing.T, store Store, reopen func() Store) {
+	t.Helper()
+	jitter := time.Duration(0)
+	c := newChecking(nil, nil, store, nil, newDomainStates(store, 10), nil, nil, 1, time.Hour, time.Second)
+	c.addDomain("example.org", watchOptions{Interval: 5 * time.Minute, Jitter: &jitter})
+
+	store = reopen()
+	domains, err := store.Domains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := newDomainStates(store, 10)
+	if err := states.load(); err != nil {
+		t.Fatal(err)
+	}
+	started := newChecking(domains, nil, store, nil, states, nil, nil, 1, time.Hour, time.Second)
+	leader := newChecking(nil, nil, store, nil, newDomainStates(store, 10), nil, nil, 1, time.Hour, time.Second)
+	if err := leader.sync(); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]*checking{"restarted": started, "leader": leader} {
+		d, ok := c.scheduler.domains["example.org"]
+		if !ok || d.interval != 5*time.Minute || d.jitter != 0 {
+			t.Errorf("Expected the %s instance to check every 5m without jitter, got %+v", name, d)
+		}
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	s, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"example.org", "example.com", "example.net"} {
+		if err := s.AddDomain(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(bucketPending, map[string][]byte{"example.org": []byte(`{"a":1}`), "example.com": []byte(`{"b":2}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(bucketPending, map[string][]byte{"example.com": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(bucketStates, map[string][]byte{"example.net": []byte(`{"c":3}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveDomain("example.net"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if d, _ := s.Domains(); !reflect.DeepEqual(d, []string{"example.com", "example.org"}) {
+		t.Errorf("Expected the domains to survive a restart, got %v", d)
+	}
+	m, _ := s.Load(bucketPending)
+	if len(m) != 1 || string(m["example.org"]) != `{"a":1}` {
+		t.Errorf("Expected only the remaining record, got %v", m)
+	}
+	if m, _ := s.Load(bucketStates); len(m) != 0 {
+		t.Errorf("Expected the records of a removed domain to be gone, got %v", m)
+	}
+
+	if err := s.Save(bucketStates, map[string][]byte{"example.net": []byte(`{"c":4}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := s.Load(bucketStates); len(m) != 0 {
+		t.Errorf("Expected the records of a domain that is not watched to be left out, got %v", m)
+	}
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	s, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDomain("example.org"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < fileCompactMin+10; i++ {
+		if err := s.Save(bucketStates, map[string][]byte{"example.org": []byte(`{"n":1}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n > 20 {
+		t.Errorf("Expected the log to be compacted, it holds %d records", n)
+	}
+	s, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if m, _ := s.Load(bucketStates); string(m["example.org"]) != `{"n":1}` {
+		t.Errorf("Expected the record to survive compaction, got %v", m)
+	}
+}
+
+func TestFileStoreBrokenRecords(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	good := `{"op":"add","key":"example.org"}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(good+`{"op":"put","buck`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected an incomplete last record to be cut off, got %v", err)
+	}
+	if err := s.AddDomain("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if s, err = openFileStore(path); err != nil {
+		t.Fatalf("Expected the store to be usable after cutting off the record, got %v", err)
+	}
+	if d, _ := s.Domains(); !reflect.DeepEqual(d, []string{"example.com", "example.org"}) {
+		t.Errorf("Expected the domains written after the repair, got %v", d)
+	}
+	s.Close()
+
+	if err := ioutil.WriteFile(path, []byte("garbage\n"+good), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openFileStore(path); err == nil {
+		t.Error("Expected a broken record in the middle of the log to be refused")
+	}
+}
+
+func TestDomainStatesRestart(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDomain("example.org"); err != nil {
+		t.Fatal(err)
+	}
+	s := newDomainStates(store, 10)
+	s.add("example.org")
+	s.setOptions("example.org", watchOptions{Tags: []string{"brand"}})
+	s.move("example.org", stateAvailable, "scripted", "")
+	s.move("example.org", stateRegistering, "", "")
+	store.Close()
+
+	if store, err = openFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s = newDomainStates(store, 10)
+	if err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := s.get("example.org")
+	expectStates(t, d, stateWatching, stateAvailable, stateRegistering, stateFailed)
+	if len(d.Options.Tags) != 1 || d.Options.Tags[0] != "brand" {
+		t.Errorf("Expected the options to survive a restart, got %+v", d.Options)
+	}
+}
+
+func TestFileStoreSchedule(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectScheduleRestored(t, store, func() Store {
+		store.Close()
+		if store, err = openFileStore(path); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+	store.Close()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/go-redis/redis"
+)
+
+// Keys within Redis
+const (
+	// RedisSchemaKey holds the version of the layout of the keys below
+	RedisSchemaKey = "checker:schema"
+	// RedisDomainsKey is the set of watched domains
+	RedisDomainsKey = "checker:domains"
+	// RedisDomainPrefix starts the key of the hash per domain, its fields are the buckets
+	RedisDomainPrefix = "checker:domain:"
+)
+
+// redisFenceField is the field of the hash of a domain holding the highest fencing token its
+// records were written with
+const redisFenceField = "fence"
+
+// RedisListKey defines the key within Redis that is used for caching the domain name list in
+// the legacy layout, schema version 1. It is migrated when the store is opened.
+const RedisListKey = "checker_domain_list"
+
+// redisSchemaVersion is the version of the layout this daemon uses
+const redisSchemaVersion = 2
+
+// redisStore keeps the watched domains in a set and the records of every domain in a hash
+// per domain, with a field per bucket. Changes spanning keys are made in a transaction.
+type redisStore struct {
+	client *redis.Client
+}
+
+// Domains returns the members of the domain set
+func (s *redisStore) Domains() ([]string, error) {
+	domains, err := s.client.SMembers(RedisDomainsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+// AddDomain adds the domain to the set
+func (s *redisStore) AddDomain(name string) error {
+	return s.client.SAdd(RedisDomainsKey, name).Err()
+}
+
+// RemoveDomain removes the domain from the set and deletes its hash
+func (s *redisStore) RemoveDomain(name string) error {
+	_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.SRem(RedisDomainsKey, name)
+		pipe.Del(RedisDomainPrefix + name)
+		return nil
+	})
+	return err
+}
+
+// Load returns the field of the bucket from the hash of every watched domain that has it
+func (s *redisStore) Load(bucket string) (map[string][]byte, error) {
+	domains, err := s.client.SMembers(RedisDomainsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.StringCmd, len(domains))
+	_, err = s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, name := range domains {
+			cmds[i] = pipe.HGet(RedisDomainPrefix+name, bucket)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	records := make(map[string][]byte, len(domains))
+	for i, cmd := range cmds {
+		b, err := cmd.Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records[domains[i]] = b
+	}
+	return records, nil
+}
+
+// Save sets or deletes the field of the bucket in the hashes of the watched domains in one
+// transaction. The domain set is watched, so a domain removed meanwhile does not get its hash
+// back.
+func (s *redisStore) Save(bucket string, records map[string][]byte) error {
+	if len(records) == 0 {
+		return nil
+	}
+	for {
+		err := s.client.Watch(func(tx *redis.Tx) error {
+			return s.saveTx(tx, bucket, records)
+		}, RedisDomainsKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+func (s *redisStore) saveTx(tx *redis.Tx, bucket string, records map[string][]byte) error {
+	domains, err := tx.SMembers(RedisDomainsKey).Result()
+	if err != nil {
+		return err
+	}
+	watched := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		watched[d] = true
+	}
+	_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+		for name, v := range records {
+			switch {
+			case !watched[name]:
+			case v == nil:
+				pipe.HDel(RedisDomainPrefix+name, bucket)
+			default:
+				pipe.HSet(RedisDomainPrefix+name, bucket, v)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// SaveFenced sets or deletes the field of the bucket in the hash of a watched domain and
+// raises its fencing token, unless the hash holds a higher token. The hash is watched, so a
+// later lock holder writing meanwhile wins.
+func (s *redisStore) SaveFenced(bucket, name string, record []byte, fence int64) error {
+	key := RedisDomainPrefix + name
+	for {
+		err := s.client.Watch(func(tx *redis.Tx) error {
+			watched, err := tx.SIsMember(RedisDomainsKey, name).Result()
+			if err != nil || !watched {
+				return err
+			}
+			stored, err := tx.HGet(key, redisFenceField).Int64()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if stored > fence {
+				return errFenced
+			}
+			_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+				if record == nil {
+					pipe.HDel(key, bucket)
+				} else {
+					pipe.HSet(key, bucket, record)
+				}
+				pipe.HSet(key, redisFenceField, fence)
+				return nil
+			})
+			return err
+		}, RedisDomainsKey, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// Close closes the connection to Redis
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+// migrate brings the layout in Redis up to the current schema version. The legacy layout has
+// no version key, its list is moved into the domain set. When another daemon migrates at the
+// same time one of them retries and finds nothing to do.
+func (s *redisStore) migrate() error {
+	for {
+		err := s.client.Watch(s.migrateTx, RedisSchemaKey, RedisListKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+func (s *redisStore) migrateTx(tx *redis.Tx) error {
+	version, err := tx.Get(RedisSchemaKey).Int()
+	switch {
+	case err == redis.Nil:
+		version = 1
+	case err != nil:
+		return fmt.Errorf("could not read the schema version: %w", err)
+	}
+	if version > redisSchemaVersion {
+		return fmt.Errorf("schema version %d is newer than version %d this daemon supports", version, redisSchemaVersion)
+	}
+	if version == redisSchemaVersion {
+		return nil
+	}
+
+	domains, err := tx.LRange(RedisListKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("could not read the legacy domain list: %w", err)
+	}
+	_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, d := range domains {
+			pipe.SAdd(RedisDomainsKey, d)
+		}
+		pipe.Del(RedisListKey)
+		pipe.Set(RedisSchemaKey, redisSchemaVersion, 0)
+		return nil
+	})
+	if err == nil && len(domains) > 0 {
+		log.Printf("Migrated %d domains to Redis schema version %d", len(domains), redisSchemaVersion)
+	}
+	return err
+}
+
+// newRedisStore returns a store on the Redis connection, migrating the layout when needed
+func newRedisStore(client *redis.Client) (*redisStore, error) {
+	s := &redisStore{client: client}
+	if err := s.migrate(); err != nil {
+		return nil, fmt.Errorf("error while migrating Redis: %w", err)
+	}
+	return s, nil
+}
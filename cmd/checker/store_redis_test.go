@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedisStoreMigrate(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	client.LPush(RedisListKey, "example.org", "example.com")
+
+	s, err := newRedisStore(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{RedisDomainsKey, RedisSchemaKey}
+	if keys := standIn.keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected the legacy list to be replaced by %v, got %v", expected, keys)
+	}
+	if d, err := s.Domains(); err != nil || !reflect.DeepEqual(d, []string{"example.com", "example.org"}) {
+		t.Errorf("Expected the migrated domains, got %v and %v", d, err)
+	}
+
+	// the domains survive opening the store again
+	if _, err := newRedisStore(client); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := s.Domains(); len(d) != 2 {
+		t.Errorf("Expected the domains to survive a restart, got %v", d)
+	}
+
+	client.Set(RedisSchemaKey, redisSchemaVersion+1, 0)
+	if _, err := newRedisStore(client); err == nil {
+		t.Error("Expected a newer schema to be refused")
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	s, err := newRedisStore(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"example.org", "example.com"} {
+		if err := s.AddDomain(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(bucketStates, map[string][]byte{"example.org": []byte("a"), "example.com": []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(bucketPending, map[string][]byte{"example.org": []byte("c")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(bucketStates, map[string][]byte{"example.org": nil}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, _ := s.Load(bucketStates); !reflect.DeepEqual(m, map[string][]byte{"example.com": []byte("b")}) {
+		t.Errorf("Expected only the remaining state, got %v", m)
+	}
+	if m, _ := s.Load(bucketPending); !reflect.DeepEqual(m, map[string][]byte{"example.org": []byte("c")}) {
+		t.Errorf("Expected the pending registration, got %v", m)
+	}
+
+	if err := s.RemoveDomain("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := s.Domains(); !reflect.DeepEqual(d, []string{"example.org"}) {
+		t.Errorf("Expected the removed domain to be gone, got %v", d)
+	}
+	if m, _ := s.Load(bucketStates); len(m) != 0 {
+		t.Errorf("Expected the records of the removed domain to be gone, got %v", m)
+	}
+
+	// a late write for the removed domain does not bring its hash back
+	if err := s.Save(bucketPending, map[string][]byte{"example.com": []byte("d")}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := standIn.keys(); !reflect.DeepEqual(keys, []string{"checker:domain:example.org", RedisDomainsKey, RedisSchemaKey}) {
+		t.Errorf("Expected no hash for the removed domain, got %v", keys)
+	}
+	client.HSet(RedisDomainPrefix+"orphan.org", bucketPending, "e")
+	if m, _ := s.Load(bucketPending); !reflect.DeepEqual(m, map[string][]byte{"example.org": []byte("c")}) {
+		t.Errorf("Expected orphan hashes to be ignored, got %v", m)
+	}
+}
+
+func TestRedisStoreSchedule(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	s, err := newRedisStore(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectScheduleRestored(t, s, func() Store {
+		s, err := newRedisStore(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestRedisStoreFenced(t *testing.T) {
+	standIn, client := newRedisStandIn(t)
+	defer standIn.Close()
+	defer client.Close()
+
+	s, err := newRedisStore(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDomain("example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveFenced(bucketStates, "example.org", []byte("a"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveFenced(bucketStates, "example.org", []byte("b"), 1); err != errFenced {
+		t.Errorf("Expected a write with a lower fencing token to be refused, got %v", err)
+	}
+	if err := s.SaveFenced(bucketPending, "example.org", []byte("c"), 2); err != nil {
+		t.Errorf("Expected a write with the same fencing token to be stored, got %v", err)
+	}
+	if m, _ := s.Load(bucketStates); string(m["example.org"]) != "a" {
+		t.Errorf("Expected the record of the later lock holder to be kept, got %v", m)
+	}
+
+	// writes without a lock are not fenced
+	if err := s.Save(bucketStates, map[string][]byte{"example.org": []byte("d")}); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := s.Load(bucketStates); string(m["example.org"]) != "d" {
+		t.Errorf("Expected an unfenced write to be stored, got %v", m)
+	}
+
+	if err := s.SaveFenced(bucketStates, "example.net", []byte("e"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if keys := standIn.keys(); !reflect.DeepEqual(keys, []string{"checker:domain:example.org", RedisDomainsKey, RedisSchemaKey}) {
+		t.Errorf("Expected no hash for a domain that is not watched, got %v", keys)
+	}
+}
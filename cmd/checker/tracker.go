@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// pendingRegistration is a registration that was answered with Processing
+type pendingRegistration struct {
+	Domain    string    `json:"domain"`
+	Registrar string    `json:"registrar"`
+	Started   time.Time `json:"started"`
+	LastPoll  time.Time `json:"lastPoll"`
+	Polls     int       `json:"polls"`
+	Escalated bool      `json:"escalated"`
+	// Fence is the fencing token of the registration lock, zero without one
+	Fence int64 `json:"fence,omitempty"`
+}
+
+// tracker follows up on registrations until the registrar reports the domain as owned, the
+// registration failed or it took too long.
+type tracker struct {
+	store      Store
+	registrars []checker.Registrar
+	states     *domainStates
+
+	// interval is the time between polls, escalateAfter the time after which a
+	// registration that is still running gets reported loudly and timeout the time
+	// after which the registration is considered failed
+	interval      time.Duration
+	escalateAfter time.Duration
+	timeout       time.Duration
+
+	lock    sync.Mutex
+	pending map[string]*pendingRegistration
+}
+
+// track starts following up on the registration of name at registrar r, fence is the fencing
+// token of the registration lock
+func (t *tracker) track(name string, r checker.Registrar, fence int64) {
+	t.lock.Lock()
+	t.pending[name] = &pendingRegistration{
+		Domain:    name,
+		Registrar: checker.RegistrarName(r),
+		Started:   time.Now(),
+		Fence:     fence,
+	}
+	t.lock.Unlock()
+	t.persist(name)
+	log.Printf("Tracking registration of '%s' at %s", name, checker.RegistrarName(r))
+}
+
+// forget stops following up on the registration of a domain that is no longer watched
+func (t *tracker) forget(name string) {
+	t.lock.Lock()
+	_, ok := t.pending[name]
+	delete(t.pending, name)
+	t.lock.Unlock()
+	if ok {
+		t.persist(name)
+	}
+}
+
+// isPending reports whether a registration for name is still running
+func (t *tracker) isPending(name string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	_, ok := t.pending[name]
+	return ok
+}
+
+func (t *tracker) registrar(name string) checker.Registrar {
+	for _, r := range t.registrars {
+		if checker.RegistrarName(r) == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// poll asks the registrars about every pending registration once
+func (t *tracker) poll() {
+	t.lock.Lock()
+	pending := make([]*pendingRegistration, 0, len(t.pending))
+	for _, p := range t.pending {
+		pending = append(pending, p)
+	}
+	t.lock.Unlock()
+
+	for _, p := range pending {
+		if t.pollOne(p) {
+			t.lock.Lock()
+			delete(t.pending, p.Domain)
+			t.lock.Unlock()
+		}
+		t.persist(p.Domain)
+	}
+}
+
+// pollOne follows up on a single registration and reports whether it is finished
+func (t *tracker) pollOne(p *pendingRegistration) bool {
+	s, err := checker.Processing, error(nil)
+	r := t.registrar(p.Registrar)
+	switch sr := r.(type) {
+	case nil:
+		err = errors.New("registrar is no longer configured")
+	case checker.RegistrationStatusReader:
+		s, err = sr.RegistrationStatus(p.Domain)
+	default:
+		// without a way to ask about the registration we wait for the domain to show up
+		if s, err = r.CheckDomain(p.Domain); err == nil && s != checker.Owned {
+			s = checker.Processing
+		}
+	}
+
+	t.lock.Lock()
+	p.LastPoll = time.Now()
+	p.Polls++
+	t.lock.Unlock()
+	age := time.Since(p.Started)
+
+	switch {
+	case errors.Is(err, checker.ErrRegistrationFailed):
+		log.Printf("Registration of '%s' at %s failed: %v", p.Domain, p.Registrar, err)
+		t.states.move(p.Domain, stateFailed, p.Registrar, err.Error())
+		return true
+	case err == nil && s == checker.Owned:
+		log.Printf("Registration of '%s' at %s completed after %s", p.Domain, p.Registrar, age.Round(time.Second))
+		t.states.move(p.Domain, stateOwned, p.Registrar, "registration completed")
+		return true
+	case err != nil && r != nil:
+		err = checker.NewOperationError(r, checker.OperationRegister, p.Domain, 0, p.Polls, err)
+		log.Printf("Could not read registration status: %v", err)
+	case err != nil:
+		log.Printf("Could not read registration status of '%s' at %s: %v", p.Domain, p.Registrar, err)
+	}
+
+	if age > t.timeout {
+		log.Printf("ESCALATION: registration of '%s' at %s timed out after %s, giving up", p.Domain, p.Registrar, age.Round(time.Second))
+		t.states.move(p.Domain, stateFailed, p.Registrar, fmt.Sprintf("registration timed out after %s", age.Round(time.Second)))
+		return true
+	}
+	if age > t.escalateAfter && !p.Escalated {
+		t.lock.Lock()
+		p.Escalated = true
+		t.lock.Unlock()
+		log.Printf("ESCALATION: registration of '%s' at %s is still pending after %s", p.Domain, p.Registrar, age.Round(time.Second))
+	}
+	return false
+}
+
+// run polls the pending registrations until done is closed
+func (t *tracker) run(done <-chan struct{}) {
+	tick := time.NewTicker(t.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			t.poll()
+		}
+	}
+}
+
+// persist stores the state of the registration for name or removes it when it is no longer
+// pending
+func (t *tracker) persist(name string) {
+	if t.store == nil {
+		return
+	}
+	t.lock.Lock()
+	p, ok := t.pending[name]
+	var b []byte
+	var err error
+	var fence int64
+	if ok {
+		b, err = json.Marshal(p)
+		fence = p.Fence
+	}
+	t.lock.Unlock()
+
+	if err != nil {
+		log.Printf("Could not encode pending registration of '%s': %v", name, err)
+		return
+	}
+	if err := saveRecord(t.store, bucketPending, name, b, fence); err != nil {
+		log.Printf("Could not persist pending registration of '%s': %v", name, err)
+	}
+}
+
+// flush stores the state of all pending registrations, it is used on shutdown
+func (t *tracker) flush() error {
+	if t.store == nil {
+		return nil
+	}
+	t.lock.Lock()
+	records := make(map[string][]byte, len(t.pending))
+	for name, p := range t.pending {
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.lock.Unlock()
+			return fmt.Errorf("could not encode pending registration of '%s': %w", name, err)
+		}
+		records[name] = b
+	}
+	t.lock.Unlock()
+	return t.store.Save(bucketPending, records)
+}
+
+// load restores the pending registrations persisted before a restart
+func (t *tracker) load() error {
+	if t.store == nil {
+		return nil
+	}
+	m, err := t.store.Load(bucketPending)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for name, v := range m {
+		var p pendingRegistration
+		if err := json.Unmarshal(v, &p); err != nil {
+			log.Printf("Skipping invalid pending registration of '%s': %v", name, err)
+			continue
+		}
+		t.pending[name] = &p
+	}
+	return nil
+}
+
+func newTracker(clients []checker.Registrar, store Store, s *domainStates, interval, escalateAfter, timeout time.Duration) *tracker {
+	return &tracker{
+		store:         store,
+		registrars:    clients,
+		states:        s,
+		interval:      interval,
+		escalateAfter: escalateAfter,
+		timeout:       timeout,
+		pending:       make(map[string]*pendingRegistration),
+	}
+}
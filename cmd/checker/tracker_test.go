@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// progressRegistrar reports the scripted registration status for every domain
+type progressRegistrar struct {
+	status checker.Status
+	err    error
+}
+
+func (progressRegistrar) Name() string                               { return "progress" }
+func (progressRegistrar) CheckDomain(string) (checker.Status, error) { return checker.Unavailable, nil }
+func (progressRegistrar) RegisterDomain(string) (checker.Status, error) {
+	return checker.Processing, nil
+}
+func (p *progressRegistrar) RegistrationStatus(string) (checker.Status, error) {
+	return p.status, p.err
+}
+
+func TestTrackerPoll(t *testing.T) {
+	r := &progressRegistrar{status: checker.Processing}
+	tr := newTracker([]checker.Registrar{r}, nil, newDomainStates(nil, 10), time.Minute, time.Hour, 2*time.Hour)
+	tr.track("example.org", r, 0)
+
+	tr.poll()
+	if !tr.isPending("example.org") {
+		t.Fatal("Expected the registration to still be pending")
+	}
+
+	r.status = checker.Owned
+	tr.poll()
+	if tr.isPending("example.org") {
+		t.Error("Expected the registration to be finished once owned")
+	}
+
+	r.status, r.err = checker.Unavailable, fmt.Errorf("%w: rejected", checker.ErrRegistrationFailed)
+	tr.track("rejected.org", r, 0)
+	tr.poll()
+	if tr.isPending("rejected.org") {
+		t.Error("Expected a failed registration to be dropped")
+	}
+}
+
+func TestTrackerTimeout(t *testing.T) {
+	r := &progressRegistrar{status: checker.Processing}
+	tr := newTracker([]checker.Registrar{r}, nil, newDomainStates(nil, 10), time.Minute, time.Hour, 2*time.Hour)
+	tr.track("example.org", r, 0)
+
+	tr.pending["example.org"].Started = time.Now().Add(-90 * time.Minute)
+	tr.poll()
+	if !tr.isPending("example.org") || !tr.pending["example.org"].Escalated {
+		t.Fatal("Expected the registration to be escalated but still pending")
+	}
+
+	tr.pending["example.org"].Started = time.Now().Add(-3 * time.Hour)
+	tr.poll()
+	if tr.isPending("example.org") {
+		t.Error("Expected the registration to be given up after the timeout")
+	}
+}
+
+func TestTrackerForgetRemovedDomain(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	r := &progressRegistrar{status: checker.Processing}
+	states := newDomainStates(store, 10)
+	tr := newTracker([]checker.Registrar{r}, store, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking(nil, []checker.Registrar{r}, store, tr, states, nil, nil, 1, time.Hour, 0)
+	c.addDomain("example.org", watchOptions{})
+	tr.track("example.org", r, 0)
+
+	c.removeDomain("example.org")
+	if tr.isPending("example.org") {
+		t.Error("Expected the registration of a removed domain to be forgotten")
+	}
+	if m, _ := store.Load(bucketPending); len(m) != 0 {
+		t.Errorf("Expected no pending registration to be stored, got %v", m)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// The modes a domain can be watched in
+const (
+	// watchRegister registers the domain as soon as it is available, it is the default
+	watchRegister = "register"
+	// watchNotify only reports the domain when it becomes available
+	watchNotify = "notify"
+)
+
+// watchOptions is what the team knows about a watched domain and how it should be handled
+type watchOptions struct {
+	Notes string   `json:"notes,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// User is the one who asked for the domain to be watched
+	User string `json:"user,omitempty"`
+	// Priority decides which domain is checked first when several are due, highest first
+	Priority int `json:"priority,omitempty"`
+	// MaxPrice is the most we pay for the domain in cents of Currency, zero means no maximum
+	MaxPrice int64  `json:"maxPrice,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	// Registrars are tried first, in order, when registering the domain
+	Registrars []string `json:"registrars,omitempty"`
+	Mode       string   `json:"mode,omitempty"`
+	// Interval and Jitter replace those of the scheduler for the domain. Jitter is a pointer so
+	// a jitter of zero can be told apart from no jitter given.
+	Interval time.Duration  `json:"interval,omitempty"`
+	Jitter   *time.Duration `json:"jitter,omitempty"`
+}
+
+// set changes a single option from a key=value pair
+func (o *watchOptions) set(option string) error {
+	i := strings.Index(option, "=")
+	if i < 1 {
+		return fmt.Errorf("invalid option '%s', expected key=value", option)
+	}
+	key, value := strings.ToLower(option[:i]), option[i+1:]
+	switch key {
+	case "note", "notes":
+		o.Notes = value
+	case "tags":
+		o.Tags = splitList(value)
+	case "user":
+		o.User = value
+	case "priority":
+		p, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid priority '%s'", value)
+		}
+		o.Priority = p
+	case "max-price":
+		amount, currency, err := parseMaxPrice(value)
+		if err != nil {
+			return err
+		}
+		o.MaxPrice, o.Currency = amount, currency
+	case "registrars":
+		o.Registrars = splitList(value)
+	case "mode":
+		switch value {
+		case watchRegister, watchNotify:
+			o.Mode = value
+		default:
+			return fmt.Errorf("invalid mode '%s', expected %s or %s", value, watchRegister, watchNotify)
+		}
+	default:
+		return fmt.Errorf("unknown option '%s'", key)
+	}
+	return nil
+}
+
+// setSchedule changes the interval and, when given, the jitter from durations like "5m". The
+// jitter can be zero to check the domain at exactly its interval.
+func (o *watchOptions) setSchedule(durations []string) error {
+	if len(durations) > 2 {
+		return fmt.Errorf("expected at most 2 durations")
+	}
+	for i, p := range durations {
+		v, err := time.ParseDuration(p)
+		if err != nil || v < 0 || v == 0 && i == 0 {
+			return fmt.Errorf("invalid duration '%s'", p)
+		}
+		if i == 0 {
+			o.Interval = v
+		} else {
+			o.Jitter = &v
+		}
+	}
+	return nil
+}
+
+// schedule returns the interval and jitter to check the domain with, a zero interval and a
+// negative jitter select the defaults of the scheduler
+func (o watchOptions) schedule() (interval, jitter time.Duration) {
+	jitter = -1
+	if o.Jitter != nil {
+		jitter = *o.Jitter
+	}
+	return o.Interval, jitter
+}
+
+// notifyOnly reports whether the domain should only be reported when it becomes available
+func (o watchOptions) notifyOnly() bool {
+	return o.Mode == watchNotify
+}
+
+// allows reports whether the price is within the maximum price. With a maximum price set an
+// unknown price, or a price in another currency, is not allowed.
+func (o watchOptions) allows(p *checker.Price) bool {
+	if o.MaxPrice == 0 {
+		return true
+	}
+	if p == nil || !strings.EqualFold(o.Currency, p.Currency) {
+		return false
+	}
+	return p.Amount <= o.MaxPrice
+}
+
+// registrars orders the registrars to register the domain at, preferred registrars come first.
+// With a maximum price only the registrars that reported a price within it in the check are
+// used.
+func (o watchOptions) registrars(all []checker.Registrar, statuses []checker.RegistrarStatus) []checker.Registrar {
+	prices := make(map[string]*checker.Price, len(statuses))
+	for _, s := range statuses {
+		prices[checker.RegistrarName(s.Registrar())] = s.Price()
+	}
+
+	ordered := make([]checker.Registrar, 0, len(all))
+	used := make(map[string]bool, len(all))
+	add := func(r checker.Registrar) {
+		name := checker.RegistrarName(r)
+		if used[name] || !o.allows(prices[name]) {
+			return
+		}
+		used[name] = true
+		ordered = append(ordered, r)
+	}
+	for _, name := range o.Registrars {
+		for _, r := range all {
+			if checker.RegistrarName(r) == name {
+				add(r)
+			}
+		}
+	}
+	for _, r := range all {
+		add(r)
+	}
+	return ordered
+}
+
+// parseMaxPrice parses a price like "12.50EUR" into cents and a currency, the currency is
+// required as prices in different currencies can not be compared
+func parseMaxPrice(s string) (int64, string, error) {
+	i := strings.IndexFunc(s, unicode.IsLetter)
+	if i < 0 {
+		return 0, "", fmt.Errorf("invalid maximum price '%s', the currency is missing like in 12.50EUR", s)
+	}
+	f, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || f <= 0 {
+		return 0, "", fmt.Errorf("invalid maximum price '%s'", s)
+	}
+	return int64(math.Round(f * 100)), strings.ToUpper(s[i:]), nil
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// splitFields splits a command line on white space, except inside double quotes which are
+// removed, so options like note="renew before May" stay together
+func splitFields(s string) []string {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted, inField = !quoted, true
+		case unicode.IsSpace(r) && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// pricedRegistrar reports the domain available at a price
+type pricedRegistrar struct {
+	name       string
+	price      *checker.Price
+	registered int
+}
+
+func (p *pricedRegistrar) Name() string { return p.name }
+func (p *pricedRegistrar) CheckDomain(n string) (checker.Status, error) {
+	d, err := p.CheckDomainDetail(n)
+	return d.Status, err
+}
+func (p *pricedRegistrar) CheckDomainDetail(string) (checker.Detail, error) {
+	return checker.Detail{Status: checker.Available, Price: p.price}, nil
+}
+func (p *pricedRegistrar) RegisterDomain(string) (checker.Status, error) {
+	p.registered++
+	return checker.Owned, nil
+}
+
+func TestSplitFields(t *testing.T) {
+	f := splitFields(`ADD example.org 5m note="renew before  May" tags=a,b ""` + "\n")
+	expected := []string{"ADD", "example.org", "5m", "note=renew before  May", "tags=a,b", ""}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("Expected %q, got %q", expected, f)
+	}
+}
+
+func TestWatchOptionsSet(t *testing.T) {
+	var o watchOptions
+	for _, opt := range []string{"note=brand", "tags=shop, brand", "user=jasper", "priority=3", "max-price=12.50eur", "registrars=porkbun,transip", "mode=notify"} {
+		if err := o.set(opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := watchOptions{
+		Notes:      "brand",
+		Tags:       []string{"shop", "brand"},
+		User:       "jasper",
+		Priority:   3,
+		MaxPrice:   1250,
+		Currency:   "EUR",
+		Registrars: []string{"porkbun", "transip"},
+		Mode:       watchNotify,
+	}
+	if !reflect.DeepEqual(o, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, o)
+	}
+
+	for _, opt := range []string{"colour=red", "priority=high", "max-price=free", "max-price=12.50", "mode=grab", "=x"} {
+		if err := o.set(opt); err == nil {
+			t.Errorf("Expected option '%s' to be refused", opt)
+		}
+	}
+}
+
+func TestCheckingWatchOptions(t *testing.T) {
+	cheap := &pricedRegistrar{name: "cheap", price: &checker.Price{Amount: 900, Currency: "EUR"}}
+	pricey := &pricedRegistrar{name: "pricey", price: &checker.Price{Amount: 5000, Currency: "EUR"}}
+	unpriced := &pricedRegistrar{name: "unpriced"}
+	registrars := []checker.Registrar{pricey, unpriced, cheap}
+	states := newDomainStates(nil, 10)
+	tr := newTracker(registrars, nil, states, time.Minute, time.Hour, 2*time.Hour)
+	c := newChecking(nil, registrars, nil, tr, states, nil, nil, 1, time.Hour, 0)
+
+	c.addDomain("notify.org", watchOptions{Mode: watchNotify})
+	c.check("notify.org", time.Now())
+	if d, _ := c.domainState("notify.org"); d.State != stateAvailable {
+		t.Errorf("Expected a notify-only domain to stay available, got %s", d.State)
+	}
+
+	c.addDomain("example.org", watchOptions{MaxPrice: 1000, Currency: "EUR", Registrars: []string{"cheap"}})
+	c.check("example.org", time.Now())
+	if cheap.registered != 1 || pricey.registered != 0 || unpriced.registered != 0 {
+		t.Errorf("Expected only the preferred registrar within the price to register, got %d, %d and %d", cheap.registered, pricey.registered, unpriced.registered)
+	}
+	if d, _ := c.domainState("example.org"); d.State != stateOwned || d.Options.Registrars[0] != "cheap" {
+		t.Errorf("Expected the domain to be owned with its options, got %+v", d)
+	}
+
+	if l := c.domainDetails(); len(l) != 2 || l[0].Domain != "example.org" {
+		t.Errorf("Expected the details of both domains, got %+v", l)
+	}
+}
+
+func TestWatchOptionsMaxPrice(t *testing.T) {
+	o := watchOptions{MaxPrice: 1000, Currency: "EUR"}
+	for _, p := range []*checker.Price{nil, {Amount: 900, Currency: "USD"}, {Amount: 1100, Currency: "EUR"}} {
+		if o.allows(p) {
+			t.Errorf("Expected price %+v not to be allowed", p)
+		}
+	}
+	if !o.allows(&checker.Price{Amount: 900, Currency: "eur"}) {
+		t.Error("Expected a price within the maximum to be allowed")
+	}
+	if !(watchOptions{}).allows(nil) {
+		t.Error("Expected any price to be allowed without a maximum")
+	}
+}
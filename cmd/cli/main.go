@@ -109,12 +109,16 @@ func main() {
 		cli.Command{
 			Name:    "list",
 			Aliases: []string{"l"},
-			Usage:   "List all domains the server is currently watching with 'list'",
+			Usage:   "List all domains the server is currently watching with 'list', 'list details' shows their state and options as JSON",
 			Flags:   f,
 			Action: func(c *cli.Context) error {
+				cmd := "LIST\n"
+				if c.Args().First() == "details" {
+					cmd = "LIST details\n"
+				}
 				conn, _ := getConn(c)
 				defer closeConnection(conn)
-				if err := doCommand(conn, "LIST\n"); err != nil {
+				if err := doCommand(conn, cmd); err != nil {
 					return err
 				}
 				return nil
@@ -123,23 +127,36 @@ func main() {
 		cli.Command{
 			Name:    "add",
 			Aliases: []string{"a"},
-			Usage:   "Use 'add [domain]' to add a domain to the checker list",
+			Usage:   "Use 'add [domain] [interval] [jitter] [key=value...]' to add a domain to the checker list, optionally checking it every interval like '5m'. Options are note, tags, user, priority, max-price, registrars and mode",
 			Flags:   f,
 			Action: func(c *cli.Context) error {
 				if len(c.Args()) == 0 {
 					return errors.New("no domain name provided")
 				}
-				if len(c.Args()) > 1 {
-					return fmt.Errorf("too many parameters received: %v", c.Args())
-				}
 				domain := c.Args()[0]
 				if len(domain) > 255 {
 					return fmt.Errorf("domain name contains too many characters: %s", domain)
 				}
+				params := []string{domain}
+				durations := 0
+				for _, a := range c.Args()[1:] {
+					if i := strings.Index(a, "="); i > 0 {
+						// the server keeps quoted values together
+						params = append(params, a[:i+1]+`"`+a[i+1:]+`"`)
+						continue
+					}
+					if _, err := time.ParseDuration(a); err != nil {
+						return fmt.Errorf("invalid duration: %s", a)
+					}
+					if durations++; durations > 2 {
+						return fmt.Errorf("too many durations received: %v", c.Args())
+					}
+					params = append(params, a)
+				}
 				conn, _ := getConn(c)
 				defer closeConnection(conn)
 
-				if err := doCommand(conn, "ADD "+domain+"\n"); err != nil {
+				if err := doCommand(conn, "ADD "+strings.Join(params, " ")+"\n"); err != nil {
 					return err
 				}
 				return nil
@@ -170,6 +187,9 @@ func main() {
 				return nil
 			},
 		},
+		domainCommand("state", "s", "Use 'state [domain]' to show the state of a domain and how it got there", "STATE", f),
+		domainCommand("pause", "p", "Use 'pause [domain]' to stop checking a domain until it is resumed", "PAUSE", f),
+		domainCommand("resume", "", "Use 'resume [domain]' to start checking a paused or owned domain again", "RESUME", f),
 		cli.Command{
 			Name:  "set",
 			Usage: "Use 'set [name] [value]' to persist variables to the cli tool config",
@@ -185,6 +205,32 @@ func main() {
 	}
 }
 
+// domainCommand returns a command sending the server command for a single domain
+func domainCommand(name, alias, usage, serverCommand string, f []cli.Flag) cli.Command {
+	cmd := cli.Command{
+		Name:  name,
+		Usage: usage,
+		Flags: f,
+		Action: func(c *cli.Context) error {
+			if len(c.Args()) != 1 {
+				return fmt.Errorf("expected a single domain name, received: %v", c.Args())
+			}
+			domain := c.Args()[0]
+			if len(domain) > 255 {
+				return fmt.Errorf("domain name contains too many characters: %s", domain)
+			}
+			conn, _ := getConn(c)
+			defer closeConnection(conn)
+
+			return doCommand(conn, serverCommand+" "+domain+"\n")
+		},
+	}
+	if alias != "" {
+		cmd.Aliases = []string{alias}
+	}
+	return cmd
+}
+
 func createConnection(host string, port int, forceUnsafe, allowUnsafe bool) (net.Conn, error) {
 	var conn net.Conn
 	var err error
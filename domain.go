@@ -1,8 +1,6 @@
 package checker
 
-import (
-	"fmt"
-)
+import "fmt"
 
 // Status wraps statuses this package will act upon
 type Status uint8
@@ -16,13 +14,39 @@ var (
 	Available Status = 0x02
 	// Processing is a special status that indicates we are already trying to get the domain
 	Processing Status = 0x04
+	// Taken tells the domain name is registered by someone else, it is not available either
+	Taken Status = 0x08
+	// Transferable tells the domain name is registered by someone else but can be transferred
+	// to us, like between accounts at the same registrar
+	Transferable Status = 0x10
 )
 
+// String returns the lower case name of the status
+func (s Status) String() string {
+	switch s {
+	case Unavailable:
+		return "unavailable"
+	case Owned:
+		return "owned"
+	case Available:
+		return "available"
+	case Processing:
+		return "processing"
+	case Taken:
+		return "taken"
+	case Transferable:
+		return "transferable"
+	}
+	return fmt.Sprintf("status(%d)", uint8(s))
+}
+
 // ClientStatus tells the status for a domain for a specific domain
 type RegistrarStatus struct {
 	c      Registrar
 	s      Status
 	domain string
+	raw    string
+	price  *Price
 }
 
 // Registrar reports the registrar to which this status applies
@@ -40,48 +64,77 @@ func (cs *RegistrarStatus) Domain() string {
 	return cs.domain
 }
 
+// Raw reports the unprocessed data the status is based on, it is only set for registrars
+// implementing DetailedChecker
+func (cs *RegistrarStatus) Raw() string {
+	return cs.raw
+}
+
+// Price reports the registration price, it is only set for registrars implementing
+// DetailedChecker that know their prices
+func (cs *RegistrarStatus) Price() *Price {
+	return cs.price
+}
+
 // CheckDomain will walk though the provided domainRegistrars and check on all of them if a specific domain
 // is available. The domainClients will be checked in order of appearance. The returning error does not mean
 // domain checking completely failed. It just states somewhere during checking an error occured at some
-// registrar in the chain.
+// registrar in the chain. When set it is a *MultipleError recording which registrar failed.
 func CheckDomain(name string, clients []Registrar) ([]RegistrarStatus, error) {
 	var errs *MultipleError
 	results := make([]RegistrarStatus, 0, len(clients))
 
-	for _, c := range clients {
-		if s, err := c.CheckDomain(name); err == nil {
-			results = append(results, RegistrarStatus{c, s, name})
+	for i, c := range clients {
+		if d, err := checkDomain(c, name); err == nil {
+			results = append(results, RegistrarStatus{c, d.Status, name, d.Raw, d.Price})
 		} else {
 			if errs == nil {
 				errs = NewMultipleError("received error during checking domain", len(clients))
-			} else {
-				errs.Add(NewError(c, fmt.Errorf("received error from provider '%T' while checking domain '%s': %w", c, name, err)))
 			}
+			errs.Add(NewOperationError(c, OperationCheck, name, i+1, 1, err))
 		}
 	}
-	return results, errs
+	if errs != nil {
+		return results, errs
+	}
+	return results, nil
+}
+
+// checkDomain checks the domain at a single registrar, with details when it supports them
+func checkDomain(c Registrar, name string) (Detail, error) {
+	if dc, ok := c.(DetailedChecker); ok {
+		return dc.CheckDomainDetail(name)
+	}
+	s, err := c.CheckDomain(name)
+	return Detail{Status: s}, err
 }
 
 // RegisterDomain will try to register a domain at a slice of given domainRegistrars. The first one to return a valid response
 // will own the domain. Please sort the domainClients in order of preference. Please check the RegistarStatus to see if the
-// registration was a success. The error will contain any error that occured with any registrar during registration attempts.
+// registration was a success. The error will contain any error that occured with any registrar during registration attempts,
+// when set it is a *MultipleError.
 func RegisterDomain(name string, clients []Registrar) (RegistrarStatus, error) {
 	var errs *MultipleError
-	for _, c := range clients {
+	for i, c := range clients {
 		if s, err := c.RegisterDomain(name); err == nil && (s == Owned || s == Processing) {
 			cs := RegistrarStatus{
 				c:      c,
 				s:      s,
 				domain: name,
 			}
-			return cs, errs
+			if errs != nil {
+				return cs, errs
+			}
+			return cs, nil
 		} else if err != nil {
 			if errs == nil {
 				errs = NewMultipleError("received error during registering domain", len(clients))
-			} else {
-				errs.Add(NewError(c, fmt.Errorf("received error from provider '%T' while trying to register domain '%s': %w", c, name, err)))
 			}
+			errs.Add(NewOperationError(c, OperationRegister, name, i+1, 1, err))
 		}
 	}
-	return RegistrarStatus{}, errs
+	if errs != nil {
+		return RegistrarStatus{}, errs
+	}
+	return RegistrarStatus{}, nil
 }
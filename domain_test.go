@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -42,13 +43,22 @@ func TestCheckDomain(t *testing.T) {
 			{domainRegistrars[3], Processing},
 		}
 
-		statuses, _ := CheckDomain(name, domainRegistrars)
+		statuses, err := CheckDomain(name, domainRegistrars)
 
 		if gotLen := len(statuses); gotLen != expectLen {
 			t.Logf("Expected %d result statuses but received %d", expectLen, gotLen)
 			t.Fail()
 		}
 
+		var me *MultipleError
+		if !errors.As(err, &me) || me.Len() != 1 {
+			t.Logf("Expected a MultipleError holding 1 error but received %v", err)
+			t.Fail()
+		} else if e := me.Errors()[0]; e.Domain() != name || e.Operation() != OperationCheck || e.Position() != len(domainRegistrars) || e.Attempt() != 1 {
+			t.Logf("Expected the error to record the failed check, received %v", e)
+			t.Fail()
+		}
+
 		// test the results in order of registrar ordering, it should comply
 		for i, s := range expectedResults {
 			status := statuses[i]
@@ -70,10 +80,16 @@ func TestCheckDomain(t *testing.T) {
 
 func TestRegisterDomain(t *testing.T) {
 	t.Run("Test registering domains with success", func(t *testing.T) {
-		if s, _ := RegisterDomain(name, registerRegistrarsSuccess); s.Status() != Owned {
+		s, err := RegisterDomain(name, registerRegistrarsSuccess)
+		if s.Status() != Owned {
 			t.Logf("Expected %d result, got %d", Owned, s.Status())
 			t.Fail()
 		}
+		var me *MultipleError
+		if !errors.As(err, &me) || me.Len() != 1 || me.Errors()[0].Operation() != OperationRegister {
+			t.Logf("Expected the error of the failing registrar, got %v", err)
+			t.Fail()
+		}
 	})
 	t.Run("Test registering domains with failure", func(t *testing.T) {
 		if s, _ := RegisterDomain(name, registerRegistrarsFailure); s.Status() != Unavailable {
@@ -82,3 +98,35 @@ func TestRegisterDomain(t *testing.T) {
 		}
 	})
 }
+
+func TestCheckDomainWithoutErrors(t *testing.T) {
+	if _, err := CheckDomain(name, []Registrar{availableRegistrar{}}); err != nil {
+		t.Logf("Expected a nil error but received %v", err)
+		t.Fail()
+	}
+}
+
+func TestCheckDomainDetail(t *testing.T) {
+	statuses, _ := CheckDomain(name, []Registrar{detailedRegistrar{}})
+	if len(statuses) != 1 || statuses[0].Status() != Unavailable || statuses[0].Raw() != "taken" {
+		t.Logf("Expected the detailed result to be used, got %v", statuses)
+		t.Fail()
+	}
+	if p := statuses[0].Price(); p == nil || p.Amount != 1299 || !p.Premium {
+		t.Logf("Expected the price to be passed on, got %v", p)
+		t.Fail()
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	for s, expected := range map[Status]string{
+		Available:    "available",
+		Taken:        "taken",
+		Transferable: "transferable",
+		Status(0x20): "status(32)",
+	} {
+		if s.String() != expected {
+			t.Errorf("Expected status %d to be named '%s', got '%s'", uint8(s), expected, s.String())
+		}
+	}
+}
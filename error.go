@@ -1,18 +1,45 @@
 package checker
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
+)
+
+// Operation names the action that was performed when an error occured
+type Operation string
+
+const (
+	// OperationCheck is the availability check of a domain name
+	OperationCheck Operation = "check"
+	// OperationRegister is the registration of a domain name
+	OperationRegister Operation = "register"
+	// OperationTransfer is the transfer of a domain name to us
+	OperationTransfer Operation = "transfer"
 )
 
 // Error defines a structured error this package will use
 type Error struct {
 	registrar Registrar
+	name      string
+	domain    string
+	op        Operation
+	position  int
+	attempt   int
+	time      time.Time
 	err       error
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("%T: %s", e.registrar, e.err)
+	if e.op == "" {
+		return fmt.Sprintf("%s: %s", e.name, e.err)
+	}
+	where := fmt.Sprintf("attempt %d", e.attempt)
+	if e.position > 0 {
+		where = fmt.Sprintf("registrar %d, %s", e.position, where)
+	}
+	return fmt.Sprintf("%s: %s '%s' (%s): %s", e.name, e.op, e.domain, where, e.err)
 }
 
 // Unwrap fills the go 1.13 error interface for chaining
@@ -20,6 +47,91 @@ func (e Error) Unwrap() error {
 	return e.err
 }
 
+// Registrar reports the registrar that returned the error, it is nil for errors
+// decoded from JSON
+func (e Error) Registrar() Registrar {
+	return e.registrar
+}
+
+// RegistrarName reports the name of the registrar that returned the error
+func (e Error) RegistrarName() string {
+	return e.name
+}
+
+// Domain reports the domain name the operation was performed on
+func (e Error) Domain() string {
+	return e.domain
+}
+
+// Operation reports what was being done when the error occured
+func (e Error) Operation() Operation {
+	return e.op
+}
+
+// Position reports the position of the registrar in the chain the operation ran on,
+// starting at 1
+func (e Error) Position() int {
+	return e.position
+}
+
+// Attempt reports how many times the operation was tried for the domain at the registrar,
+// starting at 1
+func (e Error) Attempt() int {
+	return e.attempt
+}
+
+// Time reports when the error occured
+func (e Error) Time() time.Time {
+	return e.time
+}
+
+// jsonError is the serialized form of Error
+type jsonError struct {
+	Registrar string    `json:"registrar"`
+	Domain    string    `json:"domain,omitempty"`
+	Operation Operation `json:"operation,omitempty"`
+	Position  int       `json:"position,omitempty"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Time      time.Time `json:"time"`
+	Error     string    `json:"error"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (e Error) MarshalJSON() ([]byte, error) {
+	msg := ""
+	if e.err != nil {
+		msg = e.err.Error()
+	}
+	return json.Marshal(jsonError{
+		Registrar: e.name,
+		Domain:    e.domain,
+		Operation: e.op,
+		Position:  e.position,
+		Attempt:   e.attempt,
+		Time:      e.time,
+		Error:     msg,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The original error chain and registrar
+// can not be restored, the message of the error is kept.
+func (e *Error) UnmarshalJSON(b []byte) error {
+	var je jsonError
+	if err := json.Unmarshal(b, &je); err != nil {
+		return err
+	}
+	*e = Error{
+		name:     je.Registrar,
+		domain:   je.Domain,
+		op:       je.Operation,
+		position: je.Position,
+		attempt:  je.Attempt,
+		time:     je.Time,
+		err:      errors.New(je.Error),
+	}
+	return nil
+}
+
 // MultipleError holds a set of errors
 type MultipleError struct {
 	msg  string
@@ -40,6 +152,25 @@ func (me *MultipleError) Errors() []Error {
 	return me.errs
 }
 
+// ByDomain groups the internal errors by the domain name they occured for
+func (me *MultipleError) ByDomain() map[string][]Error {
+	return me.group(func(e Error) string { return e.domain })
+}
+
+// ByRegistrar groups the internal errors by the name of the registrar that returned them
+func (me *MultipleError) ByRegistrar() map[string][]Error {
+	return me.group(func(e Error) string { return e.name })
+}
+
+func (me *MultipleError) group(key func(Error) string) map[string][]Error {
+	g := make(map[string][]Error)
+	for _, e := range me.errs {
+		k := key(e)
+		g[k] = append(g[k], e)
+	}
+	return g
+}
+
 // Is implements the interface the errors package can use to
 // match the MultipleError to an error that should be tested.
 func (me *MultipleError) Is(target error) (matches bool) {
@@ -75,9 +206,52 @@ func (me *MultipleError) Len() int {
 	return len(me.errs)
 }
 
+// jsonMultipleError is the serialized form of MultipleError
+type jsonMultipleError struct {
+	Message string  `json:"message"`
+	Errors  []Error `json:"errors"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (me *MultipleError) MarshalJSON() ([]byte, error) {
+	errs := me.errs
+	if errs == nil {
+		errs = []Error{}
+	}
+	return json.Marshal(jsonMultipleError{me.msg, errs})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (me *MultipleError) UnmarshalJSON(b []byte) error {
+	var jme jsonMultipleError
+	if err := json.Unmarshal(b, &jme); err != nil {
+		return err
+	}
+	me.msg = jme.Message
+	me.errs = jme.Errors
+	return nil
+}
+
 // NewError returns an structured error
 func NewError(client Registrar, err error) Error {
-	return Error{client, err}
+	return Error{
+		registrar: client,
+		name:      RegistrarName(client),
+		time:      time.Now(),
+		err:       err,
+	}
+}
+
+// NewOperationError returns a structured error that records which operation failed
+// for which domain name. Position is the place of the registrar in the chain starting at 1,
+// 0 outside a chain. Attempt counts the tries of the operation, starting at 1.
+func NewOperationError(client Registrar, op Operation, domain string, position, attempt int, err error) Error {
+	e := NewError(client, err)
+	e.op = op
+	e.domain = domain
+	e.position = position
+	e.attempt = attempt
+	return e
 }
 
 // NewMultipleError returns a new instance of a multiple error
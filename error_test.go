@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
@@ -72,3 +73,68 @@ func TestMultipleError(t *testing.T) {
 		fmt.Println(err2)
 	})
 }
+
+func TestOperationError(t *testing.T) {
+	orig := errors.New(errorMessage)
+	err := NewOperationError(errorRegistrar{}, OperationRegister, "example.org", 2, 3, orig)
+
+	expect := "checker.errorRegistrar: register 'example.org' (registrar 2, attempt 3): " + errorMessage
+	if got := err.Error(); got != expect {
+		t.Logf("Expected '%s' but received '%s'", expect, got)
+		t.Fail()
+	}
+	if err.Domain() != "example.org" || err.Operation() != OperationRegister || err.Position() != 2 || err.Attempt() != 3 {
+		t.Logf("Unexpected error fields: %s, %s, %d, %d", err.Domain(), err.Operation(), err.Position(), err.Attempt())
+		t.Fail()
+	}
+	if err.Time().IsZero() {
+		t.Log("Expected the error to record when it occured")
+		t.Fail()
+	}
+	if !errors.Is(err, orig) {
+		t.Log("The error does not match the original error")
+		t.Fail()
+	}
+}
+
+func TestMultipleErrorGrouping(t *testing.T) {
+	err := NewMultipleError("grouping", 3)
+	err.Add(NewOperationError(errorRegistrar{}, OperationCheck, "a.org", 1, 1, errors.New("1")))
+	err.Add(NewOperationError(unavailableRegistrar{}, OperationCheck, "a.org", 2, 1, errors.New("2")))
+	err.Add(NewOperationError(errorRegistrar{}, OperationCheck, "b.org", 1, 1, errors.New("3")))
+
+	byDomain := err.ByDomain()
+	if len(byDomain["a.org"]) != 2 || len(byDomain["b.org"]) != 1 {
+		t.Logf("Unexpected grouping by domain: %v", byDomain)
+		t.Fail()
+	}
+	byRegistrar := err.ByRegistrar()
+	if len(byRegistrar["checker.errorRegistrar"]) != 2 || len(byRegistrar["checker.unavailableRegistrar"]) != 1 {
+		t.Logf("Unexpected grouping by registrar: %v", byRegistrar)
+		t.Fail()
+	}
+}
+
+func TestMultipleErrorJSON(t *testing.T) {
+	err := NewMultipleError("serialized", 1)
+	err.Add(NewOperationError(errorRegistrar{}, OperationCheck, "a.org", 1, 2, errors.New(errorMessage)))
+
+	b, jerr := json.Marshal(err)
+	if jerr != nil {
+		t.Fatal(jerr)
+	}
+
+	var decoded MultipleError
+	if jerr := json.Unmarshal(b, &decoded); jerr != nil {
+		t.Fatal(jerr)
+	}
+	if decoded.Error() != err.Error() {
+		t.Logf("Expected '%s' after decoding but received '%s'", err.Error(), decoded.Error())
+		t.Fail()
+	}
+	e := decoded.Errors()[0]
+	if e.RegistrarName() != "checker.errorRegistrar" || e.Domain() != "a.org" || e.Operation() != OperationCheck || e.Attempt() != 2 {
+		t.Logf("Decoded error lost its fields: %s", b)
+		t.Fail()
+	}
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// DNSConfig holds the settings for the DNS pre-filter
+type DNSConfig struct {
+	// Server is the DNS server to query as "host:port", when empty the system resolver is used
+	Server string
+	// Timeout limits the duration of a single lookup, defaults to 5 seconds
+	Timeout time.Duration
+}
+
+type dnsFilter struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+// Registered reports whether the domain is delegated to name servers. A delegated domain is
+// registered, a domain without delegation may still be registered (think of domains on hold)
+// so those are left to the registrars. Only NS records are looked up, the standard resolver
+// has no SOA lookup.
+func (d *dnsFilter) Registered(name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	// the trailing dot keeps the resolver from trying search domains
+	ns, err := d.resolver.LookupNS(ctx, strings.TrimSuffix(name, ".")+".")
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return len(ns) > 0, nil
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("NS lookup of '%s' failed: %w", name, err)
+}
+
+// NewDNSFilter returns a pre-filter that marks domains with an active delegation as registered
+func NewDNSFilter(cfg DNSConfig) (checker.PreFilter, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	r := net.DefaultResolver
+	if cfg.Server != "" {
+		if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+			return nil, fmt.Errorf("invalid DNS server '%s': %w", cfg.Server, err)
+		}
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, cfg.Server)
+			},
+		}
+	}
+	return &dnsFilter{resolver: r, timeout: cfg.Timeout}, nil
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dnsStandIn is a local DNS server answering NS queries for the delegations it knows. Other
+// names get NXDOMAIN, names in silent are never answered.
+type dnsStandIn struct {
+	conn        net.PacketConn
+	delegations map[string][]string
+	silent      map[string]bool
+}
+
+func newDNSStandIn(t *testing.T, delegations map[string][]string, silent ...string) *dnsStandIn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dnsStandIn{conn: conn, delegations: delegations, silent: make(map[string]bool)}
+	for _, n := range silent {
+		s.silent[n] = true
+	}
+	go s.serve()
+	return s
+}
+
+func (s *dnsStandIn) serve() {
+	b := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(b[:n]); resp != nil {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// answer builds the response to a query with a single question
+func (s *dnsStandIn) answer(q []byte) []byte {
+	if len(q) < 12 {
+		return nil
+	}
+	// read the question name
+	var labels []string
+	i := 12
+	for i < len(q) && q[i] != 0 {
+		l := int(q[i])
+		if i+1+l > len(q) {
+			return nil
+		}
+		labels = append(labels, string(q[i+1:i+1+l]))
+		i += 1 + l
+	}
+	end := i + 5 // terminating zero, type and class
+	if end > len(q) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	if s.silent[name] {
+		return nil
+	}
+
+	ns, ok := s.delegations[name]
+	resp := make([]byte, 12, 512)
+	copy(resp, q[:2])
+	flags := uint16(0x8180) | uint16(q[2]&0x01)<<8 // response, recursion desired and available
+	if !ok {
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(ns)))
+	resp = append(resp, q[12:end]...)
+	for _, n := range ns {
+		rdata := encodeDNSName(n)
+		rr := []byte{0xc0, 0x0c, 0, 2, 0, 1, 0, 0, 0x0e, 0x10, 0, 0}
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+		resp = append(append(resp, rr...), rdata...)
+	}
+	return resp
+}
+
+func encodeDNSName(n string) []byte {
+	var b []byte
+	for _, l := range strings.Split(strings.TrimSuffix(n, "."), ".") {
+		b = append(append(b, byte(len(l))), l...)
+	}
+	return append(b, 0)
+}
+
+func TestDNSFilter(t *testing.T) {
+	s := newDNSStandIn(t, map[string][]string{
+		"taken.test": {"ns1.example.net", "ns2.example.net"},
+	}, "silent.test")
+	defer s.conn.Close()
+
+	f, err := NewDNSFilter(DNSConfig{Server: s.conn.LocalAddr().String(), Timeout: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := f.Registered("taken.test"); err != nil || !r {
+		t.Errorf("Expected a delegated domain to be registered, got %t and '%v'", r, err)
+	}
+	if r, err := f.Registered("free.test"); err != nil || r {
+		t.Errorf("Expected an NXDOMAIN to be passed on to the registrars, got %t and '%v'", r, err)
+	}
+	if r, err := f.Registered("silent.test"); err == nil || r {
+		t.Errorf("Expected an error for an unanswered query, got %t and '%v'", r, err)
+	}
+}
+
+func TestDNSFilterInvalidServer(t *testing.T) {
+	if _, err := NewDNSFilter(DNSConfig{Server: "no-port"}); err == nil {
+		t.Error("Expected an error for a server without port")
+	}
+}
@@ -0,0 +1,482 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+const (
+	eppNamespace       = "urn:ietf:params:xml:ns:epp-1.0"
+	eppDomainNamespace = "urn:ietf:params:xml:ns:domain-1.0"
+	eppHostNamespace   = "urn:ietf:params:xml:ns:host-1.0"
+	// eppContactNamespace is announced at login, contacts are referenced when creating domains
+	eppContactNamespace = "urn:ietf:params:xml:ns:contact-1.0"
+	// eppMaxFrame limits the size of a single frame read from the server
+	eppMaxFrame = 1 << 20
+	// eppMaxPoll limits the amount of queued messages read in one go
+	eppMaxPoll = 25
+)
+
+// EPP result codes used here (RFC 5730 section 3)
+const (
+	eppCompleted             = 1000
+	eppPending               = 1001
+	eppNoMessages            = 1300
+	eppMessageQueued         = 1301
+	eppLoggedOut             = 1500
+	eppAuthorizationError    = 2201
+	eppObjectExists          = 2302
+	eppObjectDoesNotExist    = 2303
+	eppConnectionManagement  = 2500
+	eppAuthenticationClosing = 2501
+	eppSessionLimitClosing   = 2502
+)
+
+// EPPConfig holds the settings for a connection to an EPP registry
+type EPPConfig struct {
+	// Address of the EPP server as "host:port", the EPP port is 700
+	Address string
+	// ClientID and Password are the credentials issued by the registry
+	ClientID string
+	Password string
+	// TLS holds the client certificate most registries require, when nil the default
+	// configuration is used
+	TLS *tls.Config
+	// Timeout limits the duration of a single command, defaults to 30 seconds
+	Timeout time.Duration
+	// Period is the registration period in years, defaults to 1
+	Period int
+	// Registrant, Admin, Tech and Billing are the contact IDs used for new domains
+	Registrant string
+	Admin      string
+	Tech       string
+	Billing    string
+	// Nameservers are delegated to for new domains
+	Nameservers []string
+}
+
+// EPPError is a result code above 2000 returned by the registry
+type EPPError struct {
+	Code    int
+	Message string
+}
+
+func (e *EPPError) Error() string {
+	return fmt.Sprintf("EPP error %d: %s", e.Code, e.Message)
+}
+
+// eppResponse holds the parts of EPP responses (RFC 5730, RFC 5731) used here
+type eppResponse struct {
+	Greeting *struct {
+		ServerID string `xml:"svID"`
+	} `xml:"greeting"`
+	Results []struct {
+		Code    int    `xml:"code,attr"`
+		Message string `xml:"msg"`
+		Reason  string `xml:"extValue>reason"`
+	} `xml:"response>result"`
+	Queue *struct {
+		Count   int    `xml:"count,attr"`
+		ID      string `xml:"id,attr"`
+		Message string `xml:"msg"`
+	} `xml:"response>msgQ"`
+	Check []struct {
+		Name struct {
+			Avail string `xml:"avail,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"name"`
+		Reason string `xml:"reason"`
+	} `xml:"response>resData>chkData>cd"`
+	Info *struct {
+		Name     string `xml:"name"`
+		Statuses []struct {
+			S string `xml:"s,attr"`
+		} `xml:"status"`
+		ClientID string `xml:"clID"`
+		Updated  string `xml:"upDate"`
+		Expires  string `xml:"exDate"`
+	} `xml:"response>resData>infData"`
+	PendingAction *struct {
+		Name struct {
+			Result string `xml:"paResult,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"name"`
+	} `xml:"response>resData>panData"`
+}
+
+func (r *eppResponse) code() int {
+	if len(r.Results) == 0 {
+		return 0
+	}
+	return r.Results[0].Code
+}
+
+func (r *eppResponse) err() error {
+	if c := r.code(); c < 2000 {
+		return nil
+	}
+	msg := r.Results[0].Message
+	if r.Results[0].Reason != "" {
+		msg += " (" + r.Results[0].Reason + ")"
+	}
+	return &EPPError{Code: r.code(), Message: msg}
+}
+
+type epp struct {
+	cfg EPPConfig
+
+	lock sync.Mutex
+	conn net.Conn
+	trID uint64
+	// results holds the outcome of pending registrations reported through the message queue
+	results map[string]bool
+}
+
+// Name returns the name of this registrar
+func (e *epp) Name() string {
+	return "epp"
+}
+
+// CheckDomain checks the availability of the domain with a domain:check command. Domains that
+// are not available are looked up to see whether we sponsor them.
+func (e *epp) CheckDomain(name string) (checker.Status, error) {
+	r, err := e.command(fmt.Sprintf(`<check><domain:check xmlns:domain="%s"><domain:name>%s</domain:name></domain:check></check>`, eppDomainNamespace, eppEscape(name)))
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	for _, cd := range r.Check {
+		if !strings.EqualFold(strings.TrimSpace(cd.Name.Value), name) {
+			continue
+		}
+		if cd.Name.Avail == "1" || cd.Name.Avail == "true" {
+			return checker.Available, nil
+		}
+		if info, err := e.info(name); err == nil && info.Info != nil && info.Info.ClientID == e.cfg.ClientID {
+			return checker.Owned, nil
+		}
+		return checker.Unavailable, nil
+	}
+	return checker.Unavailable, fmt.Errorf("EPP check response holds no result for '%s'", name)
+}
+
+// RegisterDomain creates the domain with the configured period, contacts and name servers
+func (e *epp) RegisterDomain(name string) (checker.Status, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<create><domain:create xmlns:domain="%s"><domain:name>%s</domain:name><domain:period unit="y">%d</domain:period>`, eppDomainNamespace, eppEscape(name), e.cfg.Period)
+	if len(e.cfg.Nameservers) > 0 {
+		buf.WriteString("<domain:ns>")
+		for _, ns := range e.cfg.Nameservers {
+			fmt.Fprintf(&buf, "<domain:hostObj>%s</domain:hostObj>", eppEscape(ns))
+		}
+		buf.WriteString("</domain:ns>")
+	}
+	if e.cfg.Registrant != "" {
+		fmt.Fprintf(&buf, "<domain:registrant>%s</domain:registrant>", eppEscape(e.cfg.Registrant))
+	}
+	for _, c := range [][2]string{{"admin", e.cfg.Admin}, {"tech", e.cfg.Tech}, {"billing", e.cfg.Billing}} {
+		if c[1] != "" {
+			fmt.Fprintf(&buf, `<domain:contact type="%s">%s</domain:contact>`, c[0], eppEscape(c[1]))
+		}
+	}
+	pw, err := eppAuthInfo()
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	fmt.Fprintf(&buf, "<domain:authInfo><domain:pw>%s</domain:pw></domain:authInfo></domain:create></create>", pw)
+
+	r, err := e.command(buf.String())
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	if r.code() == eppPending {
+		return checker.Processing, nil
+	}
+	return checker.Owned, nil
+}
+
+// RegistrationStatus reads the message queue for the outcome of a pending create, when the
+// queue holds nothing about the domain it is looked up.
+func (e *epp) RegistrationStatus(name string) (checker.Status, error) {
+	if err := e.poll(); err != nil {
+		return checker.Unavailable, err
+	}
+	e.lock.Lock()
+	ok, found := e.results[name]
+	delete(e.results, name)
+	e.lock.Unlock()
+	if found {
+		if ok {
+			return checker.Owned, nil
+		}
+		return checker.Unavailable, fmt.Errorf("%w: registry rejected the pending create", checker.ErrRegistrationFailed)
+	}
+
+	r, err := e.info(name)
+	var eppErr *EPPError
+	if errors.As(err, &eppErr) && eppErr.Code == eppObjectDoesNotExist {
+		return checker.Unavailable, fmt.Errorf("%w: domain does not exist at the registry", checker.ErrRegistrationFailed)
+	}
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	for _, s := range r.Info.Statuses {
+		if s.S == "pendingCreate" {
+			return checker.Processing, nil
+		}
+	}
+	if r.Info.ClientID != e.cfg.ClientID {
+		return checker.Unavailable, fmt.Errorf("%w: domain is sponsored by '%s'", checker.ErrRegistrationFailed, r.Info.ClientID)
+	}
+	return checker.Owned, nil
+}
+
+// Lifecycle returns the registry status codes and dates from a domain:info command
+func (e *epp) Lifecycle(name string) (checker.Lifecycle, error) {
+	r, err := e.info(name)
+	var eppErr *EPPError
+	if errors.As(err, &eppErr) && eppErr.Code == eppObjectDoesNotExist {
+		return checker.Lifecycle{}, nil
+	}
+	if err != nil {
+		return checker.Lifecycle{}, err
+	}
+
+	var l checker.Lifecycle
+	for _, s := range r.Info.Statuses {
+		l.Statuses = append(l.Statuses, s.S)
+	}
+	l.Expires, _ = time.Parse(time.RFC3339, r.Info.Expires)
+	l.Changed, _ = time.Parse(time.RFC3339, r.Info.Updated)
+	return l, nil
+}
+
+// Close ends the session with the registry
+func (e *epp) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	_, err := e.exchange(`<command><logout/><clTRID>` + e.nextTRID() + `</clTRID></command>`)
+	e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+func (e *epp) info(name string) (*eppResponse, error) {
+	r, err := e.command(fmt.Sprintf(`<info><domain:info xmlns:domain="%s"><domain:name hosts="none">%s</domain:name></domain:info></info>`, eppDomainNamespace, eppEscape(name)))
+	if err != nil {
+		return nil, err
+	}
+	if r.Info == nil {
+		return nil, fmt.Errorf("EPP info response holds no data for '%s'", name)
+	}
+	return r, nil
+}
+
+// poll reads and acknowledges the queued messages, the outcomes of pending actions are kept
+func (e *epp) poll() error {
+	for i := 0; i < eppMaxPoll; i++ {
+		r, err := e.command(`<poll op="req"/>`)
+		if err != nil {
+			return err
+		}
+		if r.code() == eppNoMessages || r.Queue == nil {
+			return nil
+		}
+		log.Printf("EPP message %s: %s", r.Queue.ID, r.Queue.Message)
+		if pa := r.PendingAction; pa != nil {
+			e.lock.Lock()
+			e.results[strings.TrimSpace(pa.Name.Value)] = pa.Name.Result == "1" || pa.Name.Result == "true"
+			e.lock.Unlock()
+		}
+		if _, err := e.command(fmt.Sprintf(`<poll op="ack" msgID="%s"/>`, eppEscape(r.Queue.ID))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command wraps the command in an EPP frame, sends it and returns the response. The session
+// is set up when there is none. Registries drop idle sessions, so a command failing on the
+// session is sent once more on a new one. A create that may have reached the registry is not
+// sent again, the session is probed with a hello before sending it instead.
+func (e *epp) command(cmd string) (*eppResponse, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	create := strings.HasPrefix(cmd, "<create>")
+	if create && e.conn != nil {
+		if _, err := e.exchange(`<hello/>`); err != nil {
+			log.Printf("EPP session at %s was dropped: %v", e.cfg.Address, err)
+			e.drop()
+		}
+	}
+	if e.conn == nil {
+		if err := e.login(); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := e.send(cmd)
+	if err != nil && (!create || eppClosesSession(err)) {
+		log.Printf("EPP session at %s was dropped, sending the command again: %v", e.cfg.Address, err)
+		if err := e.login(); err != nil {
+			return nil, err
+		}
+		r, err = e.send(cmd)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, r.err()
+}
+
+// send sends the command on the session. When the exchange fails or the server closes the
+// session it is dropped, the caller holds the lock.
+func (e *epp) send(cmd string) (*eppResponse, error) {
+	r, err := e.exchange(`<command>` + cmd + `<clTRID>` + e.nextTRID() + `</clTRID></command>`)
+	if err == nil && eppClosesSession(r.err()) {
+		err = r.err()
+	}
+	if err != nil {
+		e.drop()
+		return nil, err
+	}
+	return r, nil
+}
+
+// drop closes the session, a new one is set up for the next command
+func (e *epp) drop() {
+	e.conn.Close()
+	e.conn = nil
+}
+
+// eppClosesSession reports whether the error is a result code after which the server closes
+// the session, the command was not executed
+func eppClosesSession(err error) bool {
+	var eppErr *EPPError
+	if !errors.As(err, &eppErr) {
+		return false
+	}
+	switch eppErr.Code {
+	case eppConnectionManagement, eppAuthenticationClosing, eppSessionLimitClosing:
+		return true
+	}
+	return false
+}
+
+// login connects to the server, reads the greeting and logs in, the caller holds the lock
+func (e *epp) login() error {
+	d := &net.Dialer{Timeout: e.cfg.Timeout}
+	conn, err := tls.DialWithDialer(d, "tcp", e.cfg.Address, e.cfg.TLS)
+	if err != nil {
+		return fmt.Errorf("could not connect to EPP server %s: %w", e.cfg.Address, err)
+	}
+	e.conn = conn
+
+	greeting, err := e.read()
+	if err == nil && greeting.Greeting == nil {
+		err = errors.New("EPP server did not send a greeting")
+	}
+	var r *eppResponse
+	if err == nil {
+		r, err = e.exchange(fmt.Sprintf(`<command><login><clID>%s</clID><pw>%s</pw><options><version>1.0</version><lang>en</lang></options><svcs><objURI>%s</objURI><objURI>%s</objURI><objURI>%s</objURI></svcs></login><clTRID>%s</clTRID></command>`,
+			eppEscape(e.cfg.ClientID), eppEscape(e.cfg.Password), eppDomainNamespace, eppContactNamespace, eppHostNamespace, e.nextTRID()))
+	}
+	if err == nil {
+		err = r.err()
+	}
+	if err != nil {
+		conn.Close()
+		e.conn = nil
+		return fmt.Errorf("could not log in at EPP server %s: %w", e.cfg.Address, err)
+	}
+	return nil
+}
+
+// exchange sends a single frame and reads the response, the caller holds the lock
+func (e *epp) exchange(body string) (*eppResponse, error) {
+	e.conn.SetDeadline(time.Now().Add(e.cfg.Timeout))
+	msg := `<?xml version="1.0" encoding="UTF-8" standalone="no"?><epp xmlns="` + eppNamespace + `">` + body + `</epp>`
+	frame := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(4+len(msg)))
+	if _, err := e.conn.Write(append(frame, msg...)); err != nil {
+		return nil, err
+	}
+	return e.read()
+}
+
+// read reads a single frame (RFC 5734 section 4), the caller holds the lock
+func (e *epp) read() (*eppResponse, error) {
+	e.conn.SetDeadline(time.Now().Add(e.cfg.Timeout))
+	var size uint32
+	if err := binary.Read(e.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > eppMaxFrame {
+		return nil, fmt.Errorf("invalid EPP frame size %d", size)
+	}
+	b := make([]byte, size-4)
+	if _, err := io.ReadFull(e.conn, b); err != nil {
+		return nil, err
+	}
+	var r eppResponse
+	if err := xml.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("invalid EPP response: %w", err)
+	}
+	return &r, nil
+}
+
+func (e *epp) nextTRID() string {
+	e.trID++
+	return fmt.Sprintf("%s-%d-%d", e.cfg.ClientID, time.Now().Unix(), e.trID)
+}
+
+func eppEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// eppAuthInfo generates the transfer password set on new domains
+func eppAuthInfo() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x-A1", b), nil
+}
+
+// NewEPP returns a registrar talking EPP to a registry. The session is set up at the first
+// command and kept open until Close is called.
+func NewEPP(cfg EPPConfig) (checker.Registrar, error) {
+	if cfg.Address == "" || cfg.ClientID == "" {
+		return nil, errors.New("EPP address and client ID are required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		cfg.Address = net.JoinHostPort(cfg.Address, "700")
+	}
+	if cfg.TLS == nil {
+		cfg.TLS = &tls.Config{}
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Period == 0 {
+		cfg.Period = 1
+	}
+	return &epp{cfg: cfg, results: make(map[string]bool)}, nil
+}
@@ -0,0 +1,504 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+const (
+	eppStandInClient   = "standin"
+	eppStandInPassword = "secret"
+)
+
+type eppStandInDomain struct {
+	sponsor  string
+	statuses []string
+	updated  string
+	expires  string
+}
+
+// eppStandInMessage is a queued pending action notification
+type eppStandInMessage struct {
+	id     int
+	domain string
+	ok     bool
+}
+
+// eppStandIn is a local EPP registry served over TLS with a certificate generated for the test
+type eppStandIn struct {
+	l   net.Listener
+	cas *x509.CertPool
+
+	mu      sync.Mutex
+	domains map[string]*eppStandInDomain
+	queue   []eppStandInMessage
+	pending bool
+	logins  int
+	msgID   int
+	creates int
+	// sessions are the open connections, closing answers the next command with 2500 and
+	// hangup names a domain after whose command the session is closed without an answer
+	sessions map[net.Conn]bool
+	closing  bool
+	hangup   string
+}
+
+func newEPPStandIn(t *testing.T) *eppStandIn {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "epp.standin"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &eppStandIn{l: l, cas: x509.NewCertPool(), domains: make(map[string]*eppStandInDomain), sessions: make(map[net.Conn]bool)}
+	s.cas.AddCert(cert)
+	go s.serve()
+	return s
+}
+
+func (s *eppStandIn) config() EPPConfig {
+	return EPPConfig{
+		Address:    s.l.Addr().String(),
+		ClientID:   eppStandInClient,
+		Password:   eppStandInPassword,
+		TLS:        &tls.Config{RootCAs: s.cas},
+		Timeout:    time.Second,
+		Registrant: "REG-1",
+		Admin:      "ADM-1",
+	}
+}
+
+func (s *eppStandIn) set(name, sponsor string, statuses ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domains[name] = &eppStandInDomain{
+		sponsor:  sponsor,
+		statuses: statuses,
+		updated:  "2019-03-01T10:00:00.0Z",
+		expires:  "2020-03-01T10:00:00.0Z",
+	}
+}
+
+// complete finishes a pending create and queues the notification
+func (s *eppStandIn) complete(name string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		s.domains[name].statuses = []string{"ok"}
+	} else {
+		delete(s.domains, name)
+	}
+	s.msgID++
+	s.queue = append(s.queue, eppStandInMessage{s.msgID, name, ok})
+}
+
+// drop closes every session like a registry dropping idle sessions
+func (s *eppStandIn) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.sessions {
+		conn.Close()
+	}
+}
+
+func (s *eppStandIn) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+func (s *eppStandIn) session(conn net.Conn) {
+	s.mu.Lock()
+	s.sessions[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	greeting := `<greeting><svID>EPP stand-in</svID><svDate>2019-04-01T10:00:00.0Z</svDate></greeting>`
+	s.write(conn, greeting)
+
+	loggedIn := false
+	for {
+		var size uint32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		b := make([]byte, size-4)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		var msg standInNode
+		if err := xml.Unmarshal(b, &msg); err != nil {
+			s.result(conn, 2001, "Command syntax error", "")
+			return
+		}
+		if _, ok := msg.child("hello"); ok {
+			s.write(conn, greeting)
+			continue
+		}
+		cmd, _ := msg.child("command")
+		if len(cmd.Nodes) == 0 {
+			s.result(conn, 2001, "Command syntax error", "")
+			return
+		}
+		op := cmd.Nodes[0]
+
+		switch {
+		case op.XMLName.Local == "login":
+			id, _ := op.child("clID")
+			pw, _ := op.child("pw")
+			if id.Content != eppStandInClient || pw.Content != eppStandInPassword {
+				s.result(conn, 2200, "Authentication error", "")
+				return
+			}
+			s.mu.Lock()
+			s.logins++
+			s.mu.Unlock()
+			loggedIn = true
+			s.result(conn, 1000, "Command completed successfully", "")
+		case !loggedIn:
+			s.result(conn, 2002, "Command use error", "")
+		case s.takeClosing():
+			s.result(conn, 2500, "Command failed; server closing connection", "")
+			return
+		case op.XMLName.Local == "logout":
+			s.result(conn, 1500, "Command completed successfully; ending session", "")
+			return
+		case op.XMLName.Local == "poll":
+			s.poll(conn, b)
+		default:
+			if s.domain(conn, op) {
+				return
+			}
+		}
+	}
+}
+
+// takeClosing reports whether the command is to be answered with 2500, once
+func (s *eppStandIn) takeClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	closing := s.closing
+	s.closing = false
+	return closing
+}
+
+// domain executes a domain command, it reports whether the session is to be hung up without
+// answering
+func (s *eppStandIn) domain(conn net.Conn, op standInNode) (hangup bool) {
+	if len(op.Nodes) == 0 {
+		s.result(conn, 2001, "Command syntax error", "")
+		return
+	}
+	name, _ := op.Nodes[0].child("name")
+	if name.Content == "fault.test" {
+		s.result(conn, 2400, "Command failed", "")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, exists := s.domains[name.Content]
+	var w io.Writer = conn
+	if name.Content == s.hangup {
+		s.hangup, hangup, w = "", true, ioutil.Discard
+	}
+
+	switch op.XMLName.Local {
+	case "check":
+		avail := "1"
+		if exists {
+			avail = "0"
+		}
+		s.result(w, 1000, "Command completed successfully", fmt.Sprintf(
+			`<domain:chkData xmlns:domain="%s"><domain:cd><domain:name avail="%s">%s</domain:name></domain:cd></domain:chkData>`,
+			eppDomainNamespace, avail, name.Content))
+	case "info":
+		if !exists {
+			s.result(w, 2303, "Object does not exist", "")
+			return
+		}
+		if d.sponsor != eppStandInClient {
+			s.result(w, 2201, "Authorization error", "")
+			return
+		}
+		var statuses string
+		for _, st := range d.statuses {
+			statuses += fmt.Sprintf(`<domain:status s="%s"/>`, st)
+		}
+		s.result(w, 1000, "Command completed successfully", fmt.Sprintf(
+			`<domain:infData xmlns:domain="%s"><domain:name>%s</domain:name>%s<domain:clID>%s</domain:clID><domain:upDate>%s</domain:upDate><domain:exDate>%s</domain:exDate></domain:infData>`,
+			eppDomainNamespace, name.Content, statuses, d.sponsor, d.updated, d.expires))
+	case "create":
+		if exists {
+			s.result(w, 2302, "Object exists", "")
+			return
+		}
+		if reg, _ := op.Nodes[0].child("registrant"); reg.Content == "" {
+			s.result(w, 2003, "Required parameter missing", "")
+			return
+		}
+		code, status := 1000, "ok"
+		if s.pending {
+			code, status = 1001, "pendingCreate"
+		}
+		s.creates++
+		s.domains[name.Content] = &eppStandInDomain{sponsor: eppStandInClient, statuses: []string{status}}
+		s.result(w, code, "Command completed successfully", fmt.Sprintf(
+			`<domain:creData xmlns:domain="%s"><domain:name>%s</domain:name></domain:creData>`, eppDomainNamespace, name.Content))
+	default:
+		s.result(w, 2000, "Unknown command", "")
+	}
+	return
+}
+
+func (s *eppStandIn) poll(conn net.Conn, raw []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.Contains(string(raw), `op="ack"`) {
+		if len(s.queue) > 0 {
+			s.queue = s.queue[1:]
+		}
+		s.result(conn, 1000, "Command completed successfully", "")
+		return
+	}
+	if len(s.queue) == 0 {
+		s.result(conn, 1300, "Command completed successfully; no messages", "")
+		return
+	}
+	m := s.queue[0]
+	result := "0"
+	if m.ok {
+		result = "1"
+	}
+	s.write(conn, fmt.Sprintf(`<response><result code="1301"><msg>Command completed successfully; ack to dequeue</msg></result>`+
+		`<msgQ count="%d" id="%d"><qDate>2019-04-01T10:00:00.0Z</qDate><msg>Pending action completed.</msg></msgQ>`+
+		`<resData><domain:panData xmlns:domain="%s"><domain:name paResult="%s">%s</domain:name></domain:panData></resData></response>`,
+		len(s.queue), m.id, eppDomainNamespace, result, m.domain))
+}
+
+func (s *eppStandIn) result(w io.Writer, code int, msg, resData string) {
+	if resData != "" {
+		resData = "<resData>" + resData + "</resData>"
+	}
+	s.write(w, fmt.Sprintf(`<response><result code="%d"><msg>%s</msg></result>%s<trID><svTRID>standin</svTRID></trID></response>`, code, msg, resData))
+}
+
+func (s *eppStandIn) write(w io.Writer, body string) {
+	msg := `<?xml version="1.0" encoding="UTF-8"?><epp xmlns="` + eppNamespace + `">` + body + `</epp>`
+	frame := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(4+len(msg)))
+	w.Write(append(frame, msg...))
+}
+
+func newStandInEPP(t *testing.T, s *eppStandIn) checker.Registrar {
+	t.Helper()
+	e, err := NewEPP(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEPPConformance(t *testing.T) {
+	s := newEPPStandIn(t)
+	defer s.l.Close()
+
+	var registrars []checker.Registrar
+	defer func() {
+		for _, e := range registrars {
+			e.(io.Closer).Close()
+		}
+	}()
+	checkertest.RunConformance(t, func() checker.Registrar {
+		s.mu.Lock()
+		s.domains = make(map[string]*eppStandInDomain)
+		s.mu.Unlock()
+		s.set("taken.test", "other")
+		s.set("owned.test", eppStandInClient, "ok")
+		e := newStandInEPP(t, s)
+		registrars = append(registrars, e)
+		return e
+	},
+		checkertest.Fixture{Domain: "free.test", Status: checker.Available, Register: checker.Owned},
+		checkertest.Fixture{Domain: "taken.test", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "owned.test", Status: checker.Owned},
+		checkertest.Fixture{Domain: "fault.test", Err: true},
+	)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logins != len(registrars) {
+		t.Errorf("Expected a single session per registrar, got %d logins for %d registrars", s.logins, len(registrars))
+	}
+}
+
+func TestEPPRegisterDomain(t *testing.T) {
+	s := newEPPStandIn(t)
+	defer s.l.Close()
+	s.set("taken.test", "other")
+	e := newStandInEPP(t, s)
+	defer e.(io.Closer).Close()
+
+	if status, err := e.RegisterDomain("direct.test"); err != nil || status != checker.Owned {
+		t.Errorf("Expected a completed create to be owned, got %d and '%v'", status, err)
+	}
+	var eppErr *EPPError
+	if _, err := e.RegisterDomain("taken.test"); !errors.As(err, &eppErr) || eppErr.Code != eppObjectExists {
+		t.Errorf("Expected an object exists error, got '%v'", err)
+	}
+
+	s.pending = true
+	if status, err := e.RegisterDomain("pending.test"); err != nil || status != checker.Processing {
+		t.Errorf("Expected a pending create to be processing, got %d and '%v'", status, err)
+	}
+	if status, err := e.RegisterDomain("rejected.test"); err != nil || status != checker.Processing {
+		t.Errorf("Expected a pending create to be processing, got %d and '%v'", status, err)
+	}
+
+	sr := e.(checker.RegistrationStatusReader)
+	if status, err := sr.RegistrationStatus("pending.test"); err != nil || status != checker.Processing {
+		t.Errorf("Expected the create to be processing, got %d and '%v'", status, err)
+	}
+	s.complete("pending.test", true)
+	s.complete("rejected.test", false)
+	if status, err := sr.RegistrationStatus("pending.test"); err != nil || status != checker.Owned {
+		t.Errorf("Expected the completed create to be owned, got %d and '%v'", status, err)
+	}
+	if _, err := sr.RegistrationStatus("rejected.test"); !errors.Is(err, checker.ErrRegistrationFailed) {
+		t.Errorf("Expected the rejected create to have failed, got '%v'", err)
+	}
+	if len(s.queue) != 0 {
+		t.Errorf("Expected all messages to be acknowledged, %d left", len(s.queue))
+	}
+}
+
+func TestEPPSessionDropped(t *testing.T) {
+	s := newEPPStandIn(t)
+	defer s.l.Close()
+	e := newStandInEPP(t, s)
+	defer e.(io.Closer).Close()
+	logins := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.logins
+	}
+
+	if _, err := e.CheckDomain("free.test"); err != nil {
+		t.Fatal(err)
+	}
+	s.drop()
+	if status, err := e.CheckDomain("free.test"); err != nil || status != checker.Available {
+		t.Errorf("Expected the check to be sent again on a new session, got %d and '%v'", status, err)
+	}
+	s.closing = true
+	if status, err := e.CheckDomain("free.test"); err != nil || status != checker.Available {
+		t.Errorf("Expected the check to be sent again after the server closed the session, got %d and '%v'", status, err)
+	}
+	if n := logins(); n != 3 {
+		t.Errorf("Expected 3 logins, got %d", n)
+	}
+
+	// the hello finds the dropped session before the create is sent
+	s.drop()
+	if status, err := e.RegisterDomain("first.test"); err != nil || status != checker.Owned {
+		t.Errorf("Expected the create to be sent on a new session, got %d and '%v'", status, err)
+	}
+	s.closing = true
+	if status, err := e.RegisterDomain("second.test"); err != nil || status != checker.Owned {
+		t.Errorf("Expected the create refused by a closing server to be sent again, got %d and '%v'", status, err)
+	}
+
+	// a create that may have reached the registry is not sent again
+	s.hangup = "third.test"
+	if _, err := e.RegisterDomain("third.test"); err == nil {
+		t.Error("Expected the unanswered create to fail")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creates != 3 {
+		t.Errorf("Expected every create to reach the registry once, got %d creates", s.creates)
+	}
+}
+
+func TestEPPLifecycle(t *testing.T) {
+	s := newEPPStandIn(t)
+	defer s.l.Close()
+	s.set("expiring.test", eppStandInClient, checker.StatusCodeAutoRenewPeriod)
+	e := newStandInEPP(t, s)
+	defer e.(io.Closer).Close()
+
+	l, err := e.(checker.LifecycleReader).Lifecycle("expiring.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.HasStatus(checker.StatusCodeAutoRenewPeriod) {
+		t.Errorf("Expected the auto renew status, got %v", l.Statuses)
+	}
+	if !l.Expires.Equal(time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)) || !l.Changed.Equal(time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected dates %s and %s", l.Expires, l.Changed)
+	}
+}
+
+func TestEPPInvalidLogin(t *testing.T) {
+	s := newEPPStandIn(t)
+	defer s.l.Close()
+	cfg := s.config()
+	cfg.Password = "wrong"
+	e, err := NewEPP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eppErr *EPPError
+	if _, err := e.CheckDomain("free.test"); !errors.As(err, &eppErr) || eppErr.Code != 2200 {
+		t.Errorf("Expected an authentication error, got '%v'", err)
+	}
+}
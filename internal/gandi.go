@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+const (
+	gandiEndpoint = "https://api.gandi.net/v5/"
+	// gandiPageSize is the amount of domains requested per page when listing
+	gandiPageSize = 100
+)
+
+// GandiContact is the owner contact for new domains
+type GandiContact struct {
+	// Type is one of "individual", "company", "association" or "publicbody"
+	Type       string `json:"type"`
+	Given      string `json:"given"`
+	Family     string `json:"family"`
+	OrgName    string `json:"orgname,omitempty"`
+	StreetAddr string `json:"streetaddr"`
+	City       string `json:"city"`
+	Zip        string `json:"zip"`
+	// Country is the two letter country code
+	Country string `json:"country"`
+	// Phone is formatted like +31.201234567
+	Phone string `json:"phone"`
+	Email string `json:"email"`
+}
+
+// GandiConfig holds the settings for a Gandi registrar
+type GandiConfig struct {
+	// APIKey is the key or personal access token from the Gandi account settings
+	APIKey string
+	// SharingID selects the organization acted upon, when empty the personal account is used
+	SharingID string
+	// Endpoint overrides the production API
+	Endpoint string
+	// Currency is the currency prices are requested in, defaults to "EUR"
+	Currency string
+	// Duration is the registration period in years, defaults to 1
+	Duration int
+	// Owner is the owner contact of new domains
+	Owner GandiContact
+}
+
+// gandiError is the error body returned by the API
+type gandiError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *gandiError) Error() string {
+	return fmt.Sprintf("Gandi API returned HTTP status %d: %s", e.StatusCode, e.Message)
+}
+
+type gandi struct {
+	cfg      GandiConfig
+	endpoint *url.URL
+	http     *http.Client
+}
+
+// Name returns the name of this registrar
+func (g *gandi) Name() string {
+	return "gandi"
+}
+
+// CheckDomain checks whether the domain can be created at Gandi
+func (g *gandi) CheckDomain(name string) (checker.Status, error) {
+	d, err := g.CheckDomainDetail(name)
+	return d.Status, err
+}
+
+// CheckDomainDetail checks whether the domain can be created, available domains come with the
+// price of the shortest registration period
+func (g *gandi) CheckDomainDetail(name string) (checker.Detail, error) {
+	var resp struct {
+		Currency string `json:"currency"`
+		Products []struct {
+			Name    string `json:"name"`
+			Status  string `json:"status"`
+			Process string `json:"process"`
+			Prices  []struct {
+				MinDuration      int     `json:"min_duration"`
+				PriceAfterTaxes  float64 `json:"price_after_taxes"`
+				PriceBeforeTaxes float64 `json:"price_before_taxes"`
+			} `json:"prices"`
+		} `json:"products"`
+	}
+	q := url.Values{"name": {name}, "processes": {"create"}, "currency": {g.cfg.Currency}}
+	if err := g.do(http.MethodGet, "domain/check?"+q.Encode(), nil, &resp); err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+
+	for _, p := range resp.Products {
+		if p.Name != name || p.Process != "create" {
+			continue
+		}
+		d := checker.Detail{Status: checker.Unavailable, Raw: p.Status}
+		switch p.Status {
+		case "available":
+			d.Status = checker.Available
+		case "pending", "error":
+			return checker.Detail{Status: checker.Unavailable}, fmt.Errorf("Gandi could not check '%s', status is %s", name, p.Status)
+		}
+		for _, price := range p.Prices {
+			if price.MinDuration <= 1 {
+				d.Price = &checker.Price{Amount: int64(math.Round(price.PriceAfterTaxes * 100)), Currency: resp.Currency}
+				break
+			}
+		}
+		return d, nil
+	}
+	return checker.Detail{Status: checker.Unavailable}, fmt.Errorf("Gandi check response holds no result for '%s'", name)
+}
+
+// RegisterDomain orders the domain with the configured owner, Gandi creates it in the background
+func (g *gandi) RegisterDomain(name string) (checker.Status, error) {
+	req := map[string]interface{}{
+		"fqdn":     name,
+		"duration": g.cfg.Duration,
+		"owner":    g.cfg.Owner,
+	}
+	if err := g.do(http.MethodPost, "domain/domains", req, nil); err != nil {
+		return checker.Unavailable, err
+	}
+	return checker.Processing, nil
+}
+
+// RegistrationStatus reports Owned once the domain shows up in the account
+func (g *gandi) RegistrationStatus(name string) (checker.Status, error) {
+	_, err := g.domain(name)
+	var gErr *gandiError
+	if errors.As(err, &gErr) && gErr.StatusCode == http.StatusNotFound {
+		return checker.Processing, nil
+	}
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	return checker.Owned, nil
+}
+
+// ListDomains returns the names of all domains in the account
+func (g *gandi) ListDomains() ([]string, error) {
+	var names []string
+	for page := 1; ; page++ {
+		var domains []struct {
+			FQDN string `json:"fqdn"`
+		}
+		q := url.Values{"page": {strconv.Itoa(page)}, "per_page": {strconv.Itoa(gandiPageSize)}}
+		if err := g.do(http.MethodGet, "domain/domains?"+q.Encode(), nil, &domains); err != nil {
+			return nil, fmt.Errorf("list domains returned an error: %w", err)
+		}
+		for _, d := range domains {
+			names = append(names, d.FQDN)
+		}
+		if len(domains) < gandiPageSize {
+			return names, nil
+		}
+	}
+}
+
+// Lifecycle returns the status codes and dates of a domain in the account, for other domains
+// Gandi knows nothing and an empty lifecycle is returned
+func (g *gandi) Lifecycle(name string) (checker.Lifecycle, error) {
+	d, err := g.domain(name)
+	var gErr *gandiError
+	if errors.As(err, &gErr) && gErr.StatusCode == http.StatusNotFound {
+		return checker.Lifecycle{}, nil
+	}
+	if err != nil {
+		return checker.Lifecycle{}, err
+	}
+	return checker.Lifecycle{
+		Statuses: d.Status,
+		Expires:  d.Dates.RegistryEndsAt,
+		Changed:  d.Dates.UpdatedAt,
+	}, nil
+}
+
+type gandiDomain struct {
+	Status []string `json:"status"`
+	Dates  struct {
+		RegistryEndsAt time.Time `json:"registry_ends_at"`
+		UpdatedAt      time.Time `json:"updated_at"`
+	} `json:"dates"`
+}
+
+func (g *gandi) domain(name string) (*gandiDomain, error) {
+	var d gandiDomain
+	if err := g.do(http.MethodGet, "domain/domains/"+url.PathEscape(name), nil, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// do performs the request and decodes the response into result, which may be nil when no
+// response body is expected
+func (g *gandi) do(method, path string, body, result interface{}) error {
+	u, err := g.endpoint.Parse(path)
+	if err != nil {
+		return err
+	}
+	if g.cfg.SharingID != "" {
+		q := u.Query()
+		q.Set("sharing_id", g.cfg.SharingID)
+		u.RawQuery = q.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Apikey "+g.cfg.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to '%s' failed: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		e := &gandiError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(b, e); err != nil || e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	if result == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, result)
+}
+
+// NewGandi returns a registrar using the Gandi v5 REST API
+func NewGandi(cfg GandiConfig) (checker.Registrar, error) {
+	if cfg.APIKey == "" {
+		return nil, errors.New("Gandi API key is required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = gandiEndpoint
+	}
+	if cfg.Currency == "" {
+		cfg.Currency = "EUR"
+	}
+	if cfg.Duration == 0 {
+		cfg.Duration = 1
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint '%s': %w", cfg.Endpoint, err)
+	}
+	if u.Path == "" || u.Path[len(u.Path)-1] != '/' {
+		u.Path += "/"
+	}
+	return &gandi{cfg: cfg, endpoint: u, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
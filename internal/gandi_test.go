@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+// gandiStandIn is a local Gandi API. Domains are available unless they are in the account or
+// marked taken, registrations land in the account once they are completed.
+type gandiStandIn struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	taken    map[string]bool
+	account  map[string]bool
+	pending  map[string]bool
+	statuses map[string][]string
+	owners   []GandiContact
+}
+
+func newGandiStandIn() *gandiStandIn {
+	s := &gandiStandIn{
+		taken:    make(map[string]bool),
+		account:  make(map[string]bool),
+		pending:  make(map[string]bool),
+		statuses: make(map[string][]string),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *gandiStandIn) config() GandiConfig {
+	return GandiConfig{
+		APIKey:    "secret",
+		SharingID: "org-1",
+		Endpoint:  s.server.URL + "/v5",
+		Owner: GandiContact{
+			Type: "individual", Given: "Jan", Family: "Jansen", StreetAddr: "Dorpsstraat 1",
+			City: "Amsterdam", Zip: "1000AA", Country: "NL", Phone: "+31.201234567", Email: "jan@example.org",
+		},
+	}
+}
+
+// complete moves a pending registration into the account
+func (s *gandiStandIn) complete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, name)
+	s.account[name] = true
+}
+
+func (s *gandiStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Apikey secret" {
+		writeStandInJSON(w, http.StatusUnauthorized, map[string]string{"message": "The server could not verify that you authorized to access the document you requested."})
+		return
+	}
+	if r.URL.Query().Get("sharing_id") != "org-1" {
+		writeStandInJSON(w, http.StatusForbidden, map[string]string{"message": "Access was denied to this resource."})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v5/")
+	switch {
+	case r.Method == http.MethodGet && path == "domain/check":
+		s.check(w, r.URL.Query().Get("name"), r.URL.Query().Get("currency"))
+	case r.Method == http.MethodPost && path == "domain/domains":
+		var req struct {
+			FQDN     string       `json:"fqdn"`
+			Duration int          `json:"duration"`
+			Owner    GandiContact `json:"owner"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Owner.Email == "" || req.Duration < 1 {
+			writeStandInJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+			return
+		}
+		if s.taken[req.FQDN] || s.account[req.FQDN] || s.pending[req.FQDN] {
+			writeStandInJSON(w, http.StatusConflict, map[string]string{"message": "Domain " + req.FQDN + " is not available"})
+			return
+		}
+		if strings.HasSuffix(req.FQDN, ".invalid") {
+			writeStandInJSON(w, http.StatusBadRequest, map[string]string{"message": "Domain extension is not supported"})
+			return
+		}
+		s.owners = append(s.owners, req.Owner)
+		s.pending[req.FQDN] = true
+		writeStandInJSON(w, http.StatusAccepted, map[string]string{"message": "The domain is being created"})
+	case r.Method == http.MethodGet && path == "domain/domains":
+		var names []string
+		for n := range s.account {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		domains := []map[string]string{}
+		for i := (page - 1) * perPage; i < len(names) && i < page*perPage; i++ {
+			domains = append(domains, map[string]string{"fqdn": names[i]})
+		}
+		writeStandInJSON(w, http.StatusOK, domains)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "domain/domains/"):
+		name := strings.TrimPrefix(path, "domain/domains/")
+		if !s.account[name] {
+			writeStandInJSON(w, http.StatusNotFound, map[string]string{"message": "The requested resource does not exist"})
+			return
+		}
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{
+			"fqdn":   name,
+			"status": s.statuses[name],
+			"dates": map[string]string{
+				"registry_ends_at": "2020-06-01T12:00:00Z",
+				"updated_at":       "2019-06-01T12:00:00Z",
+			},
+		})
+	default:
+		writeStandInJSON(w, http.StatusNotFound, map[string]string{"message": "The requested resource does not exist"})
+	}
+}
+
+func (s *gandiStandIn) check(w http.ResponseWriter, name, currency string) {
+	status := "available"
+	if s.taken[name] || s.account[name] || s.pending[name] {
+		status = "unavailable"
+	}
+	if strings.HasSuffix(name, ".invalid") {
+		status = "error"
+	}
+	writeStandInJSON(w, http.StatusOK, map[string]interface{}{
+		"currency": currency,
+		"grid":     "A",
+		"products": []map[string]interface{}{{
+			"name":    name,
+			"status":  status,
+			"process": "create",
+			"prices": []map[string]interface{}{
+				{"min_duration": 1, "max_duration": 10, "duration_unit": "y", "price_after_taxes": 14.52, "price_before_taxes": 12.0},
+			},
+		}},
+	})
+}
+
+func newStandInGandi(t *testing.T, cfg GandiConfig) checker.Registrar {
+	t.Helper()
+	g, err := NewGandi(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGandiConformance(t *testing.T) {
+	s := newGandiStandIn()
+	defer s.server.Close()
+
+	checkertest.RunConformance(t, func() checker.Registrar {
+		s.mu.Lock()
+		s.taken = map[string]bool{"taken.com": true}
+		s.account = make(map[string]bool)
+		s.pending = make(map[string]bool)
+		s.mu.Unlock()
+		return newStandInGandi(t, s.config())
+	},
+		checkertest.Fixture{Domain: "free.com", Status: checker.Available, Register: checker.Processing},
+		checkertest.Fixture{Domain: "taken.com", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "broken.invalid", Err: true},
+	)
+}
+
+func TestGandiPrice(t *testing.T) {
+	s := newGandiStandIn()
+	defer s.server.Close()
+	cfg := s.config()
+	cfg.Currency = "USD"
+
+	d, err := newStandInGandi(t, cfg).(checker.DetailedChecker).CheckDomainDetail("free.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != checker.Available || d.Price == nil || d.Price.Amount != 1452 || d.Price.Currency != "USD" {
+		t.Errorf("Expected an available domain with its price, got %d and %+v", d.Status, d.Price)
+	}
+}
+
+func TestGandiRegisterDomain(t *testing.T) {
+	s := newGandiStandIn()
+	defer s.server.Close()
+	g := newStandInGandi(t, s.config())
+	sr := g.(checker.RegistrationStatusReader)
+
+	if st, err := g.RegisterDomain("free.com"); err != nil || st != checker.Processing {
+		t.Fatalf("Expected Processing, got %d and '%v'", st, err)
+	}
+	if len(s.owners) != 1 || s.owners[0].Family != "Jansen" {
+		t.Errorf("Expected the owner contact to be sent, got %+v", s.owners)
+	}
+	if st, err := sr.RegistrationStatus("free.com"); err != nil || st != checker.Processing {
+		t.Errorf("Expected the registration to be Processing, got %d and '%v'", st, err)
+	}
+	s.complete("free.com")
+	if st, err := sr.RegistrationStatus("free.com"); err != nil || st != checker.Owned {
+		t.Errorf("Expected the completed registration to be Owned, got %d and '%v'", st, err)
+	}
+	if _, err := g.RegisterDomain("free.com"); err == nil {
+		t.Error("Expected an error registering an owned domain")
+	}
+}
+
+func TestGandiListDomainsAndLifecycle(t *testing.T) {
+	s := newGandiStandIn()
+	defer s.server.Close()
+	g := newStandInGandi(t, s.config())
+	for i := 0; i < gandiPageSize+5; i++ {
+		s.account[fmt.Sprintf("domain%03d.com", i)] = true
+	}
+	s.statuses["domain000.com"] = []string{checker.StatusCodeAutoRenewPeriod}
+
+	names, err := g.(checker.DomainLister).ListDomains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != gandiPageSize+5 || names[gandiPageSize] != "domain100.com" {
+		t.Errorf("Expected all pages to be read, got %d domains", len(names))
+	}
+
+	lr := g.(checker.LifecycleReader)
+	l, err := lr.Lifecycle("domain000.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.HasStatus(checker.StatusCodeAutoRenewPeriod) || !l.Expires.Equal(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected lifecycle %+v", l)
+	}
+	if l, err := lr.Lifecycle("elsewhere.com"); err != nil || len(l.Statuses) != 0 {
+		t.Errorf("Expected an empty lifecycle for a domain outside the account, got %+v and '%v'", l, err)
+	}
+}
+
+func TestGandiUnauthorized(t *testing.T) {
+	s := newGandiStandIn()
+	defer s.server.Close()
+	cfg := s.config()
+	cfg.APIKey = "wrong"
+
+	if _, err := newStandInGandi(t, cfg).CheckDomain("free.com"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected an unauthorized error, got '%v'", err)
+	}
+	if _, err := NewGandi(GandiConfig{}); err == nil {
+		t.Error("Expected an error without API key")
+	}
+}
@@ -0,0 +1,483 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"gopkg.in/yaml.v2"
+)
+
+// httpMaxResponse limits the size of a response that is read
+const httpMaxResponse = 1 << 20
+
+// statusNames are the names statuses are referred to by in configuration and protocols
+var statusNames = map[string]checker.Status{
+	"unavailable":  checker.Unavailable,
+	"owned":        checker.Owned,
+	"available":    checker.Available,
+	"processing":   checker.Processing,
+	"taken":        checker.Taken,
+	"transferable": checker.Transferable,
+}
+
+// HTTPConfig describes a registrar with a simple HTTP API, it is read from YAML. All strings
+// except the extraction rules are Go templates (text/template) executed with .Domain, .BaseURL
+// and .Vars, the env function reads environment variables and json encodes a value as JSON.
+//
+//	name: smallreg
+//	base_url: https://api.smallreg.example/v1
+//	headers:
+//	  Authorization: 'Bearer {{ env "SMALLREG_TOKEN" }}'
+//	check:
+//	  url: '{{ .BaseURL }}/domains/{{ .Domain | urlquery }}'
+//	  extract:
+//	    path: $.result.status
+//	    statuses: {free: available, taken: unavailable, yours: owned}
+//	register:
+//	  method: POST
+//	  url: '{{ .BaseURL }}/domains'
+//	  body: '{"name": {{ json .Domain }}}'
+//	  extract:
+//	    codes: {201: owned, 202: processing}
+type HTTPConfig struct {
+	// Name is the name of the registrar as used in logging and errors
+	Name string `yaml:"name"`
+	// BaseURL is available in the templates as .BaseURL
+	BaseURL string `yaml:"base_url"`
+	// Vars are available in the templates as .Vars
+	Vars map[string]string `yaml:"vars"`
+	// Headers are sent with every request, like authentication headers
+	Headers map[string]string `yaml:"headers"`
+	// Timeout limits the duration of a request, defaults to 30 seconds
+	Timeout time.Duration `yaml:"timeout"`
+	// Check describes the availability check
+	Check HTTPRequest `yaml:"check"`
+	// Register describes the registration, without it the registrar is read-only
+	Register *HTTPRequest `yaml:"register"`
+}
+
+// HTTPRequest describes a request and how its response is mapped onto a status
+type HTTPRequest struct {
+	// Method defaults to GET
+	Method string `yaml:"method"`
+	URL    string `yaml:"url"`
+	// Headers are added to the headers of the registrar
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	// Extract maps the response onto a status
+	Extract HTTPExtract `yaml:"extract"`
+}
+
+// HTTPExtract maps a response onto a status. The HTTP status code is looked up in Codes first,
+// after that the value at Path is looked up in Statuses. Other responses with a code outside the
+// 2xx range are errors. Statuses are named available, unavailable, taken, transferable, owned
+// and processing.
+type HTTPExtract struct {
+	// Codes maps HTTP status codes onto statuses, like 404 onto available
+	Codes map[int]string `yaml:"codes"`
+	// Path selects a value in the JSON response like "$.result.status" or "$.items[0].free",
+	// booleans and numbers are matched in their JSON notation
+	Path string `yaml:"path"`
+	// Statuses maps the extracted values onto statuses
+	Statuses map[string]string `yaml:"statuses"`
+	// Default is the status of values not in Statuses, when empty those are errors
+	Default string `yaml:"default"`
+}
+
+// mapped returns every status the extraction can result in
+func (e HTTPExtract) mapped() []string {
+	var s []string
+	for _, v := range e.Codes {
+		s = append(s, v)
+	}
+	for _, v := range e.Statuses {
+		s = append(s, v)
+	}
+	if e.Default != "" {
+		s = append(s, e.Default)
+	}
+	return s
+}
+
+// HTTPExtractError reports a response that could not be mapped onto a status
+type HTTPExtractError struct {
+	// Registrar and Operation tell which request the response belongs to
+	Registrar string
+	Operation string
+	// StatusCode and Body are the response that was read
+	StatusCode int
+	Body       string
+	// Reason tells why no status was found
+	Reason string
+}
+
+func (e *HTTPExtractError) Error() string {
+	body := e.Body
+	if len(body) > 200 {
+		body = body[:200] + "..."
+	}
+	return fmt.Sprintf("%s %s: %s (HTTP status %d, response %q)", e.Registrar, e.Operation, e.Reason, e.StatusCode, body)
+}
+
+// httpRequest is a HTTPRequest with parsed templates
+type httpRequest struct {
+	method  string
+	url     *template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+	extract HTTPExtract
+}
+
+type httpTemplateData struct {
+	Domain  string
+	BaseURL string
+	Vars    map[string]string
+}
+
+type httpAPI struct {
+	cfg      HTTPConfig
+	check    *httpRequest
+	register *httpRequest
+	http     *http.Client
+}
+
+// Name returns the configured name of this registrar
+func (h *httpAPI) Name() string {
+	return h.cfg.Name
+}
+
+// CheckDomain performs the configured check request
+func (h *httpAPI) CheckDomain(name string) (checker.Status, error) {
+	d, err := h.CheckDomainDetail(name)
+	return d.Status, err
+}
+
+// CheckDomainDetail performs the configured check request, the raw value is the extracted value
+func (h *httpAPI) CheckDomainDetail(name string) (checker.Detail, error) {
+	s, raw, err := h.do("check", h.check, name)
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	return checker.Detail{Status: s, Raw: raw}, nil
+}
+
+// RegisterDomain performs the configured register request
+func (h *httpAPI) RegisterDomain(name string) (checker.Status, error) {
+	if h.register == nil {
+		return checker.Unavailable, fmt.Errorf("%s can not register '%s': %w", h.cfg.Name, name, checker.ErrReadOnly)
+	}
+	s, _, err := h.do("register", h.register, name)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	return s, nil
+}
+
+// do performs the request for the domain and returns the status with the value it was based on
+func (h *httpAPI) do(op string, r *httpRequest, name string) (checker.Status, string, error) {
+	data := httpTemplateData{Domain: name, BaseURL: h.cfg.BaseURL, Vars: h.cfg.Vars}
+	u, err := executeTemplate(r.url, data)
+	if err != nil {
+		return checker.Unavailable, "", err
+	}
+	body, err := executeTemplate(r.body, data)
+	if err != nil {
+		return checker.Unavailable, "", err
+	}
+	req, err := http.NewRequest(r.method, u, strings.NewReader(body))
+	if err != nil {
+		return checker.Unavailable, "", err
+	}
+	for k, t := range r.headers {
+		v, err := executeTemplate(t, data)
+		if err != nil {
+			return checker.Unavailable, "", err
+		}
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.http.Do(req)
+	if err != nil {
+		return checker.Unavailable, "", fmt.Errorf("%s %s request failed: %w", h.cfg.Name, op, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpMaxResponse+1))
+	if err != nil {
+		return checker.Unavailable, "", err
+	}
+	if len(b) > httpMaxResponse {
+		return checker.Unavailable, "", fmt.Errorf("%s %s response exceeds %d bytes", h.cfg.Name, op, httpMaxResponse)
+	}
+
+	extractErr := func(reason string, args ...interface{}) error {
+		return &HTTPExtractError{
+			Registrar:  h.cfg.Name,
+			Operation:  op,
+			StatusCode: resp.StatusCode,
+			Body:       string(b),
+			Reason:     fmt.Sprintf(reason, args...),
+		}
+	}
+	e := r.extract
+	if s, ok := e.Codes[resp.StatusCode]; ok {
+		return statusNames[s], strconv.Itoa(resp.StatusCode), nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return checker.Unavailable, "", extractErr("unexpected HTTP status")
+	}
+	if e.Path == "" {
+		return checker.Unavailable, "", extractErr("no status is configured for this HTTP status and there is no path to extract")
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return checker.Unavailable, "", extractErr("response is not valid JSON: %v", err)
+	}
+	v, err := jsonPath(doc, e.Path)
+	if err != nil {
+		return checker.Unavailable, "", extractErr("could not extract %s: %v", e.Path, err)
+	}
+	raw, err := jsonScalar(v)
+	if err != nil {
+		return checker.Unavailable, "", extractErr("could not extract %s: %v", e.Path, err)
+	}
+	s, ok := e.Statuses[raw]
+	if !ok {
+		if e.Default == "" {
+			return checker.Unavailable, raw, extractErr("value %q at %s is not mapped onto a status", raw, e.Path)
+		}
+		s = e.Default
+	}
+	return statusNames[s], raw, nil
+}
+
+// jsonPath selects a value from a decoded JSON document. It supports the subset of JSONPath of
+// member names and array indices, like "$.result.items[0].status".
+func jsonPath(doc interface{}, path string) (interface{}, error) {
+	segs, err := jsonPathSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	v := doc
+	at := "$"
+	for _, seg := range segs {
+		if seg[0] == '[' {
+			i, _ := strconv.Atoi(seg[1 : len(seg)-1])
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is %s, not an array", at, jsonKind(v))
+			}
+			if i < 0 || i >= len(a) {
+				return nil, fmt.Errorf("%s has %d elements, index %d is out of range", at, len(a), i)
+			}
+			v = a[i]
+			at += seg
+			continue
+		}
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is %s, not an object", at, jsonKind(v))
+		}
+		if v, ok = o[seg]; !ok {
+			return nil, fmt.Errorf("%s has no member %q", at, seg)
+		}
+		at += "." + seg
+	}
+	return v, nil
+}
+
+// jsonPathSegments splits a path into member names and indices like "[0]", so paths can be
+// validated before any document is at hand
+func jsonPathSegments(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q should start with $", path)
+	}
+	p := path[1:]
+	if p != "" && p[0] != '.' && p[0] != '[' {
+		p = "." + p
+	}
+	var segs []string
+	at := "$"
+	for p != "" {
+		var seg string
+		switch p[0] {
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] after %s", at)
+			}
+			seg, p = p[:end+1], p[end+1:]
+			if _, err := strconv.Atoi(seg[1 : len(seg)-1]); err != nil {
+				return nil, fmt.Errorf("invalid index %s after %s", seg, at)
+			}
+			at += seg
+		case '.':
+			end := strings.IndexAny(p[1:], ".[") + 1
+			if end == 0 {
+				end = len(p)
+			}
+			seg, p = p[1:end], p[end:]
+			if seg == "" {
+				return nil, fmt.Errorf("empty member name after %s", at)
+			}
+			at += "." + seg
+		default:
+			return nil, fmt.Errorf("expected . or [ after %s", at)
+		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// jsonScalar formats a string, number or boolean, other values can not be mapped
+func jsonScalar(v interface{}) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("value is %s, expected a string, number or boolean", jsonKind(v))
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+var httpTemplateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func executeTemplate(t *template.Template, data httpTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// parse validates the request description and parses its templates
+func (r HTTPRequest) parse(op string, headers map[string]string) (*httpRequest, error) {
+	if r.URL == "" {
+		return nil, fmt.Errorf("%s: url is required", op)
+	}
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	p := &httpRequest{method: strings.ToUpper(r.Method), headers: make(map[string]*template.Template), extract: r.Extract}
+
+	var err error
+	if p.url, err = template.New(op + ".url").Funcs(httpTemplateFuncs).Parse(r.URL); err != nil {
+		return nil, err
+	}
+	if p.body, err = template.New(op + ".body").Funcs(httpTemplateFuncs).Parse(r.Body); err != nil {
+		return nil, err
+	}
+	for _, h := range []map[string]string{headers, r.Headers} {
+		for k, v := range h {
+			if p.headers[k], err = template.New(op + ".headers." + k).Funcs(httpTemplateFuncs).Parse(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	e := r.Extract
+	if len(e.Codes) == 0 && e.Path == "" {
+		return nil, fmt.Errorf("%s: extract needs codes or a path", op)
+	}
+	if e.Path != "" {
+		if _, err := jsonPathSegments(e.Path); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	for code, s := range e.Codes {
+		if _, ok := statusNames[s]; !ok {
+			return nil, fmt.Errorf("%s: unknown status %q for HTTP status %d", op, s, code)
+		}
+	}
+	for v, s := range e.Statuses {
+		if _, ok := statusNames[s]; !ok {
+			return nil, fmt.Errorf("%s: unknown status %q for value %q", op, s, v)
+		}
+	}
+	if _, ok := statusNames[e.Default]; e.Default != "" && !ok {
+		return nil, fmt.Errorf("%s: unknown default status %q", op, e.Default)
+	}
+	// a registration can not leave a domain available, that would register it over and over
+	if op == "register" {
+		for _, s := range e.mapped() {
+			if statusNames[s] == checker.Available {
+				return nil, fmt.Errorf("%s: a registration can not result in %s", op, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+// NewHTTP returns a registrar for a simple HTTP API as described by the configuration
+func NewHTTP(cfg HTTPConfig) (checker.Registrar, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("HTTP registrar requires a name")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	check, err := cfg.Check.parse("check", cfg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP registrar '%s': %w", cfg.Name, err)
+	}
+	h := &httpAPI{cfg: cfg, check: check, http: &http.Client{Timeout: cfg.Timeout}}
+	if cfg.Register != nil {
+		if h.register, err = cfg.Register.parse("register", cfg.Headers); err != nil {
+			return nil, fmt.Errorf("invalid HTTP registrar '%s': %w", cfg.Name, err)
+		}
+	}
+	return h, nil
+}
+
+// LoadHTTPConfig reads the description of a registrar with a simple HTTP API from a YAML file,
+// the description is validated like NewHTTP does
+func LoadHTTPConfig(path string) (HTTPConfig, error) {
+	var cfg HTTPConfig
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid HTTP registrar in %s: %w", path, err)
+	}
+	if _, err := NewHTTP(cfg); err != nil {
+		return cfg, fmt.Errorf("in %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+// httpStandIn serves the API described in testdata/http/smallreg.yaml. Domains starting with
+// "gone" are reported as not found, "slow" domains are registered asynchronously and "odd"
+// domains have a state that is not described.
+type httpStandIn struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	states map[string]string
+}
+
+func newHTTPStandIn() *httpStandIn {
+	s := &httpStandIn{}
+	s.reset()
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *httpStandIn) reset() {
+	s.mu.Lock()
+	s.states = map[string]string{"taken.test": "taken", "mine.test": "mine", "odd.test": "reserved"}
+	s.mu.Unlock()
+}
+
+func (s *httpStandIn) config(t *testing.T) HTTPConfig {
+	t.Helper()
+	cfg, err := LoadHTTPConfig("testdata/http/smallreg.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BaseURL = s.server.URL
+	return cfg
+}
+
+func (s *httpStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer standin-token" {
+		writeStandInError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/domains/"):
+		name := strings.TrimPrefix(r.URL.Path, "/domains/")
+		if strings.HasPrefix(name, "gone") {
+			writeStandInError(w, http.StatusNotFound, "no such domain")
+			return
+		}
+		if strings.HasSuffix(name, ".invalid") {
+			writeStandInJSON(w, http.StatusOK, map[string]interface{}{"result": map[string]interface{}{"domains": []interface{}{}}})
+			return
+		}
+		state, ok := s.states[name]
+		if !ok {
+			state = "free"
+		}
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{
+			"result": map[string]interface{}{"domains": []interface{}{map[string]string{"name": name, "state": state}}},
+		})
+	case r.Method == http.MethodPost && r.URL.Path == "/accounts/acme/domains":
+		var req struct {
+			Name string `json:"name"`
+		}
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			writeStandInError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+		if _, ok := s.states[req.Name]; ok || strings.HasSuffix(req.Name, ".invalid") {
+			writeStandInError(w, http.StatusConflict, "domain is not available")
+			return
+		}
+		if strings.HasPrefix(req.Name, "slow") {
+			s.states[req.Name] = "pending"
+			writeStandInJSON(w, http.StatusAccepted, map[string]string{})
+			return
+		}
+		s.states[req.Name] = "mine"
+		writeStandInJSON(w, http.StatusOK, map[string]bool{"registered": true})
+	default:
+		writeStandInError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func newStandInHTTP(t *testing.T, cfg HTTPConfig) checker.Registrar {
+	t.Helper()
+	h, err := NewHTTP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func setStandInToken(t *testing.T) func() {
+	t.Helper()
+	if err := os.Setenv("SMALLREG_TEST_TOKEN", "standin-token"); err != nil {
+		t.Fatal(err)
+	}
+	return func() { os.Unsetenv("SMALLREG_TEST_TOKEN") }
+}
+
+func TestHTTPConformance(t *testing.T) {
+	defer setStandInToken(t)()
+	s := newHTTPStandIn()
+	defer s.server.Close()
+
+	checkertest.RunConformance(t, func() checker.Registrar {
+		s.reset()
+		return newStandInHTTP(t, s.config(t))
+	},
+		checkertest.Fixture{Domain: "free.test", Status: checker.Available, Register: checker.Owned},
+		checkertest.Fixture{Domain: "gone.test", Status: checker.Available, Register: checker.Owned},
+		checkertest.Fixture{Domain: "taken.test", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "mine.test", Status: checker.Owned},
+		checkertest.Fixture{Domain: "domain.invalid", Err: true},
+	)
+}
+
+func TestHTTPRegisterDomain(t *testing.T) {
+	defer setStandInToken(t)()
+	s := newHTTPStandIn()
+	defer s.server.Close()
+	h := newStandInHTTP(t, s.config(t))
+
+	if st, err := h.RegisterDomain("free.test"); err != nil || st != checker.Owned {
+		t.Errorf("Expected free.test to be Owned, got %d and '%v'", st, err)
+	}
+	if st, err := h.RegisterDomain("slow.test"); err != nil || st != checker.Processing {
+		t.Errorf("Expected slow.test to be Processing, got %d and '%v'", st, err)
+	}
+	if st, err := h.CheckDomain("slow.test"); err != nil || st != checker.Processing {
+		t.Errorf("Expected slow.test to be checked as Processing, got %d and '%v'", st, err)
+	}
+	if _, err := h.RegisterDomain("taken.test"); err == nil || !strings.Contains(err.Error(), "HTTP status 409") {
+		t.Errorf("Expected the conflict to be reported, got '%v'", err)
+	}
+
+	cfg := s.config(t)
+	cfg.Register = nil
+	if _, err := newStandInHTTP(t, cfg).RegisterDomain("free.test"); !errors.Is(err, checker.ErrReadOnly) {
+		t.Errorf("Expected a registrar without register request to be read-only, got '%v'", err)
+	}
+}
+
+func TestHTTPExtractErrors(t *testing.T) {
+	defer setStandInToken(t)()
+	s := newHTTPStandIn()
+	defer s.server.Close()
+	h := newStandInHTTP(t, s.config(t))
+
+	tests := map[string]string{
+		"odd.test":       `value "reserved" at $.result.domains[0].state is not mapped onto a status`,
+		"domain.invalid": "$.result.domains has 0 elements, index 0 is out of range",
+	}
+	for domain, reason := range tests {
+		_, err := h.CheckDomain(domain)
+		var e *HTTPExtractError
+		if !errors.As(err, &e) {
+			t.Errorf("Expected an extraction error for %s, got '%v'", domain, err)
+			continue
+		}
+		if !strings.Contains(e.Reason, reason) || e.Registrar != "smallreg" || e.Operation != "check" {
+			t.Errorf("Expected the reason '%s' for %s, got '%v'", reason, domain, err)
+		}
+	}
+
+	cfg := s.config(t)
+	cfg.Check.Extract.Default = "unavailable"
+	if st, err := newStandInHTTP(t, cfg).CheckDomain("odd.test"); err != nil || st != checker.Unavailable {
+		t.Errorf("Expected the default status, got %d and '%v'", st, err)
+	}
+
+	os.Unsetenv("SMALLREG_TEST_TOKEN")
+	if _, err := h.CheckDomain("free.test"); err == nil || !strings.Contains(err.Error(), "HTTP status 401") {
+		t.Errorf("Expected an unauthorized error, got '%v'", err)
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{"a": {"b": [{"c": true}, 12.5]}, "s": "x"}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"$.a.b[0].c": "true", "$.a.b[1]": "12.5", "$.s": "x"} {
+		v, err := jsonPath(doc, path)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", path, err)
+			continue
+		}
+		if got, err := jsonScalar(v); err != nil || got != want {
+			t.Errorf("Expected %s at %s, got '%s' and '%v'", want, path, got, err)
+		}
+	}
+	for path, want := range map[string]string{
+		"$.a.x":     `$.a has no member "x"`,
+		"$.s[0]":    "$.s is a string, not an array",
+		"$.a.b.c":   "$.a.b is an array, not an object",
+		"$.a.b[x]":  "invalid index [x] after $.a.b",
+		"$..a":      "empty member name after $",
+		"$.a..b":    "empty member name after $.a",
+		"$.a.[0]":   "empty member name after $.a",
+		"$.a.b[0":   "missing ] after $.a.b",
+		"$.a.b[0]c": "expected . or [ after $.a.b[0]",
+	} {
+		if _, err := jsonPath(doc, path); err == nil || err.Error() != want {
+			t.Errorf("Expected '%s' for %s, got '%v'", want, path, err)
+		}
+	}
+}
+
+func TestHTTPInvalidConfig(t *testing.T) {
+	s := newHTTPStandIn()
+	defer s.server.Close()
+
+	tests := map[string]func(*HTTPConfig){
+		"without name":        func(c *HTTPConfig) { c.Name = "" },
+		"without check url":   func(c *HTTPConfig) { c.Check.URL = "" },
+		"unknown status":      func(c *HTTPConfig) { c.Check.Extract.Statuses["free"] = "free" },
+		"invalid template":    func(c *HTTPConfig) { c.Check.URL = "{{ .Domain" },
+		"path without $":      func(c *HTTPConfig) { c.Check.Extract.Path = "result.state" },
+		"empty path segment":  func(c *HTTPConfig) { c.Check.Extract.Path = "$..state" },
+		"without extraction":  func(c *HTTPConfig) { c.Register.Extract = HTTPExtract{} },
+		"unknown status code": func(c *HTTPConfig) { c.Register.Extract.Codes[201] = "done" },
+		"register available":  func(c *HTTPConfig) { c.Register.Extract.Codes[201] = "available" },
+		"register default":    func(c *HTTPConfig) { c.Register.Extract.Default = "available" },
+	}
+	for name, modify := range tests {
+		cfg := s.config(t)
+		modify(&cfg)
+		if _, err := NewHTTP(cfg); err == nil {
+			t.Errorf("Expected an error for a configuration %s", name)
+		}
+	}
+	if _, err := LoadHTTPConfig("testdata/http/missing.yaml"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	f, err := ioutil.TempFile("", "http-registrar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("name: loop\ncheck: {url: x, extract: {codes: {404: available}}}\nregister: {url: x, extract: {codes: {200: available}}}\n")
+	f.Close()
+	if _, err := LoadHTTPConfig(f.Name()); err == nil {
+		t.Error("Expected a registration resulting in available to be refused when the file is read")
+	}
+}
@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+const (
+	namecheapProduction = "https://api.namecheap.com/xml.response"
+	namecheapSandbox    = "https://api.sandbox.namecheap.com/xml.response"
+
+	// namecheapDomainNotFound is the error domains.getInfo reports for domains that are not
+	// in the account
+	namecheapDomainNotFound = "2019166"
+)
+
+// NamecheapContact is a contact profile as used for new domains
+type NamecheapContact struct {
+	FirstName        string
+	LastName         string
+	OrganizationName string
+	Address1         string
+	City             string
+	StateProvince    string
+	PostalCode       string
+	// Country is the two letter country code
+	Country string
+	// Phone is formatted like +31.201234567
+	Phone        string
+	EmailAddress string
+}
+
+// NamecheapConfig holds the settings for a Namecheap registrar
+type NamecheapConfig struct {
+	// APIUser and APIKey are the API credentials, UserName is the account acted upon and
+	// defaults to APIUser
+	APIUser  string
+	APIKey   string
+	UserName string
+	// ClientIP is the IP address requests are made from, it has to be whitelisted in the
+	// Namecheap control panel
+	ClientIP string
+	// Sandbox selects the sandbox API instead of production
+	Sandbox bool
+	// Endpoint overrides the endpoint selected by Sandbox
+	Endpoint string
+	// Years is the registration period, defaults to 1
+	Years int
+	// Registrant is the contact profile for new domains, Admin, Tech and AuxBilling default
+	// to it
+	Registrant NamecheapContact
+	Admin      *NamecheapContact
+	Tech       *NamecheapContact
+	AuxBilling *NamecheapContact
+	// AllowPremium allows the registration of premium domains at their premium price
+	AllowPremium bool
+}
+
+// NamecheapError is an error reported in the body of a Namecheap response
+type NamecheapError struct {
+	Number  string
+	Message string
+}
+
+func (e *NamecheapError) Error() string {
+	return fmt.Sprintf("Namecheap error %s: %s", e.Number, e.Message)
+}
+
+// namecheapResponse holds the parts of Namecheap responses used here
+type namecheapResponse struct {
+	Status string `xml:"Status,attr"`
+	Errors []struct {
+		Number  string `xml:"Number,attr"`
+		Message string `xml:",chardata"`
+	} `xml:"Errors>Error"`
+	Check  []namecheapCheckResult `xml:"CommandResponse>DomainCheckResult"`
+	Create *struct {
+		Registered    bool   `xml:"Registered,attr"`
+		ChargedAmount string `xml:"ChargedAmount,attr"`
+		NonRealTime   bool   `xml:"NonRealTimeDomain,attr"`
+	} `xml:"CommandResponse>DomainCreateResult"`
+	Info *struct {
+		Status  string `xml:"Status,attr"`
+		IsOwner bool   `xml:"IsOwner,attr"`
+	} `xml:"CommandResponse>DomainGetInfoResult"`
+}
+
+type namecheapCheckResult struct {
+	Domain                   string `xml:"Domain,attr"`
+	Available                bool   `xml:"Available,attr"`
+	ErrorNo                  string `xml:"ErrorNo,attr"`
+	Description              string `xml:"Description,attr"`
+	IsPremiumName            bool   `xml:"IsPremiumName,attr"`
+	PremiumRegistrationPrice string `xml:"PremiumRegistrationPrice,attr"`
+	EapFee                   string `xml:"EapFee,attr"`
+}
+
+type namecheap struct {
+	cfg      NamecheapConfig
+	endpoint string
+	http     *http.Client
+}
+
+// Name returns the name of this registrar
+func (n *namecheap) Name() string {
+	return "namecheap"
+}
+
+// CheckDomain checks the availability of the domain with domains.check
+func (n *namecheap) CheckDomain(name string) (checker.Status, error) {
+	d, err := n.CheckDomainDetail(name)
+	return d.Status, err
+}
+
+// CheckDomainDetail checks the availability of the domain, premium domains come with their price
+func (n *namecheap) CheckDomainDetail(name string) (checker.Detail, error) {
+	r, err := n.check(name)
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	d := checker.Detail{Status: checker.Unavailable, Raw: fmt.Sprintf("available=%t premium=%t", r.Available, r.IsPremiumName)}
+	if r.Available {
+		d.Status = checker.Available
+	}
+	if r.IsPremiumName {
+		amount, err := parseCents(r.PremiumRegistrationPrice)
+		if err != nil {
+			return checker.Detail{Status: checker.Unavailable}, err
+		}
+		d.Price = &checker.Price{Amount: amount, Currency: "USD", Premium: true}
+	}
+	return d, nil
+}
+
+// RegisterDomain creates the domain with the configured contact profiles. Premium domains are
+// only registered when that is allowed.
+func (n *namecheap) RegisterDomain(name string) (checker.Status, error) {
+	c, err := n.check(name)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	if !c.Available {
+		return checker.Unavailable, fmt.Errorf("domain '%s' is not available at Namecheap", name)
+	}
+
+	params := url.Values{
+		"DomainName": {name},
+		"Years":      {strconv.Itoa(n.cfg.Years)},
+	}
+	if c.IsPremiumName {
+		if !n.cfg.AllowPremium {
+			return checker.Unavailable, fmt.Errorf("domain '%s' is a premium domain priced at %s USD, premium registrations are not allowed", name, c.PremiumRegistrationPrice)
+		}
+		params.Set("IsPremiumDomain", "true")
+		params.Set("PremiumPrice", c.PremiumRegistrationPrice)
+		if c.EapFee != "" {
+			params.Set("EapFee", c.EapFee)
+		}
+	}
+	contacts := map[string]*NamecheapContact{
+		"Registrant": &n.cfg.Registrant,
+		"Admin":      n.cfg.Admin,
+		"Tech":       n.cfg.Tech,
+		"AuxBilling": n.cfg.AuxBilling,
+	}
+	for role, contact := range contacts {
+		if contact == nil {
+			contact = &n.cfg.Registrant
+		}
+		contact.encode(role, params)
+	}
+
+	r, err := n.call("namecheap.domains.create", params)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	switch {
+	case r.Create == nil:
+		return checker.Unavailable, errors.New("Namecheap create response holds no result")
+	case r.Create.NonRealTime:
+		return checker.Processing, nil
+	case r.Create.Registered:
+		return checker.Owned, nil
+	}
+	return checker.Unavailable, fmt.Errorf("Namecheap did not register '%s'", name)
+}
+
+// RegistrationStatus follows up on a non real time registration with domains.getInfo, the
+// domain is Owned once it shows up in the account
+func (n *namecheap) RegistrationStatus(name string) (checker.Status, error) {
+	r, err := n.call("namecheap.domains.getInfo", url.Values{"DomainName": {name}})
+	var ncErr *NamecheapError
+	if errors.As(err, &ncErr) && ncErr.Number == namecheapDomainNotFound {
+		return checker.Processing, nil
+	}
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	if r.Info == nil || !r.Info.IsOwner {
+		return checker.Processing, nil
+	}
+	return checker.Owned, nil
+}
+
+// encode adds the contact to the parameters, prefixed with its role
+func (c *NamecheapContact) encode(role string, params url.Values) {
+	for k, v := range map[string]string{
+		"FirstName":        c.FirstName,
+		"LastName":         c.LastName,
+		"OrganizationName": c.OrganizationName,
+		"Address1":         c.Address1,
+		"City":             c.City,
+		"StateProvince":    c.StateProvince,
+		"PostalCode":       c.PostalCode,
+		"Country":          c.Country,
+		"Phone":            c.Phone,
+		"EmailAddress":     c.EmailAddress,
+	} {
+		if v != "" {
+			params.Set(role+k, v)
+		}
+	}
+}
+
+func (n *namecheap) check(name string) (*namecheapCheckResult, error) {
+	r, err := n.call("namecheap.domains.check", url.Values{"DomainList": {name}})
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range r.Check {
+		if !strings.EqualFold(c.Domain, name) {
+			continue
+		}
+		if c.ErrorNo != "" && c.ErrorNo != "0" {
+			return nil, &NamecheapError{Number: c.ErrorNo, Message: c.Description}
+		}
+		return &r.Check[i], nil
+	}
+	return nil, fmt.Errorf("Namecheap check response holds no result for '%s'", name)
+}
+
+// call performs the API command with the global parameters added
+func (n *namecheap) call(command string, params url.Values) (*namecheapResponse, error) {
+	params.Set("ApiUser", n.cfg.APIUser)
+	params.Set("ApiKey", n.cfg.APIKey)
+	params.Set("UserName", n.cfg.UserName)
+	params.Set("ClientIp", n.cfg.ClientIP)
+	params.Set("Command", command)
+
+	resp, err := n.http.PostForm(n.endpoint, params)
+	if err != nil {
+		return nil, fmt.Errorf("request to Namecheap failed: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var r namecheapResponse
+	if err := xml.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("invalid Namecheap response with HTTP status %d: %w", resp.StatusCode, err)
+	}
+	if len(r.Errors) > 0 {
+		return nil, &NamecheapError{Number: r.Errors[0].Number, Message: strings.TrimSpace(r.Errors[0].Message)}
+	}
+	if r.Status != "OK" {
+		return nil, fmt.Errorf("Namecheap response has status '%s'", r.Status)
+	}
+	return &r, nil
+}
+
+// parseCents converts a price like "10.87" into cents
+func parseCents(s string) (int64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price '%s': %w", s, err)
+	}
+	return int64(math.Round(f * 100)), nil
+}
+
+// NewNamecheap returns a registrar using the Namecheap XML API
+func NewNamecheap(cfg NamecheapConfig) (checker.Registrar, error) {
+	if cfg.APIUser == "" || cfg.APIKey == "" {
+		return nil, errors.New("Namecheap API user and key are required")
+	}
+	if cfg.ClientIP == "" {
+		return nil, errors.New("Namecheap requires the whitelisted client IP")
+	}
+	if cfg.UserName == "" {
+		cfg.UserName = cfg.APIUser
+	}
+	if cfg.Years == 0 {
+		cfg.Years = 1
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = namecheapProduction
+		if cfg.Sandbox {
+			endpoint = namecheapSandbox
+		}
+	}
+	return &namecheap{cfg: cfg, endpoint: endpoint, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+// namecheapStandIn replays the recorded responses in testdata/namecheap, named after the
+// command and the domain like "check_free.xml". Creates are recorded for inspection.
+type namecheapStandIn struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	creates []url.Values
+}
+
+func newNamecheapStandIn() *namecheapStandIn {
+	s := &namecheapStandIn{}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *namecheapStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fixture := ""
+	switch {
+	case r.Form.Get("ApiUser") != "standin" || r.Form.Get("ApiKey") != "secret" || r.Form.Get("UserName") != "standin":
+		fixture = "error_api_key.xml"
+	case r.Form.Get("ClientIp") != "192.0.2.10":
+		fixture = "error_client_ip.xml"
+	case r.Form.Get("Command") == "namecheap.domains.check":
+		fixture = "check_" + strings.Split(r.Form.Get("DomainList"), ".")[0] + ".xml"
+	case r.Form.Get("Command") == "namecheap.domains.create":
+		s.mu.Lock()
+		s.creates = append(s.creates, r.Form)
+		s.mu.Unlock()
+		fixture = "create_" + strings.Split(r.Form.Get("DomainName"), ".")[0] + ".xml"
+	case r.Form.Get("Command") == "namecheap.domains.getInfo":
+		fixture = "getinfo_" + strings.Split(r.Form.Get("DomainName"), ".")[0] + ".xml"
+	}
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "namecheap", fixture))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(b)
+}
+
+func (s *namecheapStandIn) config() NamecheapConfig {
+	return NamecheapConfig{
+		APIUser:  "standin",
+		APIKey:   "secret",
+		ClientIP: "192.0.2.10",
+		Endpoint: s.server.URL + "/xml.response",
+		Registrant: NamecheapContact{
+			FirstName:    "Jan",
+			LastName:     "Jansen",
+			Address1:     "Dorpsstraat 1",
+			City:         "Amsterdam",
+			PostalCode:   "1000AA",
+			Country:      "NL",
+			Phone:        "+31.201234567",
+			EmailAddress: "jan@example.org",
+		},
+		Tech: &NamecheapContact{FirstName: "Tech", LastName: "Support", EmailAddress: "tech@example.org"},
+	}
+}
+
+func newStandInNamecheap(t *testing.T, cfg NamecheapConfig) checker.Registrar {
+	t.Helper()
+	n, err := NewNamecheap(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNamecheapConformance(t *testing.T) {
+	s := newNamecheapStandIn()
+	defer s.server.Close()
+
+	checkertest.RunConformance(t, func() checker.Registrar { return newStandInNamecheap(t, s.config()) },
+		checkertest.Fixture{Domain: "free.com", Status: checker.Available, Register: checker.Owned},
+		checkertest.Fixture{Domain: "taken.com", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "premium.com", Status: checker.Available},
+		checkertest.Fixture{Domain: "unsupported.tld", Err: true},
+	)
+}
+
+func TestNamecheapPremiumPrice(t *testing.T) {
+	s := newNamecheapStandIn()
+	defer s.server.Close()
+	n := newStandInNamecheap(t, s.config()).(checker.DetailedChecker)
+
+	d, err := n.CheckDomainDetail("premium.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Price == nil || d.Price.Amount != 261000 || d.Price.Currency != "USD" || !d.Price.Premium {
+		t.Errorf("Expected the premium price, got %+v", d.Price)
+	}
+	if d, _ := n.CheckDomainDetail("free.com"); d.Price != nil {
+		t.Errorf("Expected no price for a regular domain, got %+v", d.Price)
+	}
+}
+
+func TestNamecheapRegisterDomain(t *testing.T) {
+	s := newNamecheapStandIn()
+	defer s.server.Close()
+	n := newStandInNamecheap(t, s.config())
+
+	if st, err := n.RegisterDomain("free.com"); err != nil || st != checker.Owned {
+		t.Errorf("Expected free.com to be Owned, got %d and '%v'", st, err)
+	}
+	if st, err := n.RegisterDomain("pending.com"); err != nil || st != checker.Processing {
+		t.Errorf("Expected pending.com to be Processing, got %d and '%v'", st, err)
+	}
+	if _, err := n.RegisterDomain("premium.com"); err == nil {
+		t.Error("Expected premium registrations to be refused by default")
+	}
+	if len(s.creates) != 2 {
+		t.Fatalf("Expected 2 creates, stand-in received %d", len(s.creates))
+	}
+
+	c := s.creates[0]
+	if c.Get("RegistrantFirstName") != "Jan" || c.Get("AdminFirstName") != "Jan" || c.Get("AuxBillingEmailAddress") != "jan@example.org" {
+		t.Errorf("Expected the registrant profile to be used for missing contacts, got %v", c)
+	}
+	if c.Get("TechFirstName") != "Tech" || c.Get("Years") != "1" {
+		t.Errorf("Expected the tech profile and a single year, got %v", c)
+	}
+
+	cfg := s.config()
+	cfg.AllowPremium = true
+	n = newStandInNamecheap(t, cfg)
+	if st, err := n.RegisterDomain("premium.com"); err != nil || st != checker.Owned {
+		t.Errorf("Expected premium.com to be Owned, got %d and '%v'", st, err)
+	}
+	c = s.creates[len(s.creates)-1]
+	if c.Get("IsPremiumDomain") != "true" || c.Get("PremiumPrice") != "2610.0000" {
+		t.Errorf("Expected the premium price to be confirmed, got %v", c)
+	}
+}
+
+func TestNamecheapRegistrationStatus(t *testing.T) {
+	s := newNamecheapStandIn()
+	defer s.server.Close()
+	n := newStandInNamecheap(t, s.config()).(checker.RegistrationStatusReader)
+
+	if st, err := n.RegistrationStatus("pending.com"); err != nil || st != checker.Processing {
+		t.Errorf("Expected pending.com to be Processing, got %d and '%v'", st, err)
+	}
+	if st, err := n.RegistrationStatus("free.com"); err != nil || st != checker.Owned {
+		t.Errorf("Expected free.com to be Owned, got %d and '%v'", st, err)
+	}
+	if _, err := n.RegistrationStatus("unsupported.tld"); err == nil {
+		t.Error("Expected an error when the status can not be read")
+	}
+}
+
+func TestNamecheapErrors(t *testing.T) {
+	s := newNamecheapStandIn()
+	defer s.server.Close()
+
+	cfg := s.config()
+	cfg.ClientIP = "192.0.2.99"
+	var ncErr *NamecheapError
+	if _, err := newStandInNamecheap(t, cfg).CheckDomain("free.com"); !errors.As(err, &ncErr) || ncErr.Number != "1011150" {
+		t.Errorf("Expected an error for a client IP that is not whitelisted, got '%v'", err)
+	}
+
+	cfg = s.config()
+	cfg.APIKey = "wrong"
+	if _, err := newStandInNamecheap(t, cfg).CheckDomain("free.com"); !errors.As(err, &ncErr) || ncErr.Number != "1011102" {
+		t.Errorf("Expected an error for an invalid API key, got '%v'", err)
+	}
+	if strings.Contains(ncErr.Error(), "wrong") {
+		t.Errorf("Expected the API key to be kept out of errors, got '%v'", ncErr)
+	}
+
+	if _, err := NewNamecheap(NamecheapConfig{APIUser: "standin", APIKey: "secret"}); err == nil {
+		t.Error("Expected an error without client IP")
+	}
+}
+
+func TestNamecheapEndpoints(t *testing.T) {
+	cfg := NamecheapConfig{APIUser: "standin", APIKey: "secret", ClientIP: "192.0.2.10"}
+	n, _ := NewNamecheap(cfg)
+	if e := n.(*namecheap).endpoint; e != namecheapProduction {
+		t.Errorf("Expected the production endpoint, got %s", e)
+	}
+	cfg.Sandbox = true
+	n, _ = NewNamecheap(cfg)
+	if e := n.(*namecheap).endpoint; e != namecheapSandbox {
+		t.Errorf("Expected the sandbox endpoint, got %s", e)
+	}
+}
@@ -0,0 +1,425 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// ovhEndpoints are the APIs of the OVHcloud regions
+var ovhEndpoints = map[string]string{
+	"ovh-eu": "https://eu.api.ovh.com/1.0",
+	"ovh-ca": "https://ca.api.ovh.com/1.0",
+	"ovh-us": "https://api.us.ovhcloud.com/1.0",
+}
+
+// ovhCartMargin is the time before its expiry at which the availability cart is replaced
+const ovhCartMargin = 10 * time.Minute
+
+// ovhOrderLookback limits the orders that are searched for the order of a domain
+const ovhOrderLookback = 30 * 24 * time.Hour
+
+// OVHConfig holds the settings for an OVHcloud registrar
+type OVHConfig struct {
+	// Endpoint is one of "ovh-eu" (the default), "ovh-ca" or "ovh-us", or the URL of an API
+	Endpoint string
+	// ApplicationKey and ApplicationSecret identify the application, ConsumerKey is the key
+	// the account owner validated for it
+	ApplicationKey    string
+	ApplicationSecret string
+	ConsumerKey       string
+	// Subsidiary is the OVH subsidiary carts are created for, defaults to "FR"
+	Subsidiary string
+	// Duration is the registration period as ISO 8601 duration, defaults to "P1Y"
+	Duration string
+	// AutoPay pays orders with the preferred payment method of the account. Without it orders
+	// are left to be paid by hand.
+	AutoPay bool
+	// MaxAutoPayPrice is the highest order total in cents that is paid automatically, orders
+	// above it are left to be paid by hand. It is required with AutoPay, OVHNoAutoPayLimit
+	// pays orders of any total.
+	MaxAutoPayPrice int64
+}
+
+// OVHNoAutoPayLimit is the MaxAutoPayPrice that pays orders of any total automatically
+const OVHNoAutoPayLimit int64 = -1
+
+// ovhError is the error body returned by the API
+type ovhError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *ovhError) Error() string {
+	return fmt.Sprintf("OVH API returned HTTP status %d: %s", e.StatusCode, e.Message)
+}
+
+// ovhPrice is a price as used throughout the order API
+type ovhPrice struct {
+	CurrencyCode string  `json:"currencyCode"`
+	Value        float64 `json:"value"`
+}
+
+func (p ovhPrice) cents() int64 {
+	return int64(math.Round(p.Value * 100))
+}
+
+// ovhOffer is an offer returned for a domain in a cart
+type ovhOffer struct {
+	Action    string   `json:"action"`
+	Orderable bool     `json:"orderable"`
+	Offer     string   `json:"offer"`
+	Phase     string   `json:"phase"`
+	Duration  []string `json:"duration"`
+	Prices    []struct {
+		Label string   `json:"label"`
+		Price ovhPrice `json:"price"`
+	} `json:"prices"`
+}
+
+type ovh struct {
+	cfg      OVHConfig
+	endpoint string
+	http     *http.Client
+
+	lock       sync.Mutex
+	timeDelta  time.Duration
+	timeSynced bool
+	cartID     string
+	cartExpire time.Time
+	orders     map[string]int64
+}
+
+// Name returns the name of this registrar
+func (o *ovh) Name() string {
+	return "ovh"
+}
+
+// CheckDomain checks whether the domain can be ordered
+func (o *ovh) CheckDomain(name string) (checker.Status, error) {
+	d, err := o.CheckDomainDetail(name)
+	return d.Status, err
+}
+
+// CheckDomainDetail asks for the offers of the domain in a cart, a domain is available when it
+// can be ordered for creation
+func (o *ovh) CheckDomainDetail(name string) (checker.Detail, error) {
+	cartID, err := o.availabilityCart()
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	var offers []ovhOffer
+	if err := o.call(http.MethodGet, "/order/cart/"+cartID+"/domain?domain="+url.QueryEscape(name), nil, &offers); err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+
+	d := checker.Detail{Status: checker.Unavailable}
+	for _, offer := range offers {
+		if d.Raw == "" {
+			d.Raw = fmt.Sprintf("action=%s orderable=%t", offer.Action, offer.Orderable)
+		}
+		if offer.Action != "create" || !offer.Orderable {
+			continue
+		}
+		d.Status = checker.Available
+		d.Raw = fmt.Sprintf("action=%s orderable=%t offer=%s phase=%s", offer.Action, offer.Orderable, offer.Offer, offer.Phase)
+		for _, p := range offer.Prices {
+			if p.Label == "TOTAL" {
+				d.Price = &checker.Price{Amount: p.Price.cents(), Currency: p.Price.CurrencyCode, Premium: offer.Offer == "premium"}
+			}
+		}
+		break
+	}
+	return d, nil
+}
+
+// RegisterDomain orders the domain through a new cart. The order is paid automatically when
+// that is allowed for its price, otherwise it waits to be paid by hand.
+func (o *ovh) RegisterDomain(name string) (checker.Status, error) {
+	cartID, _, err := o.newCart()
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	item := map[string]interface{}{"domain": name, "duration": o.cfg.Duration}
+	if err := o.call(http.MethodPost, "/order/cart/"+cartID+"/domain", item, nil); err != nil {
+		return checker.Unavailable, err
+	}
+	if err := o.call(http.MethodPost, "/order/cart/"+cartID+"/assign", nil, nil); err != nil {
+		return checker.Unavailable, err
+	}
+
+	var summary struct {
+		Prices struct {
+			WithTax ovhPrice `json:"withTax"`
+		} `json:"prices"`
+	}
+	if err := o.call(http.MethodGet, "/order/cart/"+cartID+"/checkout", nil, &summary); err != nil {
+		return checker.Unavailable, err
+	}
+	total := summary.Prices.WithTax
+	autoPay := o.cfg.AutoPay && (o.cfg.MaxAutoPayPrice == OVHNoAutoPayLimit || total.cents() <= o.cfg.MaxAutoPayPrice)
+	if o.cfg.AutoPay && !autoPay {
+		log.Printf("OVH order for '%s' totals %.2f %s which is above the auto-pay limit, it has to be paid by hand", name, total.Value, total.CurrencyCode)
+	}
+
+	var order struct {
+		OrderID int64  `json:"orderId"`
+		URL     string `json:"url"`
+	}
+	checkout := map[string]interface{}{"autoPayWithPreferredPaymentMethod": autoPay, "waiveRetractationPeriod": false}
+	if err := o.call(http.MethodPost, "/order/cart/"+cartID+"/checkout", checkout, &order); err != nil {
+		return checker.Unavailable, err
+	}
+	if !autoPay {
+		log.Printf("OVH order %d for '%s' awaits payment at %s", order.OrderID, name, order.URL)
+	}
+	o.lock.Lock()
+	o.orders[name] = order.OrderID
+	o.lock.Unlock()
+	return checker.Processing, nil
+}
+
+// RegistrationStatus follows the order of the domain. When the order is not known, like after
+// a restart, the domains in the account and the recent orders are consulted.
+func (o *ovh) RegistrationStatus(name string) (checker.Status, error) {
+	o.lock.Lock()
+	orderID, ok := o.orders[name]
+	o.lock.Unlock()
+	if !ok {
+		names, err := o.ListDomains()
+		if err != nil {
+			return checker.Unavailable, err
+		}
+		for _, n := range names {
+			if n == name {
+				return checker.Owned, nil
+			}
+		}
+		if orderID, err = o.findOrder(name); err != nil {
+			return checker.Unavailable, err
+		}
+		if orderID == 0 {
+			// the order may not be listed yet, the tracker gives up after its timeout
+			return checker.Processing, fmt.Errorf("no OVH order for '%s' is found and the domain is not in the account", name)
+		}
+		o.lock.Lock()
+		o.orders[name] = orderID
+		o.lock.Unlock()
+	}
+
+	var status string
+	if err := o.call(http.MethodGet, "/me/order/"+strconv.FormatInt(orderID, 10)+"/status", nil, &status); err != nil {
+		return checker.Unavailable, err
+	}
+	switch status {
+	case "delivered":
+		o.forget(name)
+		return checker.Owned, nil
+	case "cancelled", "cancelling":
+		o.forget(name)
+		return checker.Unavailable, fmt.Errorf("%w: OVH order %d is %s", checker.ErrRegistrationFailed, orderID, status)
+	}
+	return checker.Processing, nil
+}
+
+// findOrder returns the most recent order of the domain, zero when there is none
+func (o *ovh) findOrder(name string) (int64, error) {
+	var ids []int64
+	from := time.Now().Add(-ovhOrderLookback).Format("2006-01-02")
+	if err := o.call(http.MethodGet, "/me/order?date.from="+from, nil, &ids); err != nil {
+		return 0, fmt.Errorf("could not list OVH orders: %w", err)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	for _, id := range ids {
+		prefix := "/me/order/" + strconv.FormatInt(id, 10) + "/details"
+		var details []int64
+		if err := o.call(http.MethodGet, prefix, nil, &details); err != nil {
+			return 0, fmt.Errorf("could not read OVH order %d: %w", id, err)
+		}
+		for _, d := range details {
+			var detail struct {
+				Domain string `json:"domain"`
+			}
+			if err := o.call(http.MethodGet, prefix+"/"+strconv.FormatInt(d, 10), nil, &detail); err != nil {
+				return 0, fmt.Errorf("could not read OVH order %d: %w", id, err)
+			}
+			if detail.Domain == name {
+				return id, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (o *ovh) forget(name string) {
+	o.lock.Lock()
+	delete(o.orders, name)
+	o.lock.Unlock()
+}
+
+// ListDomains returns the names of all domains in the account
+func (o *ovh) ListDomains() ([]string, error) {
+	var names []string
+	if err := o.call(http.MethodGet, "/domain", nil, &names); err != nil {
+		return nil, fmt.Errorf("list domains returned an error: %w", err)
+	}
+	return names, nil
+}
+
+// availabilityCart returns the cart availability is checked in, it is replaced before it expires
+func (o *ovh) availabilityCart() (string, error) {
+	o.lock.Lock()
+	id, expire := o.cartID, o.cartExpire
+	o.lock.Unlock()
+	if id != "" && time.Now().Add(ovhCartMargin).Before(expire) {
+		return id, nil
+	}
+
+	id, expire, err := o.newCart()
+	if err != nil {
+		return "", err
+	}
+	o.lock.Lock()
+	o.cartID, o.cartExpire = id, expire
+	o.lock.Unlock()
+	return id, nil
+}
+
+func (o *ovh) newCart() (string, time.Time, error) {
+	var cart struct {
+		CartID string    `json:"cartId"`
+		Expire time.Time `json:"expire"`
+	}
+	req := map[string]string{"ovhSubsidiary": o.cfg.Subsidiary, "description": "domain-checker"}
+	if err := o.call(http.MethodPost, "/order/cart", req, &cart); err != nil {
+		return "", time.Time{}, fmt.Errorf("could not create OVH cart: %w", err)
+	}
+	return cart.CartID, cart.Expire, nil
+}
+
+// call performs a signed request and decodes the response into result, which may be nil when
+// the response is of no interest
+func (o *ovh) call(method, path string, body, result interface{}) error {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	delta, err := o.serverTimeDelta()
+	if err != nil {
+		return err
+	}
+
+	u := o.endpoint + path
+	req, err := http.NewRequest(method, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Add(delta).Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ovh-Application", o.cfg.ApplicationKey)
+	req.Header.Set("X-Ovh-Consumer", o.cfg.ConsumerKey)
+	req.Header.Set("X-Ovh-Timestamp", timestamp)
+	req.Header.Set("X-Ovh-Signature", ovhSign(o.cfg.ApplicationSecret, o.cfg.ConsumerKey, method, u, string(b), timestamp))
+
+	return o.do(req, result)
+}
+
+// serverTimeDelta returns the difference between the clock of the API and the local clock, the
+// signature is only valid when the timestamp matches the clock of the API
+func (o *ovh) serverTimeDelta() (time.Duration, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.timeSynced {
+		return o.timeDelta, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, o.endpoint+"/auth/time", nil)
+	if err != nil {
+		return 0, err
+	}
+	var serverTime int64
+	if err := o.do(req, &serverTime); err != nil {
+		return 0, fmt.Errorf("could not read OVH server time: %w", err)
+	}
+	o.timeDelta = time.Until(time.Unix(serverTime, 0)).Truncate(time.Second)
+	o.timeSynced = true
+	return o.timeDelta, nil
+}
+
+func (o *ovh) do(req *http.Request, result interface{}) error {
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to '%s' failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		e := &ovhError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(b, e); err != nil || e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	if result == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, result)
+}
+
+// ovhSign returns the signature of a request as expected in the X-Ovh-Signature header
+func ovhSign(secret, consumerKey, method, u, body, timestamp string) string {
+	h := sha1.Sum([]byte(strings.Join([]string{secret, consumerKey, method, u, body, timestamp}, "+")))
+	return fmt.Sprintf("$1$%x", h)
+}
+
+// NewOVH returns a registrar using the OVHcloud API
+func NewOVH(cfg OVHConfig) (checker.Registrar, error) {
+	if cfg.ApplicationKey == "" || cfg.ApplicationSecret == "" || cfg.ConsumerKey == "" {
+		return nil, errors.New("OVH application key, application secret and consumer key are required")
+	}
+	if cfg.AutoPay && cfg.MaxAutoPayPrice == 0 {
+		return nil, errors.New("OVH auto-pay requires a maximum price, use OVHNoAutoPayLimit to pay any total")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "ovh-eu"
+	}
+	endpoint, ok := ovhEndpoints[cfg.Endpoint]
+	if !ok {
+		if _, err := url.ParseRequestURI(cfg.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid endpoint '%s': %w", cfg.Endpoint, err)
+		}
+		endpoint = cfg.Endpoint
+	}
+	if cfg.Subsidiary == "" {
+		cfg.Subsidiary = "FR"
+	}
+	if cfg.Duration == "" {
+		cfg.Duration = "P1Y"
+	}
+	return &ovh{
+		cfg:      cfg,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		http:     &http.Client{Timeout: 30 * time.Second},
+		orders:   make(map[string]int64),
+	}, nil
+}
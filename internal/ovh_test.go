@@ -0,0 +1,379 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+// ovhStandInSkew is how far the clock of the stand-in runs ahead, requests are only accepted
+// when their timestamp is synchronized with it
+const ovhStandInSkew = time.Hour
+
+type ovhStandInOrder struct {
+	domain string
+	paid   bool
+	status string
+}
+
+// ovhStandIn is a local OVHcloud API verifying request signatures. Domains cost 7.79 EUR, those
+// starting with "premium" are premium offers of 1500 EUR.
+type ovhStandIn struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	taken   map[string]bool
+	account map[string]bool
+	carts   map[string][]string
+	orders  map[int64]*ovhStandInOrder
+	times   int
+}
+
+func newOVHStandIn() *ovhStandIn {
+	s := &ovhStandIn{
+		taken:   make(map[string]bool),
+		account: make(map[string]bool),
+		carts:   make(map[string][]string),
+		orders:  make(map[int64]*ovhStandInOrder),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *ovhStandIn) config() OVHConfig {
+	return OVHConfig{
+		Endpoint:          s.server.URL + "/1.0",
+		ApplicationKey:    "app-key",
+		ApplicationSecret: "app-secret",
+		ConsumerKey:       "consumer-key",
+	}
+}
+
+// deliver completes all paid orders
+func (s *ovhStandIn) deliver() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.orders {
+		if o.paid {
+			o.status = "delivered"
+			s.account[o.domain] = true
+		}
+	}
+}
+
+func (s *ovhStandIn) price(name string) (float64, string) {
+	if strings.HasPrefix(name, "premium") {
+		return 1500, "premium"
+	}
+	return 7.79, "gold"
+}
+
+func (s *ovhStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/1.0")
+	now := time.Now().Add(ovhStandInSkew)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if path == "/auth/time" {
+		s.times++
+		writeStandInJSON(w, http.StatusOK, now.Unix())
+		return
+	}
+	if err := s.verify(r, now); err != nil {
+		writeStandInJSON(w, http.StatusForbidden, map[string]string{"message": err.Error()})
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && path == "/order/cart":
+		id := fmt.Sprintf("cart-%d", len(s.carts)+1)
+		s.carts[id] = nil
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{"cartId": id, "expire": now.Add(24 * time.Hour).Format(time.RFC3339)})
+	case len(parts) >= 3 && parts[0] == "order" && parts[1] == "cart":
+		s.cart(w, r, parts[2], strings.Join(parts[3:], "/"))
+	case r.Method == http.MethodGet && path == "/me/order":
+		ids := []int64{}
+		for id := range s.orders {
+			ids = append(ids, id)
+		}
+		writeStandInJSON(w, http.StatusOK, ids)
+	case r.Method == http.MethodGet && len(parts) >= 4 && parts[0] == "me" && parts[1] == "order" && parts[3] == "details":
+		// every order holds a single detail with the id of the order
+		id, _ := strconv.ParseInt(parts[2], 10, 64)
+		o, ok := s.orders[id]
+		switch {
+		case !ok:
+			writeStandInJSON(w, http.StatusNotFound, map[string]string{"message": "This order does not exist"})
+		case len(parts) == 4:
+			writeStandInJSON(w, http.StatusOK, []int64{id})
+		default:
+			writeStandInJSON(w, http.StatusOK, map[string]interface{}{"orderDetailId": id, "domain": o.domain, "detailType": "DURATION"})
+		}
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[0] == "me" && parts[1] == "order" && parts[3] == "status":
+		id, _ := strconv.ParseInt(parts[2], 10, 64)
+		o, ok := s.orders[id]
+		if !ok {
+			writeStandInJSON(w, http.StatusNotFound, map[string]string{"message": "This order does not exist"})
+			return
+		}
+		writeStandInJSON(w, http.StatusOK, o.status)
+	case r.Method == http.MethodGet && path == "/domain":
+		names := []string{}
+		for n := range s.account {
+			names = append(names, n)
+		}
+		writeStandInJSON(w, http.StatusOK, names)
+	default:
+		writeStandInJSON(w, http.StatusNotFound, map[string]string{"message": "Got an invalid (or empty) URL"})
+	}
+}
+
+func (s *ovhStandIn) cart(w http.ResponseWriter, r *http.Request, id, action string) {
+	items, ok := s.carts[id]
+	if !ok {
+		writeStandInJSON(w, http.StatusNotFound, map[string]string{"message": "Cart " + id + " does not exist"})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && action == "domain":
+		name := r.URL.Query().Get("domain")
+		if strings.HasSuffix(name, ".invalid") {
+			writeStandInJSON(w, http.StatusBadRequest, map[string]string{"message": "Domain extension is not supported"})
+			return
+		}
+		value, offer := s.price(name)
+		a := "create"
+		if s.taken[name] || s.account[name] {
+			a = "transfer"
+		}
+		writeStandInJSON(w, http.StatusOK, []map[string]interface{}{{
+			"action": a, "orderable": true, "offer": offer, "phase": "ga", "duration": []string{"P1Y"},
+			"prices": []map[string]interface{}{
+				{"label": "PRICE", "price": map[string]interface{}{"currencyCode": "EUR", "value": value}},
+				{"label": "TOTAL", "price": map[string]interface{}{"currencyCode": "EUR", "value": value}},
+			},
+		}})
+	case r.Method == http.MethodPost && action == "domain":
+		var req struct {
+			Domain   string `json:"domain"`
+			Duration string `json:"duration"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if s.taken[req.Domain] || s.account[req.Domain] || strings.HasSuffix(req.Domain, ".invalid") || req.Duration != "P1Y" {
+			writeStandInJSON(w, http.StatusBadRequest, map[string]string{"message": "Domain " + req.Domain + " can not be ordered"})
+			return
+		}
+		s.carts[id] = append(items, req.Domain)
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{"itemId": len(items) + 1})
+	case r.Method == http.MethodPost && action == "assign":
+		w.WriteHeader(http.StatusOK)
+	case action == "checkout":
+		var total float64
+		for _, n := range items {
+			v, _ := s.price(n)
+			total += v
+		}
+		if r.Method == http.MethodGet {
+			writeStandInJSON(w, http.StatusOK, map[string]interface{}{
+				"prices": map[string]interface{}{"withTax": map[string]interface{}{"currencyCode": "EUR", "value": total}},
+			})
+			return
+		}
+		var req struct {
+			AutoPay bool `json:"autoPayWithPreferredPaymentMethod"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		orderID := int64(1000 + len(s.orders))
+		status := "notPaid"
+		if req.AutoPay {
+			status = "delivering"
+		}
+		for _, n := range items {
+			s.orders[orderID] = &ovhStandInOrder{domain: n, paid: req.AutoPay, status: status}
+		}
+		delete(s.carts, id)
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{"orderId": orderID, "url": "https://www.ovh.com/cgi-bin/order/display-order.cgi?orderId=" + strconv.FormatInt(orderID, 10)})
+	default:
+		writeStandInJSON(w, http.StatusNotFound, map[string]string{"message": "Got an invalid (or empty) URL"})
+	}
+}
+
+// verify checks the application, consumer key and signature of a request
+func (s *ovhStandIn) verify(r *http.Request, now time.Time) error {
+	if r.Header.Get("X-Ovh-Application") != "app-key" {
+		return fmt.Errorf("This application key is invalid")
+	}
+	if r.Header.Get("X-Ovh-Consumer") != "consumer-key" {
+		return fmt.Errorf("This credential does not exist")
+	}
+	ts, err := strconv.ParseInt(r.Header.Get("X-Ovh-Timestamp"), 10, 64)
+	if err != nil || ts < now.Unix()-30 || ts > now.Unix()+30 {
+		return fmt.Errorf("Invalid timestamp")
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	u := "http://" + r.Host + r.URL.RequestURI()
+	h := sha1.Sum([]byte("app-secret+consumer-key+" + r.Method + "+" + u + "+" + string(body) + "+" + r.Header.Get("X-Ovh-Timestamp")))
+	if r.Header.Get("X-Ovh-Signature") != fmt.Sprintf("$1$%x", h) {
+		return fmt.Errorf("Invalid signature")
+	}
+	return nil
+}
+
+func newStandInOVH(t *testing.T, cfg OVHConfig) checker.Registrar {
+	t.Helper()
+	o, err := NewOVH(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOVHConformance(t *testing.T) {
+	s := newOVHStandIn()
+	defer s.server.Close()
+
+	checkertest.RunConformance(t, func() checker.Registrar {
+		s.mu.Lock()
+		s.taken = map[string]bool{"taken.fr": true}
+		s.account = make(map[string]bool)
+		s.mu.Unlock()
+		return newStandInOVH(t, s.config())
+	},
+		checkertest.Fixture{Domain: "free.fr", Status: checker.Available, Register: checker.Processing},
+		checkertest.Fixture{Domain: "taken.fr", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "premium.fr", Status: checker.Available, Register: checker.Processing},
+		checkertest.Fixture{Domain: "unsupported.invalid", Err: true},
+	)
+}
+
+func TestOVHCheckDomainDetail(t *testing.T) {
+	s := newOVHStandIn()
+	defer s.server.Close()
+	o := newStandInOVH(t, s.config()).(checker.DetailedChecker)
+
+	d, err := o.CheckDomainDetail("premium.fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Price == nil || d.Price.Amount != 150000 || d.Price.Currency != "EUR" || !d.Price.Premium {
+		t.Errorf("Expected the premium price, got %+v", d.Price)
+	}
+	if _, err := o.CheckDomainDetail("free.fr"); err != nil {
+		t.Fatal(err)
+	}
+	if s.times != 1 || len(s.carts) != 1 {
+		t.Errorf("Expected the server time and cart to be reused, got %d time syncs and %d carts", s.times, len(s.carts))
+	}
+}
+
+func TestOVHRegisterDomain(t *testing.T) {
+	s := newOVHStandIn()
+	defer s.server.Close()
+	cfg := s.config()
+	cfg.AutoPay = true
+	cfg.MaxAutoPayPrice = 2000
+	o := newStandInOVH(t, cfg)
+	sr := o.(checker.RegistrationStatusReader)
+
+	for _, n := range []string{"free.fr", "premium.fr"} {
+		if st, err := o.RegisterDomain(n); err != nil || st != checker.Processing {
+			t.Fatalf("Expected %s to be Processing, got %d and '%v'", n, st, err)
+		}
+	}
+	for _, order := range s.orders {
+		if order.paid != (order.domain == "free.fr") {
+			t.Errorf("Expected only orders below the auto-pay limit to be paid, %s paid: %t", order.domain, order.paid)
+		}
+	}
+
+	s.deliver()
+	if st, err := sr.RegistrationStatus("free.fr"); err != nil || st != checker.Owned {
+		t.Errorf("Expected the paid order to be Owned once delivered, got %d and '%v'", st, err)
+	}
+	if st, err := sr.RegistrationStatus("premium.fr"); err != nil || st != checker.Processing {
+		t.Errorf("Expected the unpaid order to be Processing, got %d and '%v'", st, err)
+	}
+	// a restarted daemon does not know the order anymore and falls back on the account and
+	// the orders
+	restarted := newStandInOVH(t, cfg).(checker.RegistrationStatusReader)
+	if st, err := restarted.RegistrationStatus("free.fr"); err != nil || st != checker.Owned {
+		t.Errorf("Expected a domain in the account to be Owned, got %d and '%v'", st, err)
+	}
+	if st, err := restarted.RegistrationStatus("premium.fr"); err != nil || st != checker.Processing {
+		t.Errorf("Expected the unpaid order to be found after a restart, got %d and '%v'", st, err)
+	}
+	if _, err := restarted.RegistrationStatus("unknown.fr"); err == nil || errors.Is(err, checker.ErrRegistrationFailed) {
+		t.Errorf("Expected an unknown order not to fail the registration, got '%v'", err)
+	}
+	if names, err := o.(checker.DomainLister).ListDomains(); err != nil || len(names) != 1 || names[0] != "free.fr" {
+		t.Errorf("Expected the delivered domain to be listed, got %v and '%v'", names, err)
+	}
+}
+
+func TestOVHUnlimitedAutoPay(t *testing.T) {
+	s := newOVHStandIn()
+	defer s.server.Close()
+	cfg := s.config()
+	cfg.AutoPay = true
+	cfg.MaxAutoPayPrice = OVHNoAutoPayLimit
+	o := newStandInOVH(t, cfg)
+
+	if _, err := o.RegisterDomain("premium.fr"); err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range s.orders {
+		if !order.paid {
+			t.Error("Expected orders of any total to be paid without a limit")
+		}
+	}
+}
+
+func TestOVHWithoutAutoPay(t *testing.T) {
+	s := newOVHStandIn()
+	defer s.server.Close()
+	o := newStandInOVH(t, s.config())
+
+	if _, err := o.RegisterDomain("free.fr"); err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range s.orders {
+		if order.paid {
+			t.Error("Expected orders to be left unpaid without auto-pay")
+		}
+	}
+}
+
+func TestOVHInvalidCredentials(t *testing.T) {
+	s := newOVHStandIn()
+	defer s.server.Close()
+	cfg := s.config()
+	cfg.ApplicationSecret = "wrong"
+
+	if _, err := newStandInOVH(t, cfg).CheckDomain("free.fr"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a rejected signature, got '%v'", err)
+	}
+	if _, err := NewOVH(OVHConfig{ApplicationKey: "app-key"}); err == nil {
+		t.Error("Expected an error without secret and consumer key")
+	}
+	if _, err := NewOVH(OVHConfig{ApplicationKey: "a", ApplicationSecret: "b", ConsumerKey: "c", AutoPay: true}); err == nil {
+		t.Error("Expected an error for auto-pay without a maximum price")
+	}
+	if o, err := NewOVH(OVHConfig{Endpoint: "ovh-ca", ApplicationKey: "a", ApplicationSecret: "b", ConsumerKey: "c"}); err != nil || o.(*ovh).endpoint != ovhEndpoints["ovh-ca"] {
+		t.Errorf("Expected the endpoint of the region, got '%v'", err)
+	}
+}
@@ -0,0 +1,353 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+// Plugin methods and capabilities
+const (
+	PluginCapabilities = "capabilities"
+	PluginCheck        = "check"
+	PluginRegister     = "register"
+)
+
+const (
+	// pluginMaxLine limits the size of a single response from a plugin
+	pluginMaxLine = 1 << 20
+	// pluginGrace is the time a plugin gets to exit after its stdin is closed
+	pluginGrace = 2 * time.Second
+)
+
+// PluginRequest is written by the daemon to the stdin of a plugin as a single line of JSON.
+//
+// A plugin is an executable that reads requests from stdin and writes a PluginResponse with the
+// same ID to stdout for every request, one per line and in order. The first request is always
+// a capabilities request. Check and register requests carry the domain and are answered with a
+// status: available, unavailable, taken, transferable, owned or processing. Anything written to
+// stderr ends up in the logs of the daemon. A plugin that exits is started again on the next
+// request.
+//
+//	> {"id":1,"method":"capabilities"}
+//	< {"id":1,"name":"smallreg","capabilities":["check","register"]}
+//	> {"id":2,"method":"check","domain":"example.org"}
+//	< {"id":2,"status":"available"}
+//	> {"id":3,"method":"register","domain":"example.org"}
+//	< {"id":3,"error":"insufficient funds"}
+type PluginRequest struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// PluginResponse is written by a plugin to its stdout as a single line of JSON
+type PluginResponse struct {
+	ID uint64 `json:"id"`
+	// Error fails the request, the status is ignored then
+	Error string `json:"error,omitempty"`
+	// Status answers check and register requests
+	Status string `json:"status,omitempty"`
+	// Raw is optional information about the status, like the registry response
+	Raw string `json:"raw,omitempty"`
+	// Name and Capabilities answer capabilities requests
+	Name         string   `json:"name,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// PluginConfig holds the settings for an external registrar plugin
+type PluginConfig struct {
+	// Command is the executable of the plugin, Args are passed to it
+	Command string
+	Args    []string
+	// Env is added to the environment of the daemon for the plugin, as KEY=value
+	Env []string
+	// Timeout limits the duration of a single request, defaults to 30 seconds. A plugin that
+	// does not answer in time is stopped and started again on the next request.
+	Timeout time.Duration
+}
+
+// pluginProcess is a single run of the plugin
+type pluginProcess struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan PluginResponse
+	// stopped is closed when the daemon stops the process, exited when the process has exited
+	stopped chan struct{}
+	exited  chan struct{}
+}
+
+type plugin struct {
+	cfg PluginConfig
+
+	// mu is held for the duration of a request
+	mu       sync.Mutex
+	proc     *pluginProcess
+	id       uint64
+	starts   int
+	register bool
+
+	// nameMu guards the name separately so it can be read during a request
+	nameMu sync.RWMutex
+	name   string
+}
+
+// Name returns the name the plugin reported
+func (p *plugin) Name() string {
+	p.nameMu.RLock()
+	defer p.nameMu.RUnlock()
+	return p.name
+}
+
+// CheckDomain asks the plugin to check the domain
+func (p *plugin) CheckDomain(name string) (checker.Status, error) {
+	d, err := p.CheckDomainDetail(name)
+	return d.Status, err
+}
+
+// CheckDomainDetail asks the plugin to check the domain, the raw value is passed on from the plugin
+func (p *plugin) CheckDomainDetail(name string) (checker.Detail, error) {
+	resp, err := p.call(PluginRequest{Method: PluginCheck, Domain: name})
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	s, err := p.status(resp)
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	return checker.Detail{Status: s, Raw: resp.Raw}, nil
+}
+
+// RegisterDomain asks the plugin to register the domain
+func (p *plugin) RegisterDomain(name string) (checker.Status, error) {
+	p.mu.Lock()
+	register := p.register
+	p.mu.Unlock()
+	if !register {
+		return checker.Unavailable, fmt.Errorf("plugin %s can not register '%s': %w", p.Name(), name, checker.ErrReadOnly)
+	}
+	resp, err := p.call(PluginRequest{Method: PluginRegister, Domain: name})
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	s, err := p.status(resp)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	if s == checker.Available {
+		return checker.Unavailable, fmt.Errorf("plugin %s reported '%s' available after registering it", p.Name(), name)
+	}
+	return s, nil
+}
+
+// Close stops the plugin
+func (p *plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.proc == nil {
+		return nil
+	}
+	p.stop(p.proc)
+	return nil
+}
+
+func (p *plugin) status(resp PluginResponse) (checker.Status, error) {
+	s, ok := statusNames[resp.Status]
+	if !ok {
+		return checker.Unavailable, fmt.Errorf("plugin %s answered with unknown status %q", p.Name(), resp.Status)
+	}
+	return s, nil
+}
+
+// call sends the request to the plugin, starting it when it is not running, and waits for the
+// response. Requests are handled one at a time.
+func (p *plugin) call(req PluginRequest) (PluginResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.proc != nil {
+		select {
+		case <-p.proc.exited:
+			// the plugin crashed since the last request
+			p.stop(p.proc)
+		default:
+		}
+	}
+	if p.proc == nil {
+		if err := p.start(); err != nil {
+			return PluginResponse{}, err
+		}
+	}
+	return p.send(req)
+}
+
+// send writes the request and waits for its response, the process is stopped when it fails
+func (p *plugin) send(req PluginRequest) (PluginResponse, error) {
+	p.id++
+	req.ID = p.id
+	proc := p.proc
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return PluginResponse{}, err
+	}
+	if _, err := proc.stdin.Write(append(b, '\n')); err != nil {
+		p.stop(proc)
+		return PluginResponse{}, fmt.Errorf("plugin %s: writing %s request: %w", p.Name(), req.Method, err)
+	}
+
+	timeout := time.NewTimer(p.cfg.Timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case resp, ok := <-proc.responses:
+			if !ok {
+				p.stop(proc)
+				return PluginResponse{}, fmt.Errorf("plugin %s exited during %s request", p.Name(), req.Method)
+			}
+			if resp.ID != req.ID {
+				log.Printf("plugin %s: ignoring response to request %d", p.Name(), resp.ID)
+				continue
+			}
+			if resp.Error != "" {
+				return PluginResponse{}, fmt.Errorf("plugin %s: %s", p.Name(), resp.Error)
+			}
+			return resp, nil
+		case <-timeout.C:
+			p.stop(proc)
+			return PluginResponse{}, fmt.Errorf("plugin %s did not answer %s request within %s", p.Name(), req.Method, p.cfg.Timeout)
+		}
+	}
+}
+
+// start runs the plugin and asks for its capabilities
+func (p *plugin) start() error {
+	cmd := exec.Command(p.cfg.Command, p.cfg.Args...)
+	cmd.Env = append(os.Environ(), p.cfg.Env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting plugin %s: %w", p.Name(), err)
+	}
+	p.starts++
+	if p.starts > 1 {
+		log.Printf("plugin %s: restarted (start %d)", p.Name(), p.starts)
+	}
+
+	proc := &pluginProcess{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan PluginResponse),
+		stopped:   make(chan struct{}),
+		exited:    make(chan struct{}),
+	}
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			log.Printf("plugin %s: %s", p.Name(), s.Text())
+		}
+	}()
+	go func() {
+		defer output.Done()
+		defer close(proc.responses)
+		s := bufio.NewScanner(stdout)
+		s.Buffer(make([]byte, 4096), pluginMaxLine)
+		for s.Scan() {
+			var resp PluginResponse
+			if err := json.Unmarshal(s.Bytes(), &resp); err != nil {
+				log.Printf("plugin %s: invalid response %q: %v", p.Name(), s.Text(), err)
+				continue
+			}
+			select {
+			case proc.responses <- resp:
+			case <-proc.stopped:
+				return
+			}
+		}
+	}()
+	go func() {
+		// the pipes have to be drained before waiting for the process
+		output.Wait()
+		if err := cmd.Wait(); err != nil {
+			log.Printf("plugin %s: %v", p.Name(), err)
+		}
+		close(proc.exited)
+	}()
+	p.proc = proc
+
+	resp, err := p.send(PluginRequest{Method: PluginCapabilities})
+	if err != nil {
+		return err
+	}
+	if resp.Name != "" {
+		p.nameMu.Lock()
+		p.name = resp.Name
+		p.nameMu.Unlock()
+	}
+	p.register = false
+	for _, c := range resp.Capabilities {
+		if c == PluginRegister {
+			p.register = true
+		}
+	}
+	return nil
+}
+
+// stop ends the process, closing stdin first so a well behaved plugin ends by itself
+func (p *plugin) stop(proc *pluginProcess) {
+	if p.proc == proc {
+		p.proc = nil
+	}
+	select {
+	case <-proc.stopped:
+		return
+	default:
+	}
+	close(proc.stopped)
+	proc.stdin.Close()
+	select {
+	case <-proc.exited:
+	case <-time.After(pluginGrace):
+		proc.cmd.Process.Kill()
+	}
+}
+
+// NewPlugin returns a registrar backed by an external plugin process. The plugin is started
+// right away to learn its name and capabilities.
+func NewPlugin(cfg PluginConfig) (checker.Registrar, error) {
+	if cfg.Command == "" {
+		return nil, errors.New("plugin requires a command")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	p := &plugin{cfg: cfg, name: filepath.Base(cfg.Command)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
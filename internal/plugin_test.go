@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+// TestPluginHelperProcess is not a real test, it is the plugin started by the other plugin
+// tests. Domains starting with "taken" are unavailable, "crash" domains make the plugin exit,
+// "hang" domains are never answered and "broken" domains fail. Registered domains are owned,
+// registering a "taken" domain fails.
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_PLUGIN_HELPER") != "1" {
+		return
+	}
+	registered := make(map[string]bool)
+	out := json.NewEncoder(os.Stdout)
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		var req PluginRequest
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid request: %v\n", err)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s\n", req.Method, req.Domain)
+		resp := PluginResponse{ID: req.ID}
+		switch {
+		case req.Method == PluginCapabilities:
+			resp.Name = "helper"
+			resp.Capabilities = []string{PluginCheck}
+			if os.Getenv("PLUGIN_HELPER_READ_ONLY") != "1" {
+				resp.Capabilities = append(resp.Capabilities, PluginRegister)
+			}
+		case strings.HasPrefix(req.Domain, "crash"):
+			os.Exit(3)
+		case strings.HasPrefix(req.Domain, "hang"):
+			continue
+		case strings.HasPrefix(req.Domain, "broken"):
+			resp.Error = "registry unreachable"
+		case req.Method == PluginRegister && strings.HasPrefix(req.Domain, "taken"):
+			resp.Error = "domain is not available"
+		case req.Method == PluginRegister:
+			registered[req.Domain] = true
+			resp.Status = "owned"
+		case registered[req.Domain]:
+			resp.Status = "owned"
+		case strings.HasPrefix(req.Domain, "taken"):
+			resp.Status, resp.Raw = "unavailable", "registered elsewhere"
+		default:
+			resp.Status = "available"
+		}
+		out.Encode(resp)
+	}
+	os.Exit(0)
+}
+
+func helperPluginConfig() PluginConfig {
+	return PluginConfig{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestPluginHelperProcess"},
+		Env:     []string{"GO_WANT_PLUGIN_HELPER=1"},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func newHelperPlugin(t *testing.T, cfg PluginConfig) checker.Registrar {
+	t.Helper()
+	p, err := NewPlugin(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// lockedBuffer collects log output written by multiple goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPluginConformance(t *testing.T) {
+	var plugins []checker.Registrar
+	defer func() {
+		for _, p := range plugins {
+			p.(io.Closer).Close()
+		}
+	}()
+
+	checkertest.RunConformance(t, func() checker.Registrar {
+		p := newHelperPlugin(t, helperPluginConfig())
+		plugins = append(plugins, p)
+		return p
+	},
+		checkertest.Fixture{Domain: "free.test", Status: checker.Available, Register: checker.Owned},
+		checkertest.Fixture{Domain: "taken.test", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "broken.test", Err: true},
+	)
+}
+
+func TestPluginRegisterDomain(t *testing.T) {
+	p := newHelperPlugin(t, helperPluginConfig())
+	defer p.(io.Closer).Close()
+
+	if n := p.(checker.Named).Name(); n != "helper" {
+		t.Errorf("Expected the name reported by the plugin, got '%s'", n)
+	}
+	if s, err := p.RegisterDomain("free.test"); err != nil || s != checker.Owned {
+		t.Errorf("Expected free.test to be Owned, got %d and '%v'", s, err)
+	}
+	if s, err := p.CheckDomain("free.test"); err != nil || s != checker.Owned {
+		t.Errorf("Expected a registered domain to be Owned, got %d and '%v'", s, err)
+	}
+	if d, err := p.(checker.DetailedChecker).CheckDomainDetail("taken.test"); err != nil || d.Raw != "registered elsewhere" {
+		t.Errorf("Expected the raw value from the plugin, got '%s' and '%v'", d.Raw, err)
+	}
+
+	cfg := helperPluginConfig()
+	cfg.Env = append(cfg.Env, "PLUGIN_HELPER_READ_ONLY=1")
+	ro := newHelperPlugin(t, cfg)
+	defer ro.(io.Closer).Close()
+	if _, err := ro.RegisterDomain("free.test"); !errors.Is(err, checker.ErrReadOnly) {
+		t.Errorf("Expected a plugin without register capability to be read-only, got '%v'", err)
+	}
+}
+
+func TestPluginRestart(t *testing.T) {
+	logs := &lockedBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := helperPluginConfig()
+	cfg.Timeout = 500 * time.Millisecond
+	p := newHelperPlugin(t, cfg)
+	defer p.(io.Closer).Close()
+
+	if _, err := p.RegisterDomain("free.test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CheckDomain("crash.test"); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Errorf("Expected a crash to fail the request, got '%v'", err)
+	}
+	// the restarted plugin lost its state
+	if s, err := p.CheckDomain("free.test"); err != nil || s != checker.Available {
+		t.Errorf("Expected the plugin to be restarted, got %d and '%v'", s, err)
+	}
+	if _, err := p.CheckDomain("hang.test"); err == nil || !strings.Contains(err.Error(), "did not answer") {
+		t.Errorf("Expected a timeout, got '%v'", err)
+	}
+	if s, err := p.CheckDomain("taken.test"); err != nil || s != checker.Unavailable {
+		t.Errorf("Expected the plugin to be restarted after the timeout, got %d and '%v'", s, err)
+	}
+
+	out := logs.String()
+	for _, want := range []string{"plugin helper: check crash.test", "plugin helper: restarted (start 3)", "exit status 3"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected '%s' in the logs, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestPluginInvalidCommand(t *testing.T) {
+	if _, err := NewPlugin(PluginConfig{}); err == nil {
+		t.Error("Expected an error without command")
+	}
+	if _, err := NewPlugin(PluginConfig{Command: "testdata/missing-plugin"}); err == nil {
+		t.Error("Expected an error for a missing command")
+	}
+}
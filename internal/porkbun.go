@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+const porkbunEndpoint = "https://api.porkbun.com/api/json/v3/"
+
+// PorkbunConfig holds the settings for a Porkbun registrar
+type PorkbunConfig struct {
+	// APIKey and SecretAPIKey are created in the Porkbun account, API access has to be enabled
+	// per domain and for the account
+	APIKey       string
+	SecretAPIKey string
+	// Endpoint overrides the production API
+	Endpoint string
+	// AllowPremium allows the registration of premium domains at their premium price
+	AllowPremium bool
+}
+
+// porkbunCheck is the availability of a domain as reported by Porkbun
+type porkbunCheck struct {
+	Avail   string `json:"avail"`
+	Price   string `json:"price"`
+	Premium string `json:"premium"`
+}
+
+type porkbun struct {
+	cfg      PorkbunConfig
+	endpoint *url.URL
+	http     *http.Client
+}
+
+// Name returns the name of this registrar
+func (p *porkbun) Name() string {
+	return "porkbun"
+}
+
+// CheckDomain checks the availability of the domain
+func (p *porkbun) CheckDomain(name string) (checker.Status, error) {
+	d, err := p.CheckDomainDetail(name)
+	return d.Status, err
+}
+
+// CheckDomainDetail checks the availability of the domain together with its first year price
+func (p *porkbun) CheckDomainDetail(name string) (checker.Detail, error) {
+	c, err := p.check(name)
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	d := checker.Detail{Status: checker.Unavailable, Raw: fmt.Sprintf("avail=%s premium=%s", c.Avail, c.Premium)}
+	if c.Avail == "yes" {
+		d.Status = checker.Available
+	}
+	if c.Price != "" {
+		amount, err := parseCents(c.Price)
+		if err != nil {
+			return checker.Detail{Status: checker.Unavailable}, err
+		}
+		d.Price = &checker.Price{Amount: amount, Currency: "USD", Premium: c.Premium == "yes"}
+	}
+	return d, nil
+}
+
+// RegisterDomain creates the domain. Porkbun requires the price to be confirmed, so the domain is
+// checked first. Premium domains are only registered when that is allowed.
+func (p *porkbun) RegisterDomain(name string) (checker.Status, error) {
+	c, err := p.check(name)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	if c.Avail != "yes" {
+		return checker.Unavailable, fmt.Errorf("domain '%s' is not available at Porkbun", name)
+	}
+	if c.Premium == "yes" && !p.cfg.AllowPremium {
+		return checker.Unavailable, fmt.Errorf("domain '%s' is a premium domain priced at %s USD, premium registrations are not allowed", name, c.Price)
+	}
+	cost, err := parseCents(c.Price)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+
+	req := map[string]interface{}{"cost": cost, "agreeToTerms": "yes"}
+	if err := p.call("domain/create/"+url.PathEscape(name), req, nil); err != nil {
+		return checker.Unavailable, err
+	}
+	return checker.Owned, nil
+}
+
+func (p *porkbun) check(name string) (*porkbunCheck, error) {
+	var resp struct {
+		Response porkbunCheck `json:"response"`
+	}
+	if err := p.call("domain/checkDomain/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Response, nil
+}
+
+// call posts the request with the credentials added and decodes the response into result,
+// which may be nil when the response is of no interest
+func (p *porkbun) call(path string, body map[string]interface{}, result interface{}) error {
+	u, err := p.endpoint.Parse(path)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		body = make(map[string]interface{})
+	}
+	body["apikey"] = p.cfg.APIKey
+	body["secretapikey"] = p.cfg.SecretAPIKey
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.http.Post(u.String(), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("request to '%s' failed: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	if b, err = ioutil.ReadAll(resp.Body); err != nil {
+		return err
+	}
+
+	var status struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &status); err != nil {
+		return fmt.Errorf("invalid Porkbun response with HTTP status %d: %w", resp.StatusCode, err)
+	}
+	if status.Status != "SUCCESS" {
+		return fmt.Errorf("Porkbun returned HTTP status %d: %s", resp.StatusCode, status.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(b, result)
+}
+
+// NewPorkbun returns a registrar using the Porkbun JSON API
+func NewPorkbun(cfg PorkbunConfig) (checker.Registrar, error) {
+	if cfg.APIKey == "" || cfg.SecretAPIKey == "" {
+		return nil, errors.New("Porkbun API key and secret API key are required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = porkbunEndpoint
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint '%s': %w", cfg.Endpoint, err)
+	}
+	if u.Path == "" || u.Path[len(u.Path)-1] != '/' {
+		u.Path += "/"
+	}
+	return &porkbun{cfg: cfg, endpoint: u, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
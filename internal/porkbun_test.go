@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+// porkbunStandIn is a local Porkbun API. Domains are available at $9.68 unless they are
+// registered, domains starting with "premium" cost $2500.
+type porkbunStandIn struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	registered map[string]bool
+	costs      []float64
+}
+
+func newPorkbunStandIn() *porkbunStandIn {
+	s := &porkbunStandIn{registered: make(map[string]bool)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *porkbunStandIn) config() PorkbunConfig {
+	return PorkbunConfig{APIKey: "pk1_standin", SecretAPIKey: "sk1_secret", Endpoint: s.server.URL + "/api/json/v3"}
+}
+
+func (s *porkbunStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		APIKey       string  `json:"apikey"`
+		SecretAPIKey string  `json:"secretapikey"`
+		Cost         float64 `json:"cost"`
+		AgreeToTerms string  `json:"agreeToTerms"`
+	}
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+		writeStandInJSON(w, http.StatusBadRequest, map[string]string{"status": "ERROR", "message": "Invalid request"})
+		return
+	}
+	if req.APIKey != "pk1_standin" || req.SecretAPIKey != "sk1_secret" {
+		writeStandInJSON(w, http.StatusForbidden, map[string]string{"status": "ERROR", "message": "Invalid API key. (002)"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/json/v3/"), "/")
+	if len(parts) != 3 || parts[0] != "domain" {
+		writeStandInJSON(w, http.StatusNotFound, map[string]string{"status": "ERROR", "message": "Invalid endpoint"})
+		return
+	}
+	name := parts[2]
+	if strings.HasSuffix(name, ".invalid") {
+		writeStandInJSON(w, http.StatusBadRequest, map[string]string{"status": "ERROR", "message": "The TLD of the domain is not supported."})
+		return
+	}
+	pennies, premium := 968, "no"
+	if strings.HasPrefix(name, "premium") {
+		pennies, premium = 250000, "yes"
+	}
+	price := fmt.Sprintf("%d.%02d", pennies/100, pennies%100)
+
+	switch parts[1] {
+	case "checkDomain":
+		avail := "yes"
+		if s.registered[name] {
+			avail = "no"
+		}
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "SUCCESS",
+			"response": map[string]interface{}{
+				"avail": avail, "type": "registration", "price": price, "regularPrice": price,
+				"firstYearPromo": "no", "premium": premium,
+			},
+			"limits": map[string]interface{}{"TTL": "10", "limit": "1", "used": 1},
+		})
+	case "create":
+		if s.registered[name] {
+			writeStandInJSON(w, http.StatusBadRequest, map[string]string{"status": "ERROR", "message": "Domain is not available."})
+			return
+		}
+		if req.AgreeToTerms != "yes" || req.Cost != float64(pennies) {
+			writeStandInJSON(w, http.StatusBadRequest, map[string]string{"status": "ERROR", "message": "The cost does not match the price of the domain."})
+			return
+		}
+		s.costs = append(s.costs, req.Cost)
+		s.registered[name] = true
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{"status": "SUCCESS", "domain": name, "cost": req.Cost, "orderId": 1234})
+	default:
+		writeStandInJSON(w, http.StatusNotFound, map[string]string{"status": "ERROR", "message": "Invalid endpoint"})
+	}
+}
+
+func newStandInPorkbun(t *testing.T, cfg PorkbunConfig) checker.Registrar {
+	t.Helper()
+	p, err := NewPorkbun(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPorkbunConformance(t *testing.T) {
+	s := newPorkbunStandIn()
+	defer s.server.Close()
+
+	checkertest.RunConformance(t, func() checker.Registrar {
+		s.mu.Lock()
+		s.registered = map[string]bool{"taken.com": true}
+		s.mu.Unlock()
+		return newStandInPorkbun(t, s.config())
+	},
+		checkertest.Fixture{Domain: "free.com", Status: checker.Available, Register: checker.Owned},
+		checkertest.Fixture{Domain: "taken.com", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "premium.com", Status: checker.Available},
+		checkertest.Fixture{Domain: "unsupported.invalid", Err: true},
+	)
+}
+
+func TestPorkbunPrice(t *testing.T) {
+	s := newPorkbunStandIn()
+	defer s.server.Close()
+	p := newStandInPorkbun(t, s.config()).(checker.DetailedChecker)
+
+	if d, err := p.CheckDomainDetail("free.com"); err != nil || d.Price == nil || d.Price.Amount != 968 || d.Price.Premium {
+		t.Errorf("Expected the regular price, got %+v and '%v'", d.Price, err)
+	}
+	if d, err := p.CheckDomainDetail("premium.com"); err != nil || d.Price == nil || d.Price.Amount != 250000 || !d.Price.Premium {
+		t.Errorf("Expected the premium price, got %+v and '%v'", d.Price, err)
+	}
+}
+
+func TestPorkbunRegisterDomain(t *testing.T) {
+	s := newPorkbunStandIn()
+	defer s.server.Close()
+	p := newStandInPorkbun(t, s.config())
+
+	if st, err := p.RegisterDomain("free.com"); err != nil || st != checker.Owned {
+		t.Errorf("Expected free.com to be Owned, got %d and '%v'", st, err)
+	}
+	if st, err := p.CheckDomain("free.com"); err != nil || st != checker.Unavailable {
+		t.Errorf("Expected a registered domain to be unavailable, got %d and '%v'", st, err)
+	}
+	if _, err := p.RegisterDomain("premium.com"); err == nil {
+		t.Error("Expected premium registrations to be refused by default")
+	}
+	if len(s.costs) != 1 || s.costs[0] != 968 {
+		t.Errorf("Expected a single registration confirming the price in pennies, got %v", s.costs)
+	}
+
+	cfg := s.config()
+	cfg.AllowPremium = true
+	if st, err := newStandInPorkbun(t, cfg).RegisterDomain("premium.com"); err != nil || st != checker.Owned {
+		t.Errorf("Expected premium.com to be Owned, got %d and '%v'", st, err)
+	}
+}
+
+func TestPorkbunInvalidKey(t *testing.T) {
+	s := newPorkbunStandIn()
+	defer s.server.Close()
+	cfg := s.config()
+	cfg.SecretAPIKey = "wrong"
+
+	if _, err := newStandInPorkbun(t, cfg).CheckDomain("free.com"); err == nil || !strings.Contains(err.Error(), "Invalid API key") {
+		t.Errorf("Expected an invalid key error, got '%v'", err)
+	}
+	if _, err := NewPorkbun(PorkbunConfig{APIKey: "pk1_standin"}); err == nil {
+		t.Error("Expected an error without secret API key")
+	}
+}
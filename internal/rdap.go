@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+const (
+	// rdapBootstrapURL is the IANA bootstrap registry for RDAP services of domain names
+	rdapBootstrapURL = "https://data.iana.org/rdap/dns.json"
+	// rdapBootstrapTTL is how long the bootstrap registry is used before it is fetched again
+	rdapBootstrapTTL = 24 * time.Hour
+)
+
+// errRDAPNotFound is returned when the RDAP server does not know the domain
+var errRDAPNotFound = errors.New("domain not found")
+
+// RDAPConfig holds the settings for the RDAP lookup backend
+type RDAPConfig struct {
+	// BootstrapURL overrides the IANA bootstrap registry (RFC 7484)
+	BootstrapURL string
+	// Servers maps TLDs to RDAP base URLs, these take precedence over the bootstrap registry
+	Servers map[string]string
+	// Timeout limits the duration of a single request, defaults to 10 seconds
+	Timeout time.Duration
+}
+
+// rdapBootstrap is the bootstrap registry format from RFC 7484
+type rdapBootstrap struct {
+	Services [][][]string `json:"services"`
+}
+
+// rdapDomain holds the parts of an RDAP domain object (RFC 9083) used here
+type rdapDomain struct {
+	LDHName string   `json:"ldhName"`
+	Status  []string `json:"status"`
+	Events  []struct {
+		Action string    `json:"eventAction"`
+		Date   time.Time `json:"eventDate"`
+	} `json:"events"`
+}
+
+type rdap struct {
+	cfg  RDAPConfig
+	http *http.Client
+
+	lock     sync.Mutex
+	services map[string]string
+	loaded   time.Time
+}
+
+// Name returns the name of this registrar
+func (r *rdap) Name() string {
+	return "rdap"
+}
+
+// CheckDomain looks up the domain at the RDAP server of its registry. Domains unknown to
+// the registry are reported as Available, registered ones as Taken.
+func (r *rdap) CheckDomain(name string) (checker.Status, error) {
+	_, err := r.lookup(name)
+	switch {
+	case errors.Is(err, errRDAPNotFound):
+		return checker.Available, nil
+	case err != nil:
+		return checker.Unavailable, err
+	}
+	return checker.Taken, nil
+}
+
+// RegisterDomain is not supported, RDAP only provides registration data
+func (r *rdap) RegisterDomain(name string) (checker.Status, error) {
+	return checker.Unavailable, fmt.Errorf("RDAP can not register '%s': %w", name, checker.ErrReadOnly)
+}
+
+// Lifecycle returns the status codes, expiration and last change of the domain
+func (r *rdap) Lifecycle(name string) (checker.Lifecycle, error) {
+	d, err := r.lookup(name)
+	if errors.Is(err, errRDAPNotFound) {
+		return checker.Lifecycle{}, nil
+	}
+	if err != nil {
+		return checker.Lifecycle{}, err
+	}
+
+	l := checker.Lifecycle{Statuses: d.Status}
+	for _, e := range d.Events {
+		switch e.Action {
+		case "expiration":
+			l.Expires = e.Date
+		case "last changed":
+			l.Changed = e.Date
+		}
+	}
+	return l, nil
+}
+
+func (r *rdap) lookup(name string) (*rdapDomain, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	base, err := r.server(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(base, "/")+"/domain/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("RDAP request for '%s' failed: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errRDAPNotFound
+	default:
+		return nil, fmt.Errorf("RDAP server %s returned HTTP status %d for '%s'", req.URL.Host, resp.StatusCode, name)
+	}
+
+	var d rdapDomain
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("invalid RDAP response for '%s': %w", name, err)
+	}
+	return &d, nil
+}
+
+// server returns the RDAP base URL for the TLD of the domain
+func (r *rdap) server(name string) (string, error) {
+	tld := name[strings.LastIndex(name, ".")+1:]
+	if s, ok := r.cfg.Servers[tld]; ok {
+		return s, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.services == nil || time.Since(r.loaded) > rdapBootstrapTTL {
+		if err := r.bootstrap(); err != nil {
+			if r.services == nil {
+				return "", err
+			}
+			// an outdated registry is better than none, try again after the next period
+			r.loaded = time.Now()
+		}
+	}
+	s, ok := r.services[tld]
+	if !ok {
+		return "", fmt.Errorf("no RDAP server known for TLD '%s'", tld)
+	}
+	return s, nil
+}
+
+// bootstrap loads the bootstrap registry, the caller holds the lock
+func (r *rdap) bootstrap() error {
+	resp, err := r.http.Get(r.cfg.BootstrapURL)
+	if err != nil {
+		return fmt.Errorf("could not load RDAP bootstrap registry: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not load RDAP bootstrap registry: HTTP status %d", resp.StatusCode)
+	}
+
+	var b rdapBootstrap
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return fmt.Errorf("invalid RDAP bootstrap registry: %w", err)
+	}
+
+	services := make(map[string]string)
+	for _, s := range b.Services {
+		if len(s) != 2 || len(s[1]) == 0 {
+			continue
+		}
+		// prefer a secure service URL when the registry lists more than one
+		u := s[1][0]
+		for _, c := range s[1] {
+			if strings.HasPrefix(c, "https://") {
+				u = c
+				break
+			}
+		}
+		for _, tld := range s[0] {
+			services[strings.ToLower(tld)] = u
+		}
+	}
+	r.services = services
+	r.loaded = time.Now()
+	return nil
+}
+
+// NewRDAP returns a read-only registrar that looks up domains using RDAP
+func NewRDAP(cfg RDAPConfig) (checker.Registrar, error) {
+	if cfg.BootstrapURL == "" {
+		cfg.BootstrapURL = rdapBootstrapURL
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &rdap{
+		cfg:  cfg,
+		http: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+const rdapDropping = `{
+	"objectClassName": "domain",
+	"ldhName": "dropping.test",
+	"status": ["pending delete", "server hold"],
+	"events": [
+		{"eventAction": "registration", "eventDate": "2009-10-01T12:00:00Z"},
+		{"eventAction": "expiration", "eventDate": "2019-10-01T12:00:00Z"},
+		{"eventAction": "last changed", "eventDate": "2019-12-10T08:30:00Z"}
+	]
+}`
+
+// newRDAPStandIn serves a bootstrap registry and an RDAP server for the 'test' TLD. The
+// returned counter holds the amount of bootstrap requests.
+func newRDAPStandIn() (*httptest.Server, *int32) {
+	var bootstraps int32
+	mux := http.NewServeMux()
+	var s *httptest.Server
+	mux.HandleFunc("/dns.json", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&bootstraps, 1)
+		fmt.Fprintf(w, `{"version": "1.0", "services": [[["test", "example"], ["%s/rdap/"]]]}`, s.URL)
+	})
+	mux.HandleFunc("/rdap/domain/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rdap+json")
+		switch strings.TrimPrefix(r.URL.Path, "/rdap/domain/") {
+		case "taken.test":
+			fmt.Fprint(w, `{"objectClassName": "domain", "ldhName": "taken.test", "status": ["active"]}`)
+		case "dropping.test":
+			fmt.Fprint(w, rdapDropping)
+		case "limited.test":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errorCode": 404, "title": "Not Found"}`)
+		}
+	})
+	s = httptest.NewServer(mux)
+	return s, &bootstraps
+}
+
+func TestRDAPConformance(t *testing.T) {
+	s, _ := newRDAPStandIn()
+	defer s.Close()
+
+	checkertest.RunConformance(t, func() checker.Registrar {
+		r, _ := NewRDAP(RDAPConfig{BootstrapURL: s.URL + "/dns.json"})
+		return r
+	},
+		checkertest.Fixture{Domain: "taken.test", Status: checker.Taken},
+		checkertest.Fixture{Domain: "free.test", Status: checker.Available},
+		checkertest.Fixture{Domain: "limited.test", Err: true},
+		checkertest.Fixture{Domain: "unknown.tld", Err: true},
+	)
+}
+
+func TestRDAPLifecycle(t *testing.T) {
+	s, bootstraps := newRDAPStandIn()
+	defer s.Close()
+	r, _ := NewRDAP(RDAPConfig{BootstrapURL: s.URL + "/dns.json"})
+
+	l, err := r.(checker.LifecycleReader).Lifecycle("Dropping.TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.HasStatus(checker.StatusCodePendingDelete) {
+		t.Errorf("Expected a pending delete status, got %v", l.Statuses)
+	}
+	if !l.Expires.Equal(time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected expiration %s", l.Expires)
+	}
+	if !l.Changed.Equal(time.Date(2019, 12, 10, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected last change %s", l.Changed)
+	}
+
+	if l, err := r.(checker.LifecycleReader).Lifecycle("free.example"); err != nil || len(l.Statuses) != 0 {
+		t.Errorf("Expected an empty lifecycle for an unknown domain, got %v and '%v'", l, err)
+	}
+	if n := atomic.LoadInt32(bootstraps); n != 1 {
+		t.Errorf("Expected the bootstrap registry to be loaded once, got %d", n)
+	}
+}
+
+func TestRDAPRegisterDomain(t *testing.T) {
+	r, _ := NewRDAP(RDAPConfig{Servers: map[string]string{"test": "http://127.0.0.1:0/"}})
+	if s, err := r.RegisterDomain("free.test"); !errors.Is(err, checker.ErrReadOnly) || s != checker.Unavailable {
+		t.Errorf("Expected a read-only error, got %d and '%v'", s, err)
+	}
+}
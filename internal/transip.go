@@ -2,55 +2,201 @@ package internal
 
 import (
 	"fmt"
+	"io/ioutil"
+	"time"
 
 	checker "github.com/jaztec/domain-checker"
 	"github.com/transip/gotransip"
 	transipDomain "github.com/transip/gotransip/domain"
 )
 
+// transIPService is the TransIP SOAP service handling domain names
+const transIPService = "DomainService"
+
+// The TransIP APIs that can be selected in the configuration
+const (
+	TransIPSOAP = "soap"
+	TransIPREST = "rest"
+)
+
+// TransIPConfig holds the settings for a TransIP registrar
+type TransIPConfig struct {
+	// AccountName is the TransIP account the API key belongs to
+	AccountName string
+	// PrivateKeyPath points to the PEM encoded private key generated in the TransIP control panel
+	PrivateKeyPath string
+	// PrivateKey holds the PEM encoded private key itself, it is used instead of PrivateKeyPath
+	PrivateKey string
+	// Endpoint overrides the API endpoint, when empty the production API is used
+	Endpoint string
+	// API selects the TransIP API, TransIPSOAP (the default) or TransIPREST
+	API string
+	// TLDCacheTTL is the time the TLD details and prices are cached, defaults to a day
+	TLDCacheTTL time.Duration
+	// ReadOnly uses the read-only mode of TransIP, domains are checked but never registered,
+	// which is useful to test a setup against the production API
+	ReadOnly bool
+	// GlobalKey requests REST access tokens that may be used from any IP address instead of
+	// only from the whitelist of the account
+	GlobalKey bool
+}
+
 type transip struct {
-	client gotransip.Client
+	client *soapClient
+	tlds   *transIPTLDs
+}
+
+// Name returns the name of this registrar
+func (t *transip) Name() string {
+	return "transip"
 }
 
 // CheckDomain will consult the TransIP services and return a modified internal Status on whether
 // the domain is available for registration.
-func (t *transip) CheckDomain(n string) (s checker.Status, err error) {
-	s = checker.Unavailable
-	ts, err := transipDomain.CheckAvailability(t.client, n)
-	if err != nil {
-		return s, fmt.Errorf("check domain availability returned an error: %w", checker.NewError(t, err))
+func (t *transip) CheckDomain(n string) (checker.Status, error) {
+	d, err := t.CheckDomainDetail(n)
+	return d.Status, err
+}
+
+// CheckDomainDetail checks the availability of the domain together with the registration price
+// of its TLD. Names that violate the constraints of their TLD are rejected without asking TransIP.
+func (t *transip) CheckDomainDetail(n string) (checker.Detail, error) {
+	return transIPDetail(t, t.tlds, n, t.availability)
+}
+
+func (t *transip) availability(n string) (transipDomain.Status, error) {
+	req := &soapRequest{service: transIPService, method: "checkAvailability"}
+	req.addArgument("domainName", n)
+
+	var ts transipDomain.Status
+	err := t.client.call(req, &ts)
+	return ts, err
+}
+
+// TLDs returns the details of all TLDs TransIP offers
+func (t *transip) TLDs() ([]TransIPTLD, error) {
+	var resp struct {
+		TLDs []transipDomain.TLD `xml:"item"`
 	}
+	if err := t.client.call(&soapRequest{service: transIPService, method: "getAllTldInfos"}, &resp); err != nil {
+		return nil, fmt.Errorf("get all TLD infos returned an error: %w", err)
+	}
+	tlds := make([]TransIPTLD, 0, len(resp.TLDs))
+	for _, tld := range resp.TLDs {
+		tlds = append(tlds, transIPSOAPTLD(tld))
+	}
+	return tlds, nil
+}
 
+// transIPStatus maps the availability status of both TransIP APIs onto a Status, unknown
+// statuses are Unavailable
+func transIPStatus(ts transipDomain.Status) checker.Status {
 	switch ts {
 	case transipDomain.StatusInYourAccount:
-		s = checker.Owned
+		return checker.Owned
 	case transipDomain.StatusInternalPush:
-		s = checker.Owned
+		// the domain is in our account and could be pushed to another account
+		return checker.Owned
 	case transipDomain.StatusFree:
-		s = checker.Available
+		return checker.Available
+	case transipDomain.StatusNotFree:
+		return checker.Taken
+	case transipDomain.StatusInternalPull:
+		// the domain is in another TransIP account and can be pulled into ours
+		return checker.Transferable
 	}
-	return
+	return checker.Unavailable
 }
 
 // RegisterDomain will try and register a certain domain name at the TransIP API.
 func (t *transip) RegisterDomain(name string) (checker.Status, error) {
-	err := transipDomain.Register(t.client, transipDomain.Domain{Name: name})
-	if err != nil {
+	if t.client.mode == gotransip.APIModeReadOnly {
+		return checker.Unavailable, fmt.Errorf("TransIP can not register '%s' in read-only mode: %w", name, checker.ErrReadOnly)
+	}
+	if _, err := t.tlds.check(name, true); err != nil {
+		return checker.Unavailable, err
+	}
+	req := &soapRequest{service: transIPService, method: "register"}
+	req.addArgument("domain", transipDomain.Domain{Name: name})
+	if err := t.client.call(req, nil); err != nil {
 		return checker.Unavailable, err
 	}
 	return checker.Processing, nil
 }
 
+// RegistrationStatus follows up on a registration by looking at the action TransIP is running
+// for the domain
+func (t *transip) RegistrationStatus(name string) (checker.Status, error) {
+	req := &soapRequest{service: transIPService, method: "getCurrentDomainAction"}
+	req.addArgument("domainName", name)
+
+	var action transipDomain.ActionResult
+	if err := t.client.call(req, &action); err != nil {
+		return checker.Unavailable, fmt.Errorf("get current domain action returned an error: %w", err)
+	}
+	return transIPRegistrationStatus(t, name, action)
+}
+
+// transIPRegistrationStatus derives the registration status from the current domain action,
+// when no action is running anymore the domain should be in our account.
+func transIPRegistrationStatus(t checker.Registrar, name string, action transipDomain.ActionResult) (checker.Status, error) {
+	if action.HasFailed {
+		return checker.Unavailable, fmt.Errorf("%w: TransIP action '%s' failed: %s", checker.ErrRegistrationFailed, action.Name, action.Message)
+	}
+	if action.Name != "" {
+		return checker.Processing, nil
+	}
+
+	s, err := t.CheckDomain(name)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	if s != checker.Owned {
+		return checker.Unavailable, fmt.Errorf("%w: no action is running and the domain is not in the account", checker.ErrRegistrationFailed)
+	}
+	return s, nil
+}
+
 // NewTransIP returns a new client for site validations at TransIP
 func NewTransIP(accountName, keyPath string) (checker.Registrar, error) {
-	c, err := gotransip.NewSOAPClient(gotransip.ClientConfig{
+	return NewTransIPWithConfig(TransIPConfig{
 		AccountName:    accountName,
 		PrivateKeyPath: keyPath,
-		Mode:           gotransip.APIModeReadWrite,
 	})
+}
+
+// NewTransIPWithConfig returns a new client for site validations at TransIP using the
+// provided configuration.
+func NewTransIPWithConfig(cfg TransIPConfig) (checker.Registrar, error) {
+	key := []byte(cfg.PrivateKey)
+	if len(key) == 0 {
+		var err error
+		if key, err = ioutil.ReadFile(cfg.PrivateKeyPath); err != nil {
+			return nil, fmt.Errorf("error creating TransIP client: could not read private key: %v", err)
+		}
+	}
+	switch cfg.API {
+	case "", TransIPSOAP:
+	case TransIPREST:
+		c, err := newRESTClient(cfg.Endpoint, cfg.AccountName, cfg.ReadOnly, cfg.GlobalKey, key)
+		if err != nil {
+			return nil, fmt.Errorf("error creating TransIP client: %v", err)
+		}
+		t := &transipREST{client: c}
+		t.tlds = newTransIPTLDs(t.TLDs, cfg.TLDCacheTTL)
+		return t, nil
+	default:
+		return nil, fmt.Errorf("error creating TransIP client: unknown API '%s'", cfg.API)
+	}
+	mode := gotransip.APIModeReadWrite
+	if cfg.ReadOnly {
+		mode = gotransip.APIModeReadOnly
+	}
+	c, err := newSOAPClient(cfg.Endpoint, cfg.AccountName, mode, key)
 	if err != nil {
 		return nil, fmt.Errorf("error creating TransIP client: %v", err)
 	}
-	t := &transip{&c}
+	t := &transip{client: c}
+	t.tlds = newTransIPTLDs(t.TLDs, cfg.TLDCacheTTL)
 	return t, nil
 }
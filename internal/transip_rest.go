@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	transipDomain "github.com/transip/gotransip/domain"
+)
+
+const (
+	// transIPRESTEndpoint is the production endpoint of the TransIP REST API
+	transIPRESTEndpoint = "https://api.transip.nl/v6/"
+	// transIPTokenLifetime is the lifetime requested for access tokens
+	transIPTokenLifetime = "30 minutes"
+	// transIPTokenMargin is the time before expiry at which a token is refreshed
+	transIPTokenMargin = time.Minute
+)
+
+// restError is the error body returned by the REST API
+type restError struct {
+	StatusCode int
+	Message    string `json:"error"`
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("TransIP API returned HTTP status %d: %s", e.StatusCode, e.Message)
+}
+
+// restClient talks to the TransIP REST API and keeps an access token for it
+type restClient struct {
+	endpoint  *url.URL
+	login     string
+	key       *rsa.PrivateKey
+	readOnly  bool
+	globalKey bool
+	http      *http.Client
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// do performs the request with an access token and decodes the response into result, which
+// may be nil when no response body is expected. A rejected token is refreshed once.
+func (c *restClient) do(method, path string, body, result interface{}) error {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := c.accessToken()
+		if err != nil {
+			return err
+		}
+		err = c.request(method, path, b, token, result)
+		var restErr *restError
+		if attempt == 0 && errors.As(err, &restErr) && restErr.StatusCode == http.StatusUnauthorized {
+			c.lock.Lock()
+			if c.token == token {
+				c.token = ""
+			}
+			c.lock.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+func (c *restClient) request(method, path string, body []byte, token string, result interface{}) error {
+	u, err := c.endpoint.Parse(path)
+	if err != nil {
+		return err
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		sig, err := restSign(body, c.key)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Signature", sig)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to '%s' failed: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		e := &restError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(b, e); err != nil || e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	if result == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, result)
+}
+
+// accessToken returns the current token, a new one is requested when it is about to expire
+func (c *restClient) accessToken() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token != "" && time.Now().Add(transIPTokenMargin).Before(c.expires) {
+		return c.token, nil
+	}
+
+	nonce, err := soapNonce()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"login":           c.login,
+		"nonce":           nonce,
+		"read_only":       c.readOnly,
+		"expiration_time": transIPTokenLifetime,
+		"label":           "domain-checker " + nonce[:8],
+		"global_key":      c.globalKey,
+	})
+	if err != nil {
+		return "", err
+	}
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := c.request(http.MethodPost, "auth", b, "", &auth); err != nil {
+		return "", fmt.Errorf("could not acquire TransIP access token: %w", err)
+	}
+	expires, err := tokenExpiry(auth.Token)
+	if err != nil {
+		return "", fmt.Errorf("invalid TransIP access token: %w", err)
+	}
+	c.token, c.expires = auth.Token, expires
+	return c.token, nil
+}
+
+// tokenExpiry reads the expiry claim of a JSON web token without verifying it, the token is
+// only used to talk to the party that issued it.
+func tokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("token does not consist of three parts")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var claims struct {
+		Expires int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Expires == 0 {
+		return time.Time{}, errors.New("token has no expiry")
+	}
+	return time.Unix(claims.Expires, 0), nil
+}
+
+// restSign signs the request body for the authentication endpoint
+func restSign(body []byte, key *rsa.PrivateKey) (string, error) {
+	h := sha512.Sum512(body)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA512, h[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign request: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func newRESTClient(endpoint, login string, readOnly, globalKey bool, key []byte) (*restClient, error) {
+	if login == "" {
+		return nil, errors.New("account name is required")
+	}
+	if endpoint == "" {
+		endpoint = transIPRESTEndpoint
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint '%s': %w", endpoint, err)
+	}
+	k, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &restClient{
+		endpoint:  u,
+		login:     login,
+		key:       k,
+		readOnly:  readOnly,
+		globalKey: globalKey,
+		http:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// transipREST is the TransIP registrar on top of the REST API
+type transipREST struct {
+	client *restClient
+	tlds   *transIPTLDs
+}
+
+// Name returns the name of this registrar, it equals the SOAP one as both use the same account
+func (t *transipREST) Name() string {
+	return "transip"
+}
+
+// CheckDomain consults the availability endpoint
+func (t *transipREST) CheckDomain(name string) (checker.Status, error) {
+	d, err := t.CheckDomainDetail(name)
+	return d.Status, err
+}
+
+// CheckDomainDetail checks the availability of the domain together with the registration price
+// of its TLD. Names that violate the constraints of their TLD are rejected without asking TransIP.
+func (t *transipREST) CheckDomainDetail(name string) (checker.Detail, error) {
+	return transIPDetail(t, t.tlds, name, t.availability)
+}
+
+func (t *transipREST) availability(name string) (transipDomain.Status, error) {
+	var resp struct {
+		Availability struct {
+			Status transipDomain.Status `json:"status"`
+		} `json:"availability"`
+	}
+	err := t.client.do(http.MethodGet, "domain-availability/"+url.PathEscape(name), nil, &resp)
+	return resp.Availability.Status, err
+}
+
+// RegisterDomain orders the domain, TransIP processes the registration in the background
+func (t *transipREST) RegisterDomain(name string) (checker.Status, error) {
+	if t.client.readOnly {
+		return checker.Unavailable, fmt.Errorf("TransIP can not register '%s' in read-only mode: %w", name, checker.ErrReadOnly)
+	}
+	if _, err := t.tlds.check(name, true); err != nil {
+		return checker.Unavailable, err
+	}
+	if err := t.client.do(http.MethodPost, "domains", map[string]string{"domainName": name}, nil); err != nil {
+		return checker.Unavailable, err
+	}
+	return checker.Processing, nil
+}
+
+// restAction is the action TransIP is running for a domain
+type restAction struct {
+	Name       string `json:"name"`
+	Message    string `json:"message"`
+	HasFailure bool   `json:"hasFailure"`
+}
+
+// RegistrationStatus follows up on a registration by looking at the action TransIP is running
+// for the domain
+func (t *transipREST) RegistrationStatus(name string) (checker.Status, error) {
+	var resp struct {
+		Action restAction `json:"action"`
+	}
+	err := t.client.do(http.MethodGet, "domains/"+url.PathEscape(name)+"/actions", nil, &resp)
+	var restErr *restError
+	if errors.As(err, &restErr) && restErr.StatusCode == http.StatusNotFound {
+		// the domain is not in the account (yet), so no action is known either
+		err = nil
+	}
+	if err != nil {
+		return checker.Unavailable, fmt.Errorf("get current domain action returned an error: %w", err)
+	}
+	return transIPRegistrationStatus(t, name, transipDomain.ActionResult{
+		Name:      resp.Action.Name,
+		HasFailed: resp.Action.HasFailure,
+		Message:   resp.Action.Message,
+	})
+}
+
+// ListDomains returns the names of all domains in the account
+func (t *transipREST) ListDomains() ([]string, error) {
+	var resp struct {
+		Domains []struct {
+			Name string `json:"name"`
+		} `json:"domains"`
+	}
+	if err := t.client.do(http.MethodGet, "domains", nil, &resp); err != nil {
+		return nil, fmt.Errorf("list domains returned an error: %w", err)
+	}
+	names := make([]string, 0, len(resp.Domains))
+	for _, d := range resp.Domains {
+		names = append(names, d.Name)
+	}
+	return names, nil
+}
+
+// TLDs returns the details of all TLDs TransIP offers
+func (t *transipREST) TLDs() ([]TransIPTLD, error) {
+	var resp struct {
+		TLDs []TransIPTLD `json:"tlds"`
+	}
+	if err := t.client.do(http.MethodGet, "tlds", nil, &resp); err != nil {
+		return nil, fmt.Errorf("list TLDs returned an error: %w", err)
+	}
+	return resp.TLDs, nil
+}
+
+// TLD returns the details of a single TLD, with or without its leading dot
+func (t *transipREST) TLD(name string) (TransIPTLD, error) {
+	var resp struct {
+		TLD TransIPTLD `json:"tld"`
+	}
+	if err := t.client.do(http.MethodGet, "tlds/."+url.PathEscape(strings.TrimPrefix(name, ".")), nil, &resp); err != nil {
+		return TransIPTLD{}, fmt.Errorf("get TLD returned an error: %w", err)
+	}
+	return resp.TLD, nil
+}
@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/transip/gotransip"
+)
+
+const (
+	// transIPEndpoint is the production TransIP SOAP endpoint
+	transIPEndpoint = "https://api.transip.nl/soap/"
+
+	soapEnvelope = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ns1="http://www.transip.nl/soap" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:SOAP-ENC="http://schemas.xmlsoap.org/soap/encoding/" SOAP-ENV:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+	<SOAP-ENV:Body><ns1:%s>%s</ns1:%s></SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+)
+
+// asn1SHA512 is the DER prefix for a SHA512 digest, TransIP expects it to be part of the signed data
+var asn1SHA512 = []byte{
+	0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03,
+	0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40,
+}
+
+// soapParamsEncoder is implemented by the gotransip types that know how to encode themselves
+// into SOAP arguments and signature parameters, like domain.Domain
+type soapParamsEncoder interface {
+	EncodeParams(gotransip.ParamsContainer, string)
+	EncodeArgs(string) string
+}
+
+// soapParams keeps the signature parameters in the order they were added, TransIP
+// verifies the signature over the parameters in WSDL order.
+type soapParams struct {
+	keys   []string
+	values []interface{}
+}
+
+// Add appends a parameter, it satisfies gotransip.ParamsContainer
+func (p *soapParams) Add(k string, v interface{}) {
+	p.keys = append(p.keys, k)
+	p.values = append(p.values, v)
+}
+
+// Len returns the amount of parameters, it satisfies gotransip.ParamsContainer
+func (p *soapParams) Len() int {
+	return len(p.keys)
+}
+
+// Encode returns the query-like string the request signature is calculated over
+func (p *soapParams) Encode() string {
+	var buf bytes.Buffer
+	for i, v := range p.values {
+		if i > 0 {
+			buf.WriteString("&")
+		}
+		if v == nil {
+			continue
+		}
+		switch c := v.(type) {
+		case []string:
+			for j, s := range c {
+				if j > 0 {
+					buf.WriteString("&")
+				}
+				buf.WriteString(fmt.Sprintf("%s[%d]=%s", p.keys[i], j, soapEscape(s)))
+			}
+		case string:
+			buf.WriteString(p.keys[i] + "=" + soapEscape(c))
+		case int, int8, int16, int32, int64:
+			buf.WriteString(fmt.Sprintf("%s=%d", p.keys[i], c))
+		case bool:
+			buf.WriteString(p.keys[i] + "=")
+			if c {
+				buf.WriteString("1")
+			}
+		}
+	}
+	return buf.String()
+}
+
+func soapEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// soapRequest holds a single call to a TransIP service
+type soapRequest struct {
+	service string
+	method  string
+	params  soapParams
+	args    []string
+}
+
+// addArgument adds an argument to both the XML body and the signature parameters
+func (r *soapRequest) addArgument(key string, value interface{}) {
+	switch v := value.(type) {
+	case soapParamsEncoder:
+		r.args = append(r.args, v.EncodeArgs(key))
+		v.EncodeParams(&r.params, "")
+	case string:
+		r.params.Add(strconv.Itoa(r.params.Len()), v)
+		var buf bytes.Buffer
+		xml.EscapeText(&buf, []byte(v))
+		r.args = append(r.args, fmt.Sprintf(`<%s xsi:type="xsd:string">%s</%s>`, key, buf.String(), key))
+	}
+}
+
+func (r *soapRequest) envelope() string {
+	return fmt.Sprintf(soapEnvelope, r.method, strings.Join(r.args, ""), r.method)
+}
+
+type soapFault struct {
+	Code        string `xml:"faultcode"`
+	Description string `xml:"faultstring"`
+}
+
+func (f soapFault) Error() string {
+	return fmt.Sprintf("SOAP Fault %s: %s", f.Code, f.Description)
+}
+
+type soapResponseEnvelope struct {
+	Body struct {
+		Contents []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+type soapResponse struct {
+	Return struct {
+		InnerXML []byte `xml:",innerxml"`
+	} `xml:"return"`
+}
+
+// soapClient talks to the TransIP SOAP API, or anything that looks like it
+type soapClient struct {
+	endpoint *url.URL
+	login    string
+	mode     gotransip.APIMode
+	key      *rsa.PrivateKey
+	http     *http.Client
+}
+
+// call performs the request and decodes the returned value into result, which may be nil
+// when no return value is expected.
+func (c *soapClient) call(req *soapRequest, result interface{}) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce, err := soapNonce()
+	if err != nil {
+		return err
+	}
+
+	// the signature parameters have to be added in exactly this order
+	params := req.params
+	params.Add("__method", req.method)
+	params.Add("__service", req.service)
+	params.Add("__hostname", c.endpoint.Hostname())
+	params.Add("__timestamp", timestamp)
+	params.Add("__nonce", nonce)
+	signature, err := soapSign(&params, c.key)
+	if err != nil {
+		return err
+	}
+
+	u := *c.endpoint
+	u.RawQuery = url.Values{"service": {req.service}}.Encode()
+	httpReq, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(req.envelope()))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	for n, v := range map[string]string{
+		"login":     c.login,
+		"mode":      string(c.mode),
+		"timestamp": timestamp,
+		"nonce":     nonce,
+		"signature": signature,
+	} {
+		httpReq.AddCookie(&http.Cookie{Name: n, Value: v})
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("request to '%s' failed: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return parseSOAPResponse(b, resp.StatusCode, result)
+}
+
+func parseSOAPResponse(b []byte, statusCode int, result interface{}) error {
+	var env soapResponseEnvelope
+	if err := xml.Unmarshal(b, &env); err != nil {
+		return fmt.Errorf("invalid SOAP response with HTTP status %d: %w", statusCode, err)
+	}
+
+	var fault soapFault
+	if err := xml.Unmarshal(env.Body.Contents, &fault); err == nil && fault.Code != "" {
+		return fault
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %d", statusCode)
+	}
+
+	var sr soapResponse
+	if err := xml.Unmarshal(env.Body.Contents, &sr); err != nil {
+		return err
+	}
+	if result == nil || len(sr.Return.InnerXML) == 0 {
+		return nil
+	}
+	// the return element is wrapped so the decoder sees its contents as the root value
+	return xml.Unmarshal([]byte("<transip>"+string(sr.Return.InnerXML)+"</transip>"), result)
+}
+
+func soapNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+func soapSign(params *soapParams, key *rsa.PrivateKey) (string, error) {
+	h := sha512.Sum512([]byte(params.Encode()))
+	digest := append(append([]byte{}, asn1SHA512...), h[:]...)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.Hash(0), digest)
+	if err != nil {
+		return "", fmt.Errorf("could not sign request: %w", err)
+	}
+	return url.QueryEscape(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// parsePrivateKey reads a PEM encoded RSA private key in either PKCS8 or PKCS1 form
+func parsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("could not decode private key")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %w", err)
+	}
+	k, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is a %T, expected an RSA key", parsed)
+	}
+	return k, nil
+}
+
+func newSOAPClient(endpoint, login string, mode gotransip.APIMode, key []byte) (*soapClient, error) {
+	if login == "" {
+		return nil, errors.New("account name is required")
+	}
+	if endpoint == "" {
+		endpoint = transIPEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint '%s': %w", endpoint, err)
+	}
+	k, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &soapClient{
+		endpoint: u,
+		login:    login,
+		mode:     mode,
+		key:      k,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
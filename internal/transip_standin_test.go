@@ -0,0 +1,516 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	transipDomain "github.com/transip/gotransip/domain"
+)
+
+const standInLogin = "standin"
+
+// standInNode is a generic XML element used to pick SOAP requests apart
+type standInNode struct {
+	XMLName xml.Name
+	Content string        `xml:",chardata"`
+	Inner   []byte        `xml:",innerxml"`
+	Nodes   []standInNode `xml:",any"`
+}
+
+func (n standInNode) child(name string) (standInNode, bool) {
+	for _, c := range n.Nodes {
+		if c.XMLName.Local == name {
+			return c, true
+		}
+	}
+	return standInNode{}, false
+}
+
+// transipStandIn is a local stand-in for the TransIP DomainService. It verifies request
+// signatures and keeps scriptable state about the domains it knows about.
+type transipStandIn struct {
+	server  *httptest.Server
+	keyPath string
+	key     *rsa.PublicKey
+
+	mu       sync.Mutex
+	statuses map[string]transipDomain.Status
+	faults   map[string]bool
+	calls    map[string]int
+	actions  map[string]*transipDomain.ActionResult
+	tokens   map[string]bool
+	hold     bool
+	// readOnly records whether the last session was in read-only mode
+	readOnly bool
+	// globalKey records whether the last token was requested without the IP whitelist
+	globalKey bool
+}
+
+func newTransIPStandIn(t *testing.T) *transipStandIn {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "transip-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &transipStandIn{keyPath: f.Name(), key: &key.PublicKey}
+	s.reset()
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// close stops the server and removes the generated key
+func (s *transipStandIn) close() {
+	s.server.Close()
+	os.Remove(s.keyPath)
+}
+
+// config returns a configuration that points a TransIP registrar to this stand-in
+func (s *transipStandIn) config() TransIPConfig {
+	return TransIPConfig{
+		AccountName:    standInLogin,
+		PrivateKeyPath: s.keyPath,
+		Endpoint:       s.server.URL + "/soap/",
+	}
+}
+
+// restConfig returns a configuration that points a TransIP REST registrar to this stand-in
+func (s *transipStandIn) restConfig() TransIPConfig {
+	cfg := s.config()
+	cfg.API = TransIPREST
+	cfg.Endpoint = s.server.URL + "/v6"
+	return cfg
+}
+
+// reset forgets all state
+func (s *transipStandIn) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = make(map[string]transipDomain.Status)
+	s.faults = make(map[string]bool)
+	s.calls = make(map[string]int)
+	s.actions = make(map[string]*transipDomain.ActionResult)
+	s.tokens = make(map[string]bool)
+	s.hold = false
+	s.readOnly = false
+}
+
+// revokeTokens invalidates all access tokens handed out by the REST API
+func (s *transipStandIn) revokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// set scripts the availability status for a domain, unknown domains are 'notfree'
+func (s *transipStandIn) set(name string, status transipDomain.Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[name] = status
+}
+
+// fail makes every call concerning the domain return a SOAP fault
+func (s *transipStandIn) fail(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[name] = true
+}
+
+// holdRegistrations keeps new registrations running until they are completed or rejected,
+// by default registrations complete immediately
+func (s *transipStandIn) holdRegistrations() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hold = true
+}
+
+// complete finishes a running registration and moves the domain into the account
+func (s *transipStandIn) complete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.actions, name)
+	s.statuses[name] = transipDomain.StatusInYourAccount
+}
+
+// reject marks a running registration as failed
+func (s *transipStandIn) reject(name, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.actions[name]; ok {
+		a.HasFailed = true
+		a.Message = msg
+	}
+}
+
+// readOnlySession reports whether the last session was in read-only mode
+func (s *transipStandIn) readOnlySession() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readOnly
+}
+
+// globalKeySession reports whether the last token was requested without the IP whitelist
+func (s *transipStandIn) globalKeySession() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.globalKey
+}
+
+// called returns how often a method was called
+func (s *transipStandIn) called(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+func (s *transipStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/v6/") {
+		s.handleREST(w, r)
+		return
+	}
+	var env standInNode
+	if err := xml.NewDecoder(r.Body).Decode(&env); err != nil {
+		writeStandInFault(w, "400", "could not decode envelope: "+err.Error())
+		return
+	}
+	body, _ := env.child("Body")
+	if len(body.Nodes) != 1 {
+		writeStandInFault(w, "400", "expected exactly one method call")
+		return
+	}
+	call := body.Nodes[0]
+	method := call.XMLName.Local
+	if len(call.Nodes) == 0 && method != "getAllTldInfos" {
+		writeStandInFault(w, "400", "method "+method+" requires an argument")
+		return
+	}
+	if err := s.verify(r, method, call); err != nil {
+		writeStandInFault(w, "401", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+	if c, err := r.Cookie("mode"); err == nil {
+		s.readOnly = c.Value == "readonly"
+	}
+	if method == "getAllTldInfos" {
+		writeStandInResponse(w, method, standInSOAPTLDs())
+		return
+	}
+
+	name := call.Nodes[0].Content
+	if n, ok := call.Nodes[0].child("name"); ok {
+		name = n.Content
+	}
+	if s.faults[name] {
+		writeStandInFault(w, "100", "scripted failure for "+name)
+		return
+	}
+	status, ok := s.statuses[name]
+	if !ok {
+		status = transipDomain.StatusNotFree
+	}
+
+	switch method {
+	case "checkAvailability":
+		writeStandInResponse(w, method, `<return xsi:type="xsd:string">`+string(status)+`</return>`)
+	case "register":
+		if err := s.register(name); err != nil {
+			writeStandInFault(w, "102", err.Error())
+			return
+		}
+		writeStandInResponse(w, method, "")
+	case "getCurrentDomainAction":
+		a, ok := s.actions[name]
+		if !ok {
+			writeStandInResponse(w, method, "")
+			return
+		}
+		writeStandInResponse(w, method, fmt.Sprintf(`<return xsi:type="ns1:DomainAction"><name xsi:type="xsd:string">%s</name><hasFailed xsi:type="xsd:boolean">%t</hasFailed><message xsi:type="xsd:string">%s</message></return>`, a.Name, a.HasFailed, a.Message))
+	default:
+		writeStandInFault(w, "404", "method "+method+" is not implemented by the stand-in")
+	}
+}
+
+// register starts the registration of a free domain, the caller holds the lock
+func (s *transipStandIn) register(name string) error {
+	if status, ok := s.statuses[name]; !ok || status != transipDomain.StatusFree {
+		return fmt.Errorf("domain %s is not free", name)
+	}
+	if s.hold {
+		s.statuses[name] = transipDomain.StatusUnavailable
+		s.actions[name] = &transipDomain.ActionResult{Name: "register"}
+	} else {
+		s.statuses[name] = transipDomain.StatusInYourAccount
+	}
+	return nil
+}
+
+// verify checks the authentication cookies and the signature of every call. The parameters of
+// complex arguments are rebuilt by decoding them into their gotransip type.
+func (s *transipStandIn) verify(r *http.Request, method string, call standInNode) error {
+	cookies := make(map[string]string)
+	for _, c := range r.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	if cookies["login"] != standInLogin {
+		return fmt.Errorf("unknown login '%s'", cookies["login"])
+	}
+
+	params := &soapParams{}
+	for _, a := range call.Nodes {
+		if len(a.Nodes) == 0 {
+			params.Add(strconv.Itoa(params.Len()), a.Content)
+			continue
+		}
+		if method != "register" {
+			return fmt.Errorf("the stand-in can not verify the arguments of %s", method)
+		}
+		var d transipDomain.Domain
+		if err := xml.Unmarshal([]byte("<domain>"+string(a.Inner)+"</domain>"), &d); err != nil {
+			return fmt.Errorf("could not decode the domain: %v", err)
+		}
+		d.EncodeParams(params, "")
+	}
+	params.Add("__method", method)
+	params.Add("__service", r.URL.Query().Get("service"))
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return err
+	}
+	params.Add("__hostname", host)
+	params.Add("__timestamp", cookies["timestamp"])
+	params.Add("__nonce", cookies["nonce"])
+
+	enc, err := url.QueryUnescape(cookies["signature"])
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return err
+	}
+	h := sha512.Sum512([]byte(params.Encode()))
+	if err := rsa.VerifyPKCS1v15(s.key, crypto.Hash(0), append(append([]byte{}, asn1SHA512...), h[:]...), sig); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	return nil
+}
+
+func writeStandInResponse(w http.ResponseWriter, method, inner string) {
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ns1="http://www.transip.nl/soap" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+	<SOAP-ENV:Body><ns1:%sResponse>%s</ns1:%sResponse></SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`, method, inner, method)
+}
+
+func writeStandInFault(w http.ResponseWriter, code, msg string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+	<SOAP-ENV:Body><SOAP-ENV:Fault><faultcode>%s</faultcode><faultstring>%s</faultstring></SOAP-ENV:Fault></SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`, code, msg)
+}
+
+// standInTLDs are the TLDs known to the REST stand-in
+var standInTLDs = []TransIPTLD{
+	{Name: ".nl", Price: 399, RecurringPrice: 749, Capabilities: []string{"canRegister", "canTransferWithOwnerChange"}, MinLength: 2, MaxLength: 63, RegistrationPeriodLength: 12},
+	{Name: ".com", Price: 899, RecurringPrice: 999, Capabilities: []string{"canRegister"}, MinLength: 1, MaxLength: 63, RegistrationPeriodLength: 12},
+	{Name: ".co.uk", Price: 749, RecurringPrice: 749, Capabilities: []string{"canRegister"}, MinLength: 3, MaxLength: 63, RegistrationPeriodLength: 12},
+	{Name: ".frl", Price: 2999, RecurringPrice: 2999, Capabilities: []string{"canTransferWithOwnerChange"}, MinLength: 3, MaxLength: 63, RegistrationPeriodLength: 12},
+}
+
+// standInSOAPTLDs encodes the TLDs the way the SOAP API returns them, the SOAP API has no
+// length constraints and its prices are in euros
+func standInSOAPTLDs() string {
+	var b strings.Builder
+	b.WriteString(`<return xsi:type="ns1:ArrayOfTld">`)
+	for _, t := range standInTLDs {
+		fmt.Fprintf(&b, `<item xsi:type="ns1:Tld"><name xsi:type="xsd:string">%s</name><price xsi:type="xsd:float">%.2f</price><renewalPrice xsi:type="xsd:float">%.2f</renewalPrice><capabilities>`,
+			t.Name, float64(t.Price)/100, float64(t.RecurringPrice)/100)
+		for _, c := range t.Capabilities {
+			fmt.Fprintf(&b, `<item xsi:type="xsd:string">%s</item>`, c)
+		}
+		fmt.Fprintf(&b, `</capabilities><registrationPeriodLength xsi:type="xsd:int">%d</registrationPeriodLength></item>`, t.RegistrationPeriodLength)
+	}
+	b.WriteString(`</return>`)
+	return b.String()
+}
+
+// handleREST serves the REST API, the calls are counted under the names of the SOAP methods
+// they replace
+func (s *transipStandIn) handleREST(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v6/")
+	if path == "auth" {
+		s.auth(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		writeStandInError(w, http.StatusUnauthorized, "Your access token has been revoked.")
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	var name string
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	if s.faults[name] {
+		writeStandInError(w, http.StatusInternalServerError, "scripted failure for "+name)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && parts[0] == "domain-availability" && len(parts) == 2:
+		s.calls["checkAvailability"]++
+		status, ok := s.statuses[name]
+		if !ok {
+			status = transipDomain.StatusNotFree
+		}
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{
+			"availability": map[string]interface{}{"domainName": name, "status": status, "actions": []string{}},
+		})
+	case r.Method == http.MethodPost && path == "domains":
+		s.calls["register"]++
+		var req struct {
+			DomainName string `json:"domainName"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeStandInError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if s.faults[req.DomainName] {
+			writeStandInError(w, http.StatusInternalServerError, "scripted failure for "+req.DomainName)
+			return
+		}
+		if err := s.register(req.DomainName); err != nil {
+			writeStandInError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && path == "domains":
+		s.calls["getDomainNames"]++
+		var domains []map[string]string
+		for n, status := range s.statuses {
+			if status == transipDomain.StatusInYourAccount {
+				domains = append(domains, map[string]string{"name": n})
+			}
+		}
+		sort.Slice(domains, func(i, j int) bool { return domains[i]["name"] < domains[j]["name"] })
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{"domains": domains})
+	case r.Method == http.MethodGet && parts[0] == "domains" && len(parts) == 3 && parts[2] == "actions":
+		s.calls["getCurrentDomainAction"]++
+		a, ok := s.actions[name]
+		if !ok {
+			if s.statuses[name] != transipDomain.StatusInYourAccount {
+				writeStandInError(w, http.StatusNotFound, "Domain with name '"+name+"' not found")
+				return
+			}
+			a = &transipDomain.ActionResult{}
+		}
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{
+			"action": map[string]interface{}{"name": a.Name, "message": a.Message, "hasFailure": a.HasFailed},
+		})
+	case r.Method == http.MethodGet && path == "tlds":
+		s.calls["getAllTldInfos"]++
+		writeStandInJSON(w, http.StatusOK, map[string]interface{}{"tlds": standInTLDs})
+	case r.Method == http.MethodGet && parts[0] == "tlds" && len(parts) == 2:
+		s.calls["getTldInfo"]++
+		for _, tld := range standInTLDs {
+			if tld.Name == name {
+				writeStandInJSON(w, http.StatusOK, map[string]interface{}{"tld": tld})
+				return
+			}
+		}
+		writeStandInError(w, http.StatusNotFound, "TLD '"+name+"' not found")
+	default:
+		writeStandInError(w, http.StatusNotFound, r.Method+" "+path+" is not implemented by the stand-in")
+	}
+}
+
+// auth verifies the signed token request and hands out a token valid for 30 minutes
+func (s *transipStandIn) auth(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeStandInError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get("Signature"))
+	if err != nil {
+		writeStandInError(w, http.StatusUnauthorized, "invalid signature encoding")
+		return
+	}
+	h := sha512.Sum512(b)
+	if err := rsa.VerifyPKCS1v15(s.key, crypto.SHA512, h[:], sig); err != nil {
+		writeStandInError(w, http.StatusUnauthorized, "invalid signature: "+err.Error())
+		return
+	}
+	var req struct {
+		Login     string `json:"login"`
+		Nonce     string `json:"nonce"`
+		ReadOnly  bool   `json:"read_only"`
+		GlobalKey bool   `json:"global_key"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil || req.Login != standInLogin || req.Nonce == "" {
+		writeStandInError(w, http.StatusUnauthorized, "invalid token request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls["auth"]++
+	s.readOnly = req.ReadOnly
+	s.globalKey = req.GlobalKey
+	claims, _ := json.Marshal(map[string]interface{}{"exp": time.Now().Add(30 * time.Minute).Unix(), "jti": req.Nonce})
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"RS512"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(claims) + ".c3RhbmRpbg"
+	s.tokens[token] = true
+	writeStandInJSON(w, http.StatusCreated, map[string]string{"token": token})
+}
+
+func writeStandInJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeStandInError(w http.ResponseWriter, status int, msg string) {
+	writeStandInJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,368 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+	transipDomain "github.com/transip/gotransip/domain"
+)
+
+func newStandInTransIP(t *testing.T, cfg TransIPConfig) checker.Registrar {
+	t.Helper()
+	r, err := NewTransIPWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// forEachTransIPAPI runs the test against a stand-in for both the SOAP and the REST API
+func forEachTransIPAPI(t *testing.T, test func(t *testing.T, s *transipStandIn, cfg TransIPConfig)) {
+	for _, api := range []string{TransIPSOAP, TransIPREST} {
+		t.Run(api, func(t *testing.T) {
+			s := newTransIPStandIn(t)
+			defer s.close()
+			cfg := s.config()
+			if api == TransIPREST {
+				cfg = s.restConfig()
+			}
+			test(t, s, cfg)
+		})
+	}
+}
+
+func TestTransIPConformance(t *testing.T) {
+	forEachTransIPAPI(t, func(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+		checkertest.RunConformance(t, func() checker.Registrar {
+			s.reset()
+			s.set("free.nl", transipDomain.StatusFree)
+			s.set("notfree.nl", transipDomain.StatusNotFree)
+			s.set("owned.nl", transipDomain.StatusInYourAccount)
+			s.set("pushed.nl", transipDomain.StatusInternalPush)
+			s.set("pull.nl", transipDomain.StatusInternalPull)
+			s.set("unavailable.nl", transipDomain.StatusUnavailable)
+			s.fail("fault.nl")
+			return newStandInTransIP(t, cfg)
+		},
+			checkertest.Fixture{Domain: "free.nl", Status: checker.Available, Register: checker.Processing},
+			checkertest.Fixture{Domain: "notfree.nl", Status: checker.Taken},
+			checkertest.Fixture{Domain: "owned.nl", Status: checker.Owned},
+			checkertest.Fixture{Domain: "pushed.nl", Status: checker.Owned},
+			checkertest.Fixture{Domain: "pull.nl", Status: checker.Transferable},
+			checkertest.Fixture{Domain: "unavailable.nl", Status: checker.Unavailable},
+			checkertest.Fixture{Domain: "fault.nl", Err: true},
+		)
+	})
+}
+
+func TestTransIPRegisterDomain(t *testing.T) {
+	forEachTransIPAPI(t, testTransIPRegisterDomain)
+}
+
+func testTransIPRegisterDomain(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+	r := newStandInTransIP(t, cfg)
+	s.set("free.nl", transipDomain.StatusFree)
+
+	if st, err := r.RegisterDomain("free.nl"); err != nil || st != checker.Processing {
+		t.Fatalf("Expected Processing without error, got %d and '%v'", st, err)
+	}
+	if st, err := r.CheckDomain("free.nl"); err != nil || st != checker.Owned {
+		t.Errorf("Expected Owned after registration, got %d and '%v'", st, err)
+	}
+	if _, err := r.RegisterDomain("free.nl"); err == nil {
+		t.Error("Expected an error registering an owned domain")
+	}
+	if n := s.called("register"); n != 2 {
+		t.Errorf("Expected 2 register calls, stand-in received %d", n)
+	}
+}
+
+func TestTransIPRegistrationStatus(t *testing.T) {
+	forEachTransIPAPI(t, testTransIPRegistrationStatus)
+}
+
+func testTransIPRegistrationStatus(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+	r := newStandInTransIP(t, cfg).(checker.RegistrationStatusReader)
+	s.holdRegistrations()
+	s.set("completed.nl", transipDomain.StatusFree)
+	s.set("rejected.nl", transipDomain.StatusFree)
+
+	for _, n := range []string{"completed.nl", "rejected.nl"} {
+		if _, err := r.(checker.Registrar).RegisterDomain(n); err != nil {
+			t.Fatal(err)
+		}
+		if st, err := r.RegistrationStatus(n); err != nil || st != checker.Processing {
+			t.Errorf("Expected %s to be Processing, got %d and '%v'", n, st, err)
+		}
+	}
+
+	s.complete("completed.nl")
+	if st, err := r.RegistrationStatus("completed.nl"); err != nil || st != checker.Owned {
+		t.Errorf("Expected completed.nl to be Owned, got %d and '%v'", st, err)
+	}
+
+	s.reject("rejected.nl", "registry refused")
+	if st, err := r.RegistrationStatus("rejected.nl"); !errors.Is(err, checker.ErrRegistrationFailed) || !strings.Contains(err.Error(), "registry refused") || st != checker.Unavailable {
+		t.Errorf("Expected rejected.nl to fail, got %d and '%v'", st, err)
+	}
+}
+
+func TestTransIPInvalidConfig(t *testing.T) {
+	if _, err := NewTransIPWithConfig(TransIPConfig{AccountName: "test", PrivateKeyPath: "/does/not/exist"}); err == nil {
+		t.Error("Expected an error for a missing private key")
+	}
+}
+
+func TestTransIPUnknownAPI(t *testing.T) {
+	s := newTransIPStandIn(t)
+	defer s.close()
+	cfg := s.config()
+	cfg.API = "graphql"
+	if _, err := NewTransIPWithConfig(cfg); err == nil {
+		t.Error("Expected an error for an unknown API")
+	}
+}
+
+func TestTransIPRESTTokenRefresh(t *testing.T) {
+	s := newTransIPStandIn(t)
+	defer s.close()
+	r := newStandInTransIP(t, s.restConfig())
+	s.set("free.nl", transipDomain.StatusFree)
+
+	for i := 0; i < 3; i++ {
+		if st, err := r.CheckDomain("free.nl"); err != nil || st != checker.Available {
+			t.Fatalf("Expected Available, got %d and '%v'", st, err)
+		}
+	}
+	if n := s.called("auth"); n != 1 {
+		t.Errorf("Expected the token to be reused, stand-in handed out %d tokens", n)
+	}
+
+	s.revokeTokens()
+	if st, err := r.CheckDomain("free.nl"); err != nil || st != checker.Available {
+		t.Errorf("Expected a revoked token to be refreshed, got %d and '%v'", st, err)
+	}
+	if n := s.called("auth"); n != 2 {
+		t.Errorf("Expected a new token after revocation, stand-in handed out %d tokens", n)
+	}
+}
+
+func TestTransIPRESTGlobalKey(t *testing.T) {
+	s := newTransIPStandIn(t)
+	defer s.close()
+	s.set("free.nl", transipDomain.StatusFree)
+
+	cfg := s.restConfig()
+	if _, err := newStandInTransIP(t, cfg).CheckDomain("free.nl"); err != nil {
+		t.Fatal(err)
+	}
+	if s.globalKeySession() {
+		t.Error("Expected tokens to be bound to the IP whitelist by default")
+	}
+
+	cfg.GlobalKey = true
+	if _, err := newStandInTransIP(t, cfg).CheckDomain("free.nl"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.globalKeySession() {
+		t.Error("Expected a global key to be requested when configured")
+	}
+}
+
+func TestTransIPRESTListDomains(t *testing.T) {
+	s := newTransIPStandIn(t)
+	defer s.close()
+	r := newStandInTransIP(t, s.restConfig())
+	s.set("b.nl", transipDomain.StatusInYourAccount)
+	s.set("a.nl", transipDomain.StatusInYourAccount)
+	s.set("free.nl", transipDomain.StatusFree)
+
+	names, err := r.(checker.DomainLister).ListDomains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "a.nl" || names[1] != "b.nl" {
+		t.Errorf("Expected the domains in the account, got %v", names)
+	}
+}
+
+func TestTransIPRESTTLDs(t *testing.T) {
+	s := newTransIPStandIn(t)
+	defer s.close()
+	r := newStandInTransIP(t, s.restConfig()).(*transipREST)
+
+	tlds, err := r.TLDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlds) != len(standInTLDs) {
+		t.Errorf("Expected %d TLDs, got %d", len(standInTLDs), len(tlds))
+	}
+	tld, err := r.TLD("nl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tld.Name != ".nl" || tld.Price != 399 || tld.MinLength != 2 {
+		t.Errorf("Unexpected TLD details %+v", tld)
+	}
+	if _, err := r.TLD("invalid"); err == nil {
+		t.Error("Expected an error for an unknown TLD")
+	}
+}
+
+func TestTransIPPrice(t *testing.T) {
+	forEachTransIPAPI(t, func(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+		r := newStandInTransIP(t, cfg).(checker.DetailedChecker)
+		s.set("free.nl", transipDomain.StatusFree)
+		s.set("free.co.uk", transipDomain.StatusFree)
+
+		for name, price := range map[string]int64{"free.nl": 399, "free.co.uk": 749} {
+			d, err := r.CheckDomainDetail(name)
+			if err != nil || d.Status != checker.Available {
+				t.Fatalf("Expected %s to be Available, got %d and '%v'", name, d.Status, err)
+			}
+			if d.Price == nil || d.Price.Amount != price || d.Price.Currency != "EUR" {
+				t.Errorf("Expected %s to cost %d euro cents, got %+v", name, price, d.Price)
+			}
+		}
+		if n := s.called("getAllTldInfos"); n != 1 {
+			t.Errorf("Expected the TLD details to be cached, stand-in received %d calls", n)
+		}
+
+		cfg.TLDCacheTTL = time.Nanosecond
+		expiring := newStandInTransIP(t, cfg)
+		for i := 0; i < 2; i++ {
+			if _, err := expiring.CheckDomain("free.nl"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := s.called("getAllTldInfos"); n != 3 {
+			t.Errorf("Expected the TLD details to be fetched again after expiry, stand-in received %d calls", n)
+		}
+	})
+}
+
+func TestTransIPTLDBackoff(t *testing.T) {
+	fetches := 0
+	var err error
+	c := newTransIPTLDs(func() ([]TransIPTLD, error) {
+		fetches++
+		return []TransIPTLD{{Name: ".nl"}}, err
+	}, time.Nanosecond)
+	if _, err := c.check("example.nl", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// while the API fails the expired details are used without asking again
+	err = errors.New("API unavailable")
+	for i := 0; i < 3; i++ {
+		if tld, _ := c.check("example.nl", false); tld == nil || tld.Name != ".nl" {
+			t.Errorf("Expected the cached details to be used, got %v", tld)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("Expected a single fetch after the failure, got %d", fetches-1)
+	}
+}
+
+func TestTransIPTLDConstraints(t *testing.T) {
+	forEachTransIPAPI(t, func(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+		r := newStandInTransIP(t, cfg)
+		s.set("free.frl", transipDomain.StatusFree)
+
+		names := []string{"free.invalid"}
+		if cfg.API == TransIPREST {
+			// only the REST API publishes length constraints
+			names = append(names, "a.nl", "ab.co.uk")
+		}
+		for _, n := range names {
+			if st, err := r.CheckDomain(n); err == nil || st != checker.Unavailable {
+				t.Errorf("Expected %s to be rejected, got %d and '%v'", n, st, err)
+			}
+			if _, err := r.RegisterDomain(n); err == nil {
+				t.Errorf("Expected the registration of %s to be rejected", n)
+			}
+		}
+		if st, err := r.CheckDomain("free.frl"); err != nil || st != checker.Available {
+			t.Errorf("Expected free.frl to be Available, got %d and '%v'", st, err)
+		}
+		if _, err := r.RegisterDomain("free.frl"); err == nil {
+			t.Error("Expected the registration of a TLD without registrations to be rejected")
+		}
+		if n, m := s.called("checkAvailability"), s.called("register"); n != 1 || m != 0 {
+			t.Errorf("Expected rejected names not to reach the API, stand-in received %d checks and %d registrations", n, m)
+		}
+	})
+}
+
+func TestTransIPRawStatus(t *testing.T) {
+	forEachTransIPAPI(t, func(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+		r := newStandInTransIP(t, cfg).(checker.DetailedChecker)
+		s.set("pull.nl", transipDomain.StatusInternalPull)
+
+		if d, err := r.CheckDomainDetail("pull.nl"); err != nil || d.Raw != "internalpull" {
+			t.Errorf("Expected the TransIP status as raw value, got '%s' and '%v'", d.Raw, err)
+		}
+	})
+}
+
+func TestTransIPReadOnly(t *testing.T) {
+	forEachTransIPAPI(t, func(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+		cfg.ReadOnly = true
+		r := newStandInTransIP(t, cfg)
+		s.set("free.nl", transipDomain.StatusFree)
+
+		if st, err := r.CheckDomain("free.nl"); err != nil || st != checker.Available {
+			t.Errorf("Expected checks in read-only mode, got %d and '%v'", st, err)
+		}
+		if !s.readOnlySession() {
+			t.Error("Expected the stand-in to be used in read-only mode")
+		}
+		if st, err := r.RegisterDomain("free.nl"); !errors.Is(err, checker.ErrReadOnly) || st != checker.Unavailable {
+			t.Errorf("Expected registrations to be refused in read-only mode, got %d and '%v'", st, err)
+		}
+		if n := s.called("register"); n != 0 {
+			t.Errorf("Expected no registrations in read-only mode, stand-in received %d", n)
+		}
+	})
+}
+
+func TestTransIPKeyContents(t *testing.T) {
+	forEachTransIPAPI(t, func(t *testing.T, s *transipStandIn, cfg TransIPConfig) {
+		key, err := ioutil.ReadFile(cfg.PrivateKeyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.PrivateKeyPath = ""
+		cfg.PrivateKey = string(key)
+		s.set("free.nl", transipDomain.StatusFree)
+
+		if st, err := newStandInTransIP(t, cfg).CheckDomain("free.nl"); err != nil || st != checker.Available {
+			t.Errorf("Expected a key given as contents to be used, got %d and '%v'", st, err)
+		}
+	})
+}
+
+func TestTransIPSOAPSignedRegister(t *testing.T) {
+	s := newTransIPStandIn(t)
+	defer s.close()
+	s.set("free.nl", transipDomain.StatusFree)
+	s.set("other.nl", transipDomain.StatusFree)
+	r := newStandInTransIP(t, s.config()).(*transip)
+
+	// a body that differs from what was signed has to be refused
+	req := &soapRequest{service: transIPService, method: "register"}
+	req.addArgument("domain", transipDomain.Domain{Name: "free.nl"})
+	req.args = []string{transipDomain.Domain{Name: "other.nl"}.EncodeArgs("domain")}
+	if err := r.client.call(req, nil); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("Expected the tampered registration to be refused, got '%v'", err)
+	}
+	if n := s.called("register"); n != 0 {
+		t.Errorf("Expected no registration to be accepted, stand-in received %d", n)
+	}
+}
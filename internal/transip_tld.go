@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	transipDomain "github.com/transip/gotransip/domain"
+)
+
+// transIPTLDCacheTTL is the default time TLD details are cached, TransIP rarely changes them
+const transIPTLDCacheTTL = 24 * time.Hour
+
+// transIPTLDRetry is the time after a failed fetch of the TLD details before they are fetched
+// again, the cached details are used in the meantime
+const transIPTLDRetry = time.Minute
+
+// transIPCanRegister is the capability of TLDs that accept new registrations
+const transIPCanRegister = "canRegister"
+
+// TransIPTLD holds the registration details TransIP publishes for a top level domain
+type TransIPTLD struct {
+	// Name is the TLD including its leading dot, like ".nl"
+	Name string `json:"name"`
+	// Price and RecurringPrice are the registration and renewal prices in euro cents
+	Price          int `json:"price"`
+	RecurringPrice int `json:"recurringPrice"`
+	// Capabilities lists the supported actions, like "canRegister"
+	Capabilities []string `json:"capabilities"`
+	// MinLength and MaxLength limit the length of the label in front of the TLD, the SOAP API
+	// does not publish them so they are zero there
+	MinLength int `json:"minLength"`
+	MaxLength int `json:"maxLength"`
+	// RegistrationPeriodLength is the registration period in months
+	RegistrationPeriodLength int `json:"registrationPeriodLength"`
+}
+
+// can reports whether the TLD has the capability, TLDs without capabilities are not restricted
+func (t TransIPTLD) can(capability string) bool {
+	if len(t.Capabilities) == 0 {
+		return true
+	}
+	for _, c := range t.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// transIPSOAPTLD converts the TLD details of the SOAP API, which has its prices in euros
+func transIPSOAPTLD(t transipDomain.TLD) TransIPTLD {
+	tld := TransIPTLD{
+		Name:                     t.Name,
+		Price:                    int(math.Round(t.Price * 100)),
+		RecurringPrice:           int(math.Round(t.RenewalPrice * 100)),
+		RegistrationPeriodLength: int(t.RegistrationPeriodLength),
+	}
+	for _, c := range t.Capabilities {
+		tld.Capabilities = append(tld.Capabilities, string(c))
+	}
+	return tld
+}
+
+// transIPTLDs caches the TLD details of one of the TransIP APIs
+type transIPTLDs struct {
+	fetch func() ([]TransIPTLD, error)
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	tlds     map[string]TransIPTLD
+	fetched  time.Time
+	fetching bool
+	failed   time.Time
+	err      error
+}
+
+func newTransIPTLDs(fetch func() ([]TransIPTLD, error), ttl time.Duration) *transIPTLDs {
+	if ttl == 0 {
+		ttl = transIPTLDCacheTTL
+	}
+	return &transIPTLDs{fetch: fetch, ttl: ttl}
+}
+
+// lookup returns the details of the TLD of the domain together with the label in front of it.
+// The longest TLD TransIP offers wins, so "example.co.uk" is looked up as ".co.uk".
+func (c *transIPTLDs) lookup(name string) (TransIPTLD, string, bool, error) {
+	tlds, err := c.table()
+	if err != nil {
+		return TransIPTLD{}, "", false, err
+	}
+	for i, r := range name {
+		if r != '.' {
+			continue
+		}
+		if t, ok := tlds[name[i:]]; ok {
+			return t, name[:i], true, nil
+		}
+	}
+	return TransIPTLD{}, "", false, nil
+}
+
+// table returns the TLD details by name. Expired details are fetched again by a single caller
+// without holding the lock, the others keep using the cached details meanwhile. After a failed
+// fetch the cached details are used until transIPTLDRetry passed.
+func (c *transIPTLDs) table() (map[string]TransIPTLD, error) {
+	c.mu.Lock()
+	expired := c.tlds == nil || time.Since(c.fetched) > c.ttl
+	if !expired || c.fetching || time.Since(c.failed) < transIPTLDRetry {
+		tlds, err := c.tlds, c.err
+		c.mu.Unlock()
+		if tlds == nil && err == nil {
+			err = errors.New("TransIP TLD details are being fetched")
+		}
+		if tlds == nil {
+			return nil, err
+		}
+		return tlds, nil
+	}
+	c.fetching = true
+	c.mu.Unlock()
+
+	list, err := c.fetch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetching = false
+	if err != nil {
+		c.failed, c.err = time.Now(), err
+		if c.tlds == nil {
+			return nil, err
+		}
+		log.Printf("Could not refresh the TransIP TLD details, using the cached ones: %v", err)
+		return c.tlds, nil
+	}
+	// the map is replaced rather than changed, callers read it without the lock
+	tlds := make(map[string]TransIPTLD, len(list))
+	for _, t := range list {
+		tlds[t.Name] = t
+	}
+	c.tlds, c.fetched, c.err = tlds, time.Now(), nil
+	return tlds, nil
+}
+
+// check rejects names that violate the constraints of their TLD and returns the TLD details.
+// When the details can not be fetched the name is passed on to the API unchecked, nil is
+// returned for the TLD then.
+func (c *transIPTLDs) check(name string, register bool) (*TransIPTLD, error) {
+	tld, label, ok, err := c.lookup(name)
+	if err != nil {
+		log.Printf("TransIP TLD details are unavailable, '%s' is not checked against them: %v", name, err)
+		return nil, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("TransIP does not offer the TLD of '%s'", name)
+	}
+	if n := len(label); n < tld.MinLength || (tld.MaxLength > 0 && n > tld.MaxLength) {
+		return nil, fmt.Errorf("domain '%s' violates the length of %d to %d characters TransIP allows for %s", name, tld.MinLength, tld.MaxLength, tld.Name)
+	}
+	if register && !tld.can(transIPCanRegister) {
+		return nil, fmt.Errorf("TransIP does not offer registrations for %s", tld.Name)
+	}
+	return &tld, nil
+}
+
+// transIPDetail checks the name against its TLD before asking the availability, the
+// registration price of the TLD is reported with it
+func transIPDetail(t checker.Registrar, tlds *transIPTLDs, name string, availability func(string) (transipDomain.Status, error)) (checker.Detail, error) {
+	tld, err := tlds.check(name, false)
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	ts, err := availability(name)
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, fmt.Errorf("check domain availability returned an error: %w", checker.NewError(t, err))
+	}
+	d := checker.Detail{Status: transIPStatus(ts), Raw: string(ts)}
+	if tld != nil {
+		d.Price = &checker.Price{Amount: int64(tld.Price), Currency: "EUR"}
+	}
+	return d, nil
+}
@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+)
+
+const (
+	// whoisIANA is asked for the WHOIS server of TLDs that are not configured
+	whoisIANA = "whois.iana.org"
+	// whoisMaxResponse limits the size of a single WHOIS response
+	whoisMaxResponse = 1 << 20
+)
+
+// whoisServers are the WHOIS servers of the registries of common TLDs
+var whoisServers = map[string]string{
+	"com": "whois.verisign-grs.com",
+	"net": "whois.verisign-grs.com",
+	"org": "whois.publicinterestregistry.org",
+	"nl":  "whois.domain-registry.nl",
+	"be":  "whois.dns.be",
+	"de":  "whois.denic.de",
+	"eu":  "whois.eu",
+	"uk":  "whois.nic.uk",
+	"io":  "whois.nic.io",
+}
+
+// WhoisParser describes how the response of a WHOIS server is read. All matching is done
+// case-insensitive.
+type WhoisParser struct {
+	// Query formats the query sent for a domain, defaults to "%s"
+	Query string
+	// NotFound holds texts of which one starts a line of the response when the domain does not
+	// exist, the line may start with the domain itself, like "example.nl is free"
+	NotFound []string
+	// Status holds the line prefixes that are followed by a status code
+	Status []string
+	// Expires holds the line prefixes that are followed by the expiration date
+	Expires []string
+	// Referral holds the line prefixes that are followed by the WHOIS server of the registrar
+	Referral []string
+	// StatusMap translates registry specific statuses into EPP status codes
+	StatusMap map[string]string
+}
+
+// defaultWhoisParser reads the responses of most gTLD registries
+var defaultWhoisParser = WhoisParser{
+	Query:    "%s",
+	NotFound: []string{"no match for", "not found", "domain not found", "the queried object does not exist", "no data found", "no entries found", "status: free", "status: available"},
+	Status:   []string{"domain status:", "status:"},
+	Expires:  []string{"registry expiry date:", "expiry date:", "expiration date:", "expires:", "paid-till:"},
+	Referral: []string{"registrar whois server:", "refer:", "whois:"},
+}
+
+// whoisParsers are the parsers of registries that deviate from the default
+var whoisParsers = map[string]WhoisParser{
+	"nl": {
+		NotFound:  []string{"is free"},
+		Status:    []string{"status:"},
+		StatusMap: map[string]string{"in quarantine": checker.StatusCodeRedemptionPeriod},
+	},
+	"de": {
+		Query:    "-T dn,ace %s",
+		NotFound: []string{"status: free"},
+		Status:   []string{"status:"},
+	},
+	"eu": {
+		NotFound: []string{"status: available"},
+		Status:   []string{"status:"},
+	},
+	"be": {
+		NotFound:  []string{"status:\tavailable", "status: available"},
+		Status:    []string{"status:"},
+		StatusMap: map[string]string{"quarantine": checker.StatusCodeRedemptionPeriod},
+	},
+	"uk": {
+		NotFound: []string{"no match for"},
+		Status:   []string{"registration status:"},
+		Expires:  []string{"expiry date:"},
+	},
+}
+
+// whoisDateLayouts are the date formats WHOIS servers are known to use
+var whoisDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02-Jan-2006",
+	"2006.01.02",
+}
+
+// WhoisConfig holds the settings for the WHOIS lookup backend
+type WhoisConfig struct {
+	// Servers maps TLDs to WHOIS servers as "host" or "host:port", these are added to
+	// the built-in list. TLDs without a server are looked up at IANA.
+	Servers map[string]string
+	// Parsers maps TLDs to parsers, these replace the built-in parsers
+	Parsers map[string]WhoisParser
+	// IANA overrides the server that is asked for the WHOIS server of unknown TLDs
+	IANA string
+	// Timeout limits the duration of a single query, defaults to 10 seconds
+	Timeout time.Duration
+	// MaxReferrals limits the amount of referrals to registrar WHOIS servers that are
+	// followed, defaults to 1. Use a negative value to follow none.
+	MaxReferrals int
+}
+
+// whoisResult is the normalized result of a lookup
+type whoisResult struct {
+	status    checker.Status
+	lifecycle checker.Lifecycle
+	raw       string
+}
+
+type whois struct {
+	cfg WhoisConfig
+
+	lock    sync.Mutex
+	servers map[string]string
+}
+
+// Name returns the name of this registrar
+func (w *whois) Name() string {
+	return "whois"
+}
+
+// CheckDomain looks up the domain at the WHOIS server of its registry
+func (w *whois) CheckDomain(name string) (checker.Status, error) {
+	r, err := w.lookup(name)
+	if err != nil {
+		return checker.Unavailable, err
+	}
+	return r.status, nil
+}
+
+// CheckDomainDetail looks up the domain and returns the raw WHOIS responses with the status
+func (w *whois) CheckDomainDetail(name string) (checker.Detail, error) {
+	r, err := w.lookup(name)
+	if err != nil {
+		return checker.Detail{Status: checker.Unavailable}, err
+	}
+	return checker.Detail{Status: r.status, Raw: r.raw}, nil
+}
+
+// RegisterDomain is not supported, WHOIS only provides registration data
+func (w *whois) RegisterDomain(name string) (checker.Status, error) {
+	return checker.Unavailable, fmt.Errorf("WHOIS can not register '%s': %w", name, checker.ErrReadOnly)
+}
+
+// Lifecycle returns the status codes and expiration of the domain
+func (w *whois) Lifecycle(name string) (checker.Lifecycle, error) {
+	r, err := w.lookup(name)
+	if err != nil {
+		return checker.Lifecycle{}, err
+	}
+	return r.lifecycle, nil
+}
+
+func (w *whois) lookup(name string) (*whoisResult, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	tld := name[strings.LastIndex(name, ".")+1:]
+	server, err := w.server(tld)
+	if err != nil {
+		return nil, err
+	}
+	p := w.parser(tld)
+
+	raw, err := w.query(server, fmt.Sprintf(p.Query, name))
+	if err != nil {
+		return nil, err
+	}
+	r := &whoisResult{status: checker.Unavailable, raw: raw}
+	if p.notFound(raw, name) {
+		r.status = checker.Available
+		return r, nil
+	}
+	r.lifecycle = p.lifecycle(raw)
+
+	// thin registries only know the registrar, its WHOIS server holds the details. The
+	// registry already told the domain is registered, so a failing registrar does not
+	// fail the lookup.
+	for i := 0; i < w.cfg.MaxReferrals; i++ {
+		ref := p.value(raw, p.Referral)
+		if ref == "" || strings.EqualFold(ref, server) {
+			break
+		}
+		server = ref
+		if raw, err = w.query(server, name); err != nil {
+			break
+		}
+		r.raw += "\n" + raw
+		l := defaultWhoisParser.lifecycle(raw)
+		if len(r.lifecycle.Statuses) == 0 {
+			r.lifecycle.Statuses = l.Statuses
+		}
+		if r.lifecycle.Expires.IsZero() {
+			r.lifecycle.Expires = l.Expires
+		}
+	}
+	return r, nil
+}
+
+// server returns the WHOIS server for the TLD, unknown TLDs are looked up at IANA
+func (w *whois) server(tld string) (string, error) {
+	w.lock.Lock()
+	s, ok := w.servers[tld]
+	w.lock.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	raw, err := w.query(w.cfg.IANA, tld)
+	if err != nil {
+		return "", err
+	}
+	if s = defaultWhoisParser.value(raw, []string{"whois:", "refer:"}); s == "" {
+		return "", fmt.Errorf("no WHOIS server known for TLD '%s'", tld)
+	}
+	w.lock.Lock()
+	w.servers[tld] = s
+	w.lock.Unlock()
+	return s, nil
+}
+
+func (w *whois) parser(tld string) WhoisParser {
+	p, ok := w.cfg.Parsers[tld]
+	if !ok {
+		if p, ok = whoisParsers[tld]; !ok {
+			return defaultWhoisParser
+		}
+	}
+	if p.Query == "" {
+		p.Query = defaultWhoisParser.Query
+	}
+	if p.Expires == nil {
+		p.Expires = defaultWhoisParser.Expires
+	}
+	if p.Referral == nil {
+		p.Referral = defaultWhoisParser.Referral
+	}
+	return p
+}
+
+// query sends the query to the WHOIS server and reads the complete response (RFC 3912)
+func (w *whois) query(server, q string) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "43")
+	}
+	conn, err := net.DialTimeout("tcp", server, w.cfg.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("could not connect to WHOIS server %s: %w", server, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(w.cfg.Timeout))
+
+	if _, err := conn.Write([]byte(q + "\r\n")); err != nil {
+		return "", fmt.Errorf("could not query WHOIS server %s: %w", server, err)
+	}
+	b, err := ioutil.ReadAll(bufio.NewReader(&limitedConn{conn, whoisMaxResponse}))
+	if err != nil {
+		return "", fmt.Errorf("could not read from WHOIS server %s: %w", server, err)
+	}
+	return string(b), nil
+}
+
+// notFound reports whether the response states the domain does not exist. Only the start of
+// every line is matched, disclaimers tend to mention the same texts halfway a sentence.
+func (p WhoisParser) notFound(raw, name string) bool {
+	for _, line := range strings.Split(strings.ToLower(raw), "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, name))
+		for _, nf := range p.NotFound {
+			if strings.HasPrefix(line, nf) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// value returns the value of the first line starting with one of the prefixes
+func (p WhoisParser) value(raw string, prefixes []string) string {
+	if v := p.values(raw, prefixes); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// values returns the values of all lines starting with one of the prefixes
+func (p WhoisParser) values(raw string, prefixes []string) []string {
+	var v []string
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		l := strings.ToLower(line)
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(l, prefix) {
+				if s := strings.TrimSpace(line[len(prefix):]); s != "" {
+					v = append(v, s)
+				}
+				break
+			}
+		}
+	}
+	return v
+}
+
+func (p WhoisParser) lifecycle(raw string) checker.Lifecycle {
+	var l checker.Lifecycle
+	for _, s := range p.values(raw, p.Status) {
+		if code, ok := p.StatusMap[strings.ToLower(s)]; ok {
+			s = code
+		} else if f := strings.Fields(s); len(f) > 0 {
+			// gTLD registries follow the status code by a link explaining it
+			s = f[0]
+		}
+		l.Statuses = append(l.Statuses, s)
+	}
+	if e := p.value(raw, p.Expires); e != "" {
+		for _, layout := range whoisDateLayouts {
+			if t, err := time.Parse(layout, e); err == nil {
+				l.Expires = t
+				break
+			}
+		}
+	}
+	return l
+}
+
+// limitedConn stops reading after a maximum amount of bytes
+type limitedConn struct {
+	net.Conn
+	n int64
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, fmt.Errorf("WHOIS response exceeds %d bytes", whoisMaxResponse)
+	}
+	if int64(len(b)) > c.n {
+		b = b[:c.n]
+	}
+	n, err := c.Conn.Read(b)
+	c.n -= int64(n)
+	return n, err
+}
+
+// NewWhois returns a read-only registrar that looks up domains using WHOIS
+func NewWhois(cfg WhoisConfig) (checker.Registrar, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.IANA == "" {
+		cfg.IANA = whoisIANA
+	}
+	if cfg.MaxReferrals == 0 {
+		cfg.MaxReferrals = 1
+	}
+	servers := make(map[string]string, len(whoisServers)+len(cfg.Servers))
+	for tld, s := range whoisServers {
+		servers[tld] = s
+	}
+	for tld, s := range cfg.Servers {
+		servers[strings.ToLower(tld)] = s
+	}
+	return &whois{cfg: cfg, servers: servers}, nil
+}
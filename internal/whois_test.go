@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	checker "github.com/jaztec/domain-checker"
+	"github.com/jaztec/domain-checker/checkertest"
+)
+
+// whoisStandIn is a local WHOIS server replying with recorded responses
+type whoisStandIn struct {
+	l        net.Listener
+	fixtures map[string]string
+	referral string
+}
+
+// newWhoisStandIn serves the fixtures from testdata/whois keyed by query, in the fixtures
+// '{{addr}}' is replaced with referral. Unknown queries are never answered.
+func newWhoisStandIn(t *testing.T, fixtures map[string]string, referral string) *whoisStandIn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &whoisStandIn{l, fixtures, referral}
+	go s.serve()
+	return s
+}
+
+func (s *whoisStandIn) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *whoisStandIn) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			q, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return
+			}
+			f, ok := s.fixtures[strings.TrimSpace(q)]
+			if !ok {
+				time.Sleep(time.Second)
+				return
+			}
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "whois", f))
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(strings.Replace(string(b), "{{addr}}", s.referral, -1)))
+		}()
+	}
+}
+
+func newStandInWhois(t *testing.T) (checker.Registrar, func()) {
+	t.Helper()
+	registrar := newWhoisStandIn(t, map[string]string{"taken.com": "registrar_taken.txt"}, "")
+	registrar.referral = registrar.addr()
+	registry := newWhoisStandIn(t, map[string]string{
+		"taken.com":          "com_taken.txt",
+		"dropping.com":       "com_dropping.txt",
+		"free.com":           "com_free.txt",
+		"free.nl":            "nl_free.txt",
+		"quarantine.nl":      "nl_quarantine.txt",
+		"-T dn,ace taken.de": "de_taken.txt",
+		"free.test":          "test_free.txt",
+		"disclaimer.com":     "com_disclaimer.txt",
+	}, registrar.addr())
+	iana := newWhoisStandIn(t, map[string]string{"test": "iana_test.txt"}, registry.addr())
+
+	w, err := NewWhois(WhoisConfig{
+		Servers: map[string]string{"com": registry.addr(), "nl": registry.addr(), "de": registry.addr()},
+		IANA:    iana.addr(),
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, func() {
+		registrar.l.Close()
+		registry.l.Close()
+		iana.l.Close()
+	}
+}
+
+func TestWhoisConformance(t *testing.T) {
+	w, closer := newStandInWhois(t)
+	defer closer()
+
+	checkertest.RunConformance(t, func() checker.Registrar { return w },
+		checkertest.Fixture{Domain: "taken.com", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "free.com", Status: checker.Available},
+		checkertest.Fixture{Domain: "free.nl", Status: checker.Available},
+		checkertest.Fixture{Domain: "quarantine.nl", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "taken.de", Status: checker.Unavailable},
+		checkertest.Fixture{Domain: "free.test", Status: checker.Available},
+		checkertest.Fixture{Domain: "timeout.com", Err: true},
+	)
+
+	if _, err := w.RegisterDomain("free.com"); !errors.Is(err, checker.ErrReadOnly) {
+		t.Errorf("Expected a read-only error, got '%v'", err)
+	}
+}
+
+func TestWhoisDetail(t *testing.T) {
+	w, closer := newStandInWhois(t)
+	defer closer()
+
+	d, err := w.(checker.DetailedChecker).CheckDomainDetail("taken.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(d.Raw, "Registry Expiry Date") || !strings.Contains(d.Raw, "Registrant Organization: Example Inc.") {
+		t.Errorf("Expected the raw registry and registrar responses, got %s", d.Raw)
+	}
+}
+
+func TestWhoisLifecycle(t *testing.T) {
+	w, closer := newStandInWhois(t)
+	defer closer()
+	lr := w.(checker.LifecycleReader)
+
+	l, err := lr.Lifecycle("taken.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Statuses) != 2 || l.Statuses[1] != "clientTransferProhibited" {
+		t.Errorf("Unexpected statuses %v", l.Statuses)
+	}
+	if !l.Expires.Equal(time.Date(2028, 8, 13, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected expiration %s", l.Expires)
+	}
+
+	if l, _ := lr.Lifecycle("dropping.com"); !l.HasStatus(checker.StatusCodePendingDelete) {
+		t.Errorf("Expected a pending delete status, got %v", l.Statuses)
+	}
+	if l, _ := lr.Lifecycle("quarantine.nl"); !l.HasStatus(checker.StatusCodeRedemptionPeriod) {
+		t.Errorf("Expected the quarantine to be mapped onto the redemption period, got %v", l.Statuses)
+	}
+}
+
+func TestWhoisDisclaimerAndFailedReferral(t *testing.T) {
+	w, closer := newStandInWhois(t)
+	defer closer()
+
+	// the registrar never answers for this domain, the registry response is used
+	d, err := w.(checker.DetailedChecker).CheckDomainDetail("disclaimer.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != checker.Unavailable {
+		t.Errorf("Expected the disclaimer not to make the domain available, got %d", d.Status)
+	}
+	l, err := w.(checker.LifecycleReader).Lifecycle("disclaimer.com")
+	if err != nil || len(l.Statuses) != 1 || l.Statuses[0] != "clientTransferProhibited" {
+		t.Errorf("Expected the lifecycle of the registry, got %v and '%v'", l.Statuses, err)
+	}
+}
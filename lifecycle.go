@@ -0,0 +1,102 @@
+package checker
+
+import (
+	"strings"
+	"time"
+)
+
+// Registry status codes (RFC 5731 and RFC 3915) that tell where a domain is in its lifecycle
+const (
+	StatusCodeAutoRenewPeriod  = "autoRenewPeriod"
+	StatusCodeRedemptionPeriod = "redemptionPeriod"
+	StatusCodePendingRestore   = "pendingRestore"
+	StatusCodePendingDelete    = "pendingDelete"
+)
+
+// Lifecycle periods as used by most gTLD registries. Registries of ccTLDs often deviate,
+// predictions for those are rough at best.
+var (
+	// AutoRenewGracePeriod is the longest period a registrar may keep an expired domain
+	AutoRenewGracePeriod = 45 * 24 * time.Hour
+	// RedemptionPeriod is the period in which a deleted domain can still be restored
+	RedemptionPeriod = 30 * 24 * time.Hour
+	// PendingDeletePeriod is the period between redemption and the actual drop
+	PendingDeletePeriod = 5 * 24 * time.Hour
+	// DropMargin is added around a predicted drop moment to account for registry batches
+	DropMargin = 12 * time.Hour
+)
+
+// Lifecycle holds registry information about where a domain is in its lifecycle
+type Lifecycle struct {
+	// Statuses are the registry status codes reported for the domain
+	Statuses []string
+	// Expires is the expiration date of the registration, zero when unknown
+	Expires time.Time
+	// Changed is the moment the statuses were last changed, zero when unknown
+	Changed time.Time
+}
+
+// HasStatus reports whether the lifecycle holds the status code. Codes are compared without
+// case and spaces so both EPP ("pendingDelete") and RDAP ("pending delete") notations match.
+func (l Lifecycle) HasStatus(code string) bool {
+	code = normalizeStatusCode(code)
+	for _, s := range l.Statuses {
+		if normalizeStatusCode(s) == code {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeStatusCode(s string) string {
+	return strings.ToLower(strings.Replace(s, " ", "", -1))
+}
+
+// LifecycleReader is implemented by registrars that can read registry lifecycle
+// information about a domain.
+type LifecycleReader interface {
+	// Lifecycle returns the registry status codes and dates for the domain
+	Lifecycle(string) (Lifecycle, error)
+}
+
+// DropWindow is the period in which a domain is expected to be deleted by the registry
+type DropWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains reports whether t falls within the window
+func (w DropWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && !t.After(w.End)
+}
+
+// PredictDrop predicts when the domain will be deleted by the registry based on its lifecycle.
+// It returns false when no deletion is to be expected, like for domains that are not expired.
+func PredictDrop(l Lifecycle, now time.Time) (DropWindow, bool) {
+	switch {
+	case l.HasStatus(StatusCodePendingRestore):
+		// a restore is requested, the domain is not going anywhere soon
+		return DropWindow{}, false
+	case l.HasStatus(StatusCodePendingDelete):
+		if l.Changed.IsZero() {
+			return DropWindow{now, now.Add(PendingDeletePeriod + DropMargin)}, true
+		}
+		return around(l.Changed.Add(PendingDeletePeriod)), true
+	case l.HasStatus(StatusCodeRedemptionPeriod):
+		if l.Changed.IsZero() {
+			return DropWindow{now.Add(PendingDeletePeriod - DropMargin), now.Add(RedemptionPeriod + PendingDeletePeriod + DropMargin)}, true
+		}
+		return around(l.Changed.Add(RedemptionPeriod + PendingDeletePeriod)), true
+	case !l.Expires.IsZero() && l.Expires.Before(now):
+		// the registrar may delete the domain anywhere in the auto renew grace period
+		return DropWindow{
+			Start: l.Expires.Add(RedemptionPeriod + PendingDeletePeriod - DropMargin),
+			End:   l.Expires.Add(AutoRenewGracePeriod + RedemptionPeriod + PendingDeletePeriod + DropMargin),
+		}, true
+	}
+	return DropWindow{}, false
+}
+
+func around(t time.Time) DropWindow {
+	return DropWindow{t.Add(-DropMargin), t.Add(DropMargin)}
+}
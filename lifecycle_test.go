@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLifecycleHasStatus(t *testing.T) {
+	l := Lifecycle{Statuses: []string{"pending delete", "client hold"}}
+	if !l.HasStatus(StatusCodePendingDelete) {
+		t.Log("Expected the RDAP notation to match the EPP status code")
+		t.Fail()
+	}
+	if l.HasStatus(StatusCodeRedemptionPeriod) {
+		t.Log("Did not expect a redemption period status")
+		t.Fail()
+	}
+}
+
+func TestPredictDrop(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name      string
+		lifecycle Lifecycle
+		ok        bool
+		inside    time.Time
+		outside   time.Time
+	}{
+		{
+			name:      "active domain",
+			lifecycle: Lifecycle{Statuses: []string{"ok"}, Expires: now.Add(100 * day)},
+		},
+		{
+			name:      "pending delete since yesterday",
+			lifecycle: Lifecycle{Statuses: []string{StatusCodePendingDelete}, Changed: now.Add(-day)},
+			ok:        true,
+			inside:    now.Add(4 * day),
+			outside:   now.Add(2 * day),
+		},
+		{
+			name:      "pending delete since unknown",
+			lifecycle: Lifecycle{Statuses: []string{StatusCodePendingDelete}},
+			ok:        true,
+			inside:    now,
+			outside:   now.Add(6 * day),
+		},
+		{
+			name:      "redemption period",
+			lifecycle: Lifecycle{Statuses: []string{StatusCodeRedemptionPeriod}, Changed: now.Add(-10 * day)},
+			ok:        true,
+			inside:    now.Add(25 * day),
+			outside:   now.Add(20 * day),
+		},
+		{
+			name:      "pending restore",
+			lifecycle: Lifecycle{Statuses: []string{StatusCodeRedemptionPeriod, StatusCodePendingRestore}},
+			ok:        false,
+		},
+		{
+			name:      "expired",
+			lifecycle: Lifecycle{Expires: now.Add(-10 * day)},
+			ok:        true,
+			inside:    now.Add(40 * day),
+			outside:   now.Add(10 * day),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, ok := PredictDrop(tt.lifecycle, now)
+			if ok != tt.ok {
+				t.Fatalf("Expected a drop to be predicted to be %t", tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !w.Contains(tt.inside) {
+				t.Errorf("Expected %s to fall within %s - %s", tt.inside, w.Start, w.End)
+			}
+			if w.Contains(tt.outside) {
+				t.Errorf("Expected %s to fall outside %s - %s", tt.outside, w.Start, w.End)
+			}
+		})
+	}
+}
@@ -1,5 +1,10 @@
 package checker
 
+import (
+	"errors"
+	"fmt"
+)
+
 // Registrar interface defines some methods we want external services to present to us such as but not
 // limited to domain availability checks and registration
 type Registrar interface {
@@ -8,3 +13,77 @@ type Registrar interface {
 	// Register domain will try and register the domain name
 	RegisterDomain(string) (Status, error)
 }
+
+// Named can be implemented by a Registrar to report a readable name that is used in errors and
+// logging. It should be stable over restarts as it may be persisted.
+type Named interface {
+	// Name returns the name of the registrar
+	Name() string
+}
+
+// RegistrarName returns the name of the registrar. Registrars that do not implement Named are
+// named after their type.
+func RegistrarName(r Registrar) string {
+	if n, ok := r.(Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", r)
+}
+
+// ErrReadOnly is returned, possibly wrapped, by registrars that can only look up domain names,
+// like the ones based on public registry data.
+var ErrReadOnly = errors.New("registrar is read-only")
+
+// ErrRegistrationFailed is returned, possibly wrapped, by a RegistrationStatusReader when the
+// registrar reports a registration did not succeed.
+var ErrRegistrationFailed = errors.New("registration failed")
+
+// RegistrationStatusReader is implemented by registrars that can report on the progress of a
+// registration that was answered with Processing.
+type RegistrationStatusReader interface {
+	// RegistrationStatus returns Processing while the registration is still running and Owned
+	// once it completed. When the registration was rejected it returns Unavailable with an error
+	// wrapping ErrRegistrationFailed, any other error means the status could not be read.
+	RegistrationStatus(string) (Status, error)
+}
+
+// DomainLister is implemented by registrars that can list the domains held in the account
+type DomainLister interface {
+	// ListDomains returns the names of all domains in the account
+	ListDomains() ([]string, error)
+}
+
+// Detail holds the result of an availability check together with the data it was based on
+type Detail struct {
+	// Status is the status as it would be returned by CheckDomain
+	Status Status
+	// Raw is the unprocessed status or response the registrar based the status on
+	Raw string
+	// Price is the registration price the registrar asks, nil when it is not known
+	Price *Price
+}
+
+// Price is the price a registrar asks for registering a domain name
+type Price struct {
+	// Amount is the price in the smallest unit of the currency, like cents
+	Amount int64
+	// Currency is the ISO 4217 code of the currency, like "EUR"
+	Currency string
+	// Premium marks a price above the regular price for the TLD
+	Premium bool
+}
+
+// DetailedChecker is implemented by registrars that can report more about a domain than its
+// status. CheckDomain uses it when it is available.
+type DetailedChecker interface {
+	// CheckDomainDetail checks the domain like CheckDomain does
+	CheckDomainDetail(string) (Detail, error)
+}
+
+// PreFilter is a cheap stage that can be placed in front of the registrars. It rules out
+// domain names that are certainly registered so no registrar calls are spent on them.
+type PreFilter interface {
+	// Registered reports whether the domain name is certainly registered. When it is not
+	// certain it reports false and the registrars should be consulted.
+	Registered(string) (bool, error)
+}